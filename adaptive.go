@@ -0,0 +1,125 @@
+package slipstream
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/synaptecltd/encoding/bitops"
+	"github.com/synaptecltd/encoding/simple8b"
+)
+
+// MaxAdaptiveDeltaEncodingLayers is the maximum number of delta encoding layers evaluated by the adaptive encoder
+const MaxAdaptiveDeltaEncodingLayers = 4
+
+// adaptiveEncodings lists the encodings evaluated for each variable by the adaptive encoder
+var adaptiveEncodings = func() []VariableEncoding {
+	encodings := []VariableEncoding{{Raw: true}}
+	for layers := 1; layers <= MaxAdaptiveDeltaEncodingLayers; layers++ {
+		encodings = append(encodings,
+			VariableEncoding{DeltaEncodingLayers: layers},
+			VariableEncoding{DeltaEncodingLayers: layers, UseXOR: true},
+		)
+	}
+	return encodings
+}()
+
+// SetAdaptiveEncoding selects the smallest encoding for each integer variable in every message, by evaluating raw
+// values and each number of delta encoding layers (up to MaxAdaptiveDeltaEncodingLayers) using both arithmetic and
+// XOR delta. Variables with the same value for all samples in a message are only encoded once. The encoding of each
+// variable is included in the message, so it does not need to be provided to the decoder.
+func (s *Encoder) SetAdaptiveEncoding(adaptive bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.adaptive = adaptive
+	if adaptive && s.scratch64 == nil {
+		s.scratch64 = make([]int64, s.SamplesPerMessage)
+	}
+}
+
+// encodedSize returns the number of bytes needed to encode zig-zag encoded values, using either simple-8b or varint
+func (s *Encoder) encodedSize(diffs []uint64, useSimple8b bool) int {
+	if useSimple8b {
+		numberOfSimple8b, err := simple8b.EncodeAllRef(&s.simple8bValues, diffs)
+		if err != nil {
+			return math.MaxInt
+		}
+		return numberOfSimple8b * 8
+	}
+
+	size := 0
+	for _, v := range diffs {
+		size += uvarintLen(v)
+	}
+	return size
+}
+
+// encodedSize64 returns the number of bytes needed to encode zig-zag encoded int64 values
+func (s *Encoder) encodedSize64(diffs []uint64) int {
+	if !s.usingSimple8b {
+		return s.encodedSize(diffs, false)
+	}
+
+	// an encoding byte is followed by simple-8b values, or varints if any value is too large
+	for _, v := range diffs {
+		if v > simple8b.MaxValue {
+			return 1 + s.encodedSize(diffs, false)
+		}
+	}
+	return 1 + s.encodedSize(diffs, true)
+}
+
+func uvarintLen(v uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], v)
+}
+
+// chooseEncodings selects the smallest encoding for each integer variable, which is applied when the payload is
+// encoded
+func (s *Encoder) chooseEncodings() {
+	n := s.encodedSamples
+	diffs := s.int64Diffs[:n]
+
+	for i := range s.raw {
+		raw := s.raw[i][:n]
+		s.messageEncodings[i] = VariableEncoding{ElideConstant: true, Raw: true}
+		if s.constant[i] {
+			continue
+		}
+
+		bestSize := math.MaxInt
+		for _, encoding := range adaptiveEncodings {
+			values := s.scratch32[:n]
+			copy(values, raw)
+			deltaEncode32(values, encoding.layers(), encoding.UseXOR)
+			zigZagEncode32(diffs, values)
+
+			if size := s.encodedSize(diffs, s.usingSimple8b); size < bestSize {
+				bestSize = size
+				s.messageEncodings[i] = encoding
+				s.messageEncodings[i].ElideConstant = true
+			}
+		}
+	}
+
+	// int64 variables are encoded later, using the chosen encoding
+	int32Count := len(s.raw)
+	for i := range s.int64Values {
+		raw := s.int64Values[i][:n]
+		bestSize := math.MaxInt
+		for _, encoding := range adaptiveEncodings {
+			values := s.scratch64[:n]
+			copy(values, raw)
+			deltaEncode64(values, encoding.layers(), encoding.UseXOR)
+			for j, v := range values {
+				diffs[j] = bitops.ZigZagEncode64(v)
+			}
+
+			if size := s.encodedSize64(diffs); size < bestSize {
+				bestSize = size
+				s.messageEncodings[int32Count+i] = encoding
+				s.messageEncodings[int32Count+i].ElideConstant = true
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package slipstream
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrVariableCount is returned when the number of values provided does not match the variables of an encoder or
+// decoder
+var ErrVariableCount = errors.New("number of values does not match the variables")
+
+// ErrMessageInProgress is returned when encoding a complete message while samples from Encode() have not been sent
+var ErrMessageInProgress = errors.New("message is partially encoded")
+
+// EncodeMessage encodes a complete message from a slice of up to SamplesPerMessage samples, and appends it to dst. It
+// produces the same message as calling Encode() for each sample, but processes each variable in a single loop.
+func (s *Encoder) EncodeMessage(dst []byte, data []DatasetWithQuality) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.checkMessage(len(data)); err != nil {
+		return dst, err
+	}
+	int32Count := s.Int32Count + s.Int16Count
+	for j := range data {
+		d := &data[j]
+		if len(d.Int32s) != s.Int32Count || len(d.Int16s) != s.Int16Count || len(d.Int64s) != s.Int64Count ||
+			len(d.Float32s) != s.Float32Count || len(d.Float64s) != s.Float64Count || len(d.Q) != len(s.qualityHistory) {
+			return dst, ErrVariableCount
+		}
+	}
+
+	s.encodeHeader(data[0].T)
+	n := len(data)
+
+	for i := 0; i < s.Int32Count; i++ {
+		column := s.raw[i][:n]
+		if ref := s.spatialRef[i]; ref >= 0 {
+			for j := range data {
+				column[j] = data[j].Int32s[i] - data[j].Int32s[ref]
+			}
+		} else {
+			for j := range data {
+				column[j] = data[j].Int32s[i]
+			}
+		}
+		s.checkConstant(i, column)
+	}
+	for i := s.Int32Count; i < int32Count; i++ {
+		column := s.raw[i][:n]
+		for j := range data {
+			column[j] = int32(data[j].Int16s[i-s.Int32Count])
+		}
+		s.checkConstant(i, column)
+	}
+
+	for i := range s.int64Values {
+		for j := range data {
+			s.int64Values[i][j] = data[j].Int64s[i]
+		}
+	}
+	for i := 0; i < s.Float32Count; i++ {
+		for j := range data {
+			s.floatBits[i][j] = uint64(math.Float32bits(data[j].Float32s[i]))
+		}
+	}
+	for i := 0; i < s.Float64Count; i++ {
+		for j := range data {
+			s.floatBits[s.Float32Count+i][j] = math.Float64bits(data[j].Float64s[i])
+		}
+	}
+
+	q := s.scratchQ[:n]
+	for i := range s.qualityHistory {
+		for j := range data {
+			q[j] = data[j].Q[i]
+		}
+		s.encodeQualityColumn(i, q)
+	}
+
+	s.encodedSamples = n
+	return s.appendMessage(dst), nil
+}
+
+// EncodeColumns encodes a complete message from columns of values, and appends it to dst. The encoder must only have
+// int32 variables. cols and q contain the values and quality of each variable, and t0 is the timestamp of the first
+// sample. Each column must have the same length, of up to SamplesPerMessage samples.
+func (s *Encoder) EncodeColumns(dst []byte, t0 uint64, cols [][]int32, q [][]uint32) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Int16Count != 0 || s.Int64Count != 0 || s.Float32Count != 0 || s.Float64Count != 0 {
+		return dst, ErrVariableCount
+	}
+	if len(cols) != s.Int32Count || len(q) != s.Int32Count || len(cols) == 0 {
+		return dst, ErrVariableCount
+	}
+	n := len(cols[0])
+	if err := s.checkMessage(n); err != nil {
+		return dst, err
+	}
+	for i := range cols {
+		if len(cols[i]) != n || len(q[i]) != n {
+			return dst, ErrVariableCount
+		}
+	}
+
+	s.encodeHeader(t0)
+
+	for i := range cols {
+		column := s.raw[i][:n]
+		copy(column, cols[i])
+		if ref := s.spatialRef[i]; ref >= 0 {
+			for j, v := range cols[ref] {
+				column[j] -= v
+			}
+		}
+		s.checkConstant(i, column)
+	}
+	for i := range q {
+		s.encodeQualityColumn(i, q[i])
+	}
+
+	s.encodedSamples = n
+	return s.appendMessage(dst), nil
+}
+
+// checkMessage checks that a complete message with the given number of samples can be encoded
+func (s *Encoder) checkMessage(samples int) error {
+	if s.encodedSamples != 0 {
+		return ErrMessageInProgress
+	}
+	if samples < 1 || samples > s.SamplesPerMessage {
+		return ErrSampleCountOverflow
+	}
+	return nil
+}
+
+// checkConstant records whether all the values of int32 (or int16) variable i in a message are the same
+func (s *Encoder) checkConstant(i int, values []int32) {
+	s.constant[i] = true
+	s.constantValue[i] = values[0]
+	for _, v := range values {
+		if v != values[0] {
+			s.constant[i] = false
+			break
+		}
+	}
+}
+
+// encodeQualityColumn records the runs of quality values of variable i in a message
+func (s *Encoder) encodeQualityColumn(i int, q []uint32) {
+	history := s.qualityHistory[i][:1]
+	history[0] = qualityHistory{value: q[0], samples: 1}
+	last := 0
+	for _, value := range q[1:] {
+		if value == history[last].value {
+			history[last].samples++
+		} else {
+			history = append(history, qualityHistory{value: value, samples: 1})
+			last++
+		}
+	}
+	s.qualityHistory[i] = history
+}
+
+// DecodeColumns decodes a message into columns of values, rather than into Out, and returns the timestamp of the
+// first sample and the number of samples. The decoder must only have int32 variables. cols and q must contain a column
+// for each variable, with space for the number of samples in the message.
+func (s *Decoder) DecodeColumns(buf []byte, cols [][]int32, q [][]uint32) (uint64, int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Int16Count != 0 || s.Int64Count != 0 || s.Float32Count != 0 || s.Float64Count != 0 {
+		return 0, 0, ErrVariableCount
+	}
+	if len(cols) != s.Int32Count || len(q) != s.Int32Count {
+		return 0, 0, ErrVariableCount
+	}
+
+	payload, flags, err := s.decodeHeader(buf, len(buf))
+	if err != nil {
+		return 0, 0, err
+	}
+	samples := s.encodedSamples
+	for i := range cols {
+		if len(cols[i]) < samples || len(q[i]) < samples {
+			return 0, 0, ErrVariableCount
+		}
+	}
+
+	length, err := s.decodeEncodings(payload, flags, samples)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := range cols {
+		if s.elided[i] {
+			column := cols[i][:samples]
+			for j := range column {
+				column[j] = s.constants[i]
+			}
+		}
+	}
+
+	if s.usingSimple8b {
+		for i := range cols {
+			if s.elided[i] {
+				continue
+			}
+			lenB, err := s.decodeSimple8bColumn(payload[length:], cols[i][:samples])
+			if err != nil {
+				return 0, 0, err
+			}
+			length += lenB
+		}
+	} else {
+		for j := 0; j < samples; j++ {
+			for i := range cols {
+				if s.elided[i] {
+					continue
+				}
+				value, lenB := varint32(payload[length:])
+				if err := varintError(lenB); err != nil {
+					return 0, 0, err
+				}
+				length += lenB
+				cols[i][j] = value
+			}
+		}
+	}
+	for i := range cols {
+		if !s.elided[i] {
+			encoding := s.encodings[i]
+			deltaDecode32(cols[i][:samples], encoding.layers(), encoding.UseXOR)
+		}
+	}
+
+	if flags&FlagSpatialRefs != 0 {
+		for i := range cols {
+			if ref := s.spatialRef[i]; ref >= 0 {
+				column := cols[i][:samples]
+				for j, v := range cols[ref][:samples] {
+					column[j] += v
+				}
+			}
+		}
+	}
+
+	for i := range q {
+		lenB, err := decodeQualityColumn(payload[length:], q[i][:samples])
+		if err != nil {
+			return 0, 0, err
+		}
+		length += lenB
+	}
+
+	if length != len(payload) {
+		return 0, 0, ErrTrailingBytes
+	}
+	return s.startTimestamp, samples, nil
+}
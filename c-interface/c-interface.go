@@ -46,6 +46,9 @@ func NewDecoder(ID []byte, int32Count int, samplingRate int, samplesPerMessage i
 
 	dec := slipstream.NewDecoder(goUUID, int32Count, samplingRate, samplesPerMessage)
 
+	// the C interface reports the timestamp of each sample as its index within the message
+	dec.SetRelativeTimestamps(true)
+
 	decList.PushBack(dec)
 }
 
@@ -126,7 +129,8 @@ func Encode(ID []byte, T uint64, Int32s []int32, Q []uint32) (length int, data u
 	return numBytes, C.CBytes(buf)
 }
 
-// EncodeAll performs batch encoding of an entire message. The encoded message data is returned.
+// EncodeAll performs batch encoding of an entire message, using up to SamplesPerMessage samples. The encoded message
+// data is returned.
 //
 //export EncodeAll
 func EncodeAll(ID []byte, data unsafe.Pointer, length int) (lengthOut int, dataOut unsafe.Pointer) {
@@ -137,34 +141,31 @@ func EncodeAll(ID []byte, data unsafe.Pointer, length int) (lengthOut int, dataO
 		return 0, nil
 	}
 
+	if length > enc.SamplesPerMessage {
+		length = enc.SamplesPerMessage
+	}
+
 	// convert array of DatasetWithQuality "owned" by C code into Go slice
 	datasetSlice := (*[1 << 30]C.struct_DatasetWithQuality)(unsafe.Pointer(data))[:length:length]
 
+	goData := make([]slipstream.DatasetWithQuality, length)
 	for s := range datasetSlice {
 		// similar to above, convert C arrays into Go slices
-		Int32Slice := (*[1 << 30]int32)(unsafe.Pointer(datasetSlice[s].Int32s))[:enc.Int32Count:enc.Int32Count]
-		QSlice := (*[1 << 30]uint32)(unsafe.Pointer(datasetSlice[s].Q))[:enc.Int32Count:enc.Int32Count]
-
-		// assign data into Go struct
-		goData := &slipstream.DatasetWithQuality{
+		goData[s] = slipstream.DatasetWithQuality{
 			T:      uint64(datasetSlice[s].T),
-			Int32s: Int32Slice,
-			Q:      QSlice,
-		}
-
-		// encode this data sample
-		buf, numBytes, err := enc.Encode(goData)
-		if err != nil {
-			return 0, nil
+			Int32s: (*[1 << 30]int32)(unsafe.Pointer(datasetSlice[s].Int32s))[:enc.Int32Count:enc.Int32Count],
+			Q:      (*[1 << 30]uint32)(unsafe.Pointer(datasetSlice[s].Q))[:enc.Int32Count:enc.Int32Count],
 		}
+	}
 
-		if numBytes > 0 {
-			// need to use CBytes() utility function to copy bytes to C, data must be free'd later
-			return numBytes, C.CBytes(buf)
-		}
+	// encode all samples as a single message
+	buf, err := enc.EncodeMessage(nil, goData)
+	if err != nil {
+		return 0, nil
 	}
 
-	return 0, nil
+	// need to use CBytes() utility function to copy bytes to C, data must be free'd later
+	return len(buf), C.CBytes(buf)
 }
 
 // Decode performs Slipstream decoding from raw byte data. Results are stored in the Go struct, and `GetDecoded()` or `GetDecodedIndex()` should be used to access results from C.
@@ -179,7 +180,7 @@ func Decode(ID []byte, data unsafe.Pointer, length int) bool {
 	}
 
 	// encode this data sample
-	err := dec.DecodeToBuffer(unsafe.Slice((*byte)(data), length), length)
+	_, err := dec.DecodeToBuffer(unsafe.Slice((*byte)(data), length), length)
 	if err != nil {
 		return false
 	}
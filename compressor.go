@@ -0,0 +1,319 @@
+package slipstream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/fse"
+	gzip "github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/huff0"
+	"github.com/klauspost/compress/zstd"
+)
+
+// PayloadCompressor is an entropy coder which is applied to the payload of each message, after the header. A
+// compressor must be safe for concurrent use, because the same compressor can be used by many encoders and decoders.
+type PayloadCompressor interface {
+	// ID identifies the compressor in the message header
+	ID() byte
+
+	// Compress appends the compressed src to dst. An error indicates that src cannot be compressed, and the message
+	// payload is then sent uncompressed.
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress appends the decompressed src to dst. It returns ErrTrailingBytes if the decompressed size would
+	// exceed maxSize.
+	Decompress(dst, src []byte, maxSize int) ([]byte, error)
+}
+
+// Payload compressor IDs. IDs from 128 upwards are reserved for compressors registered by applications.
+const (
+	CompressorNone  = 0
+	CompressorGzip  = 1
+	CompressorZstd  = 2
+	CompressorHuff0 = 3
+	CompressorFSE   = 4
+
+	// CompressorCustom is the first ID which can be used by compressors registered by applications
+	CompressorCustom = 128
+)
+
+// Built-in payload compressors. Encoders use GzipCompression by default.
+var (
+	NoCompression    PayloadCompressor = noCompressor{}
+	GzipCompression                    = NewGzipCompressor(gzip.BestCompression)
+	ZstdCompression                    = NewZstdCompressor(3)
+	Huff0Compression PayloadCompressor = huff0Compressor{}
+	FSECompression   PayloadCompressor = fseCompressor{}
+)
+
+// ErrUnsupportedCompressor is returned when a message payload uses a compressor which has not been registered
+var ErrUnsupportedCompressor = errors.New("unsupported payload compressor in message header")
+
+// ErrCompressorID is returned when registering a compressor with a reserved ID, or with the ID of a compressor which
+// is already registered
+var ErrCompressorID = errors.New("reserved or duplicate payload compressor ID")
+
+var (
+	compressors      = map[byte]PayloadCompressor{}
+	compressorsMutex sync.RWMutex
+)
+
+func init() {
+	for _, c := range []PayloadCompressor{NoCompression, GzipCompression, ZstdCompression, Huff0Compression, FSECompression} {
+		registerCompressor(c)
+	}
+}
+
+// RegisterCompressor makes a compressor available to decoders. The ID must be at least CompressorCustom, and must not
+// be used by another registered compressor.
+func RegisterCompressor(c PayloadCompressor) error {
+	if c.ID() < CompressorCustom {
+		return ErrCompressorID
+	}
+	return registerCompressor(c)
+}
+
+// registerCompressor registers a compressor with any ID which is not already used, including the built-in IDs
+func registerCompressor(c PayloadCompressor) error {
+	compressorsMutex.Lock()
+	defer compressorsMutex.Unlock()
+	if _, ok := compressors[c.ID()]; ok {
+		return ErrCompressorID
+	}
+	compressors[c.ID()] = c
+	return nil
+}
+
+// compressorByID returns the registered compressor with the given ID, or nil
+func compressorByID(id byte) PayloadCompressor {
+	compressorsMutex.RLock()
+	defer compressorsMutex.RUnlock()
+	return compressors[id]
+}
+
+// withCapacity returns dst with space for at least n more bytes
+func withCapacity(dst []byte, n int) []byte {
+	if cap(dst)-len(dst) >= n {
+		return dst
+	}
+	return append(make([]byte, 0, len(dst)+n), dst...)
+}
+
+type noCompressor struct{}
+
+func (noCompressor) ID() byte { return CompressorNone }
+
+func (noCompressor) Compress(dst, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
+
+func (noCompressor) Decompress(dst, src []byte, maxSize int) ([]byte, error) {
+	if len(src) > maxSize {
+		return dst, ErrTrailingBytes
+	}
+	return append(dst, src...), nil
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+// gzipWriter is a reusable gzip writer, which appends to a byte slice. Creating a writer requires large allocations,
+// so each encoder has its own writer, which is reset for each message.
+type gzipWriter struct {
+	level int
+	gz    *gzip.Writer
+	out   appendWriter
+}
+
+// gzipReader is a reusable gzip reader. Each decoder has its own reader, which is reset for each message.
+type gzipReader struct {
+	gz    gzip.Reader
+	src   bytes.Reader
+	extra [1]byte
+}
+
+// appendWriter is an io.Writer which appends to a byte slice
+type appendWriter struct {
+	buf []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+// NewGzipCompressor creates a gzip compressor with the given compression level, such as gzip.BestCompression or
+// gzip.HuffmanOnly
+func NewGzipCompressor(level int) PayloadCompressor {
+	return &gzipCompressor{level: level}
+}
+
+func (c *gzipCompressor) ID() byte { return CompressorGzip }
+
+// Compress uses a new writer for each call. Encoders use their own writer instead.
+func (c *gzipCompressor) Compress(dst, src []byte) ([]byte, error) {
+	var w gzipWriter
+	return w.compress(dst, src, c.level)
+}
+
+// Decompress uses a new reader for each call. Decoders use their own reader instead.
+func (c *gzipCompressor) Decompress(dst, src []byte, maxSize int) ([]byte, error) {
+	var r gzipReader
+	return r.decompress(dst, src, maxSize)
+}
+
+// compress appends the compressed src to dst, creating the gzip writer when first used or if the level changes
+func (w *gzipWriter) compress(dst, src []byte, level int) ([]byte, error) {
+	if w.gz == nil || w.level != level {
+		gz, err := gzip.NewWriterLevel(&w.out, level)
+		if err != nil {
+			return dst, err
+		}
+		w.gz, w.level = gz, level
+	}
+
+	w.out.buf = dst
+	w.gz.Reset(&w.out)
+	_, err := w.gz.Write(src)
+	if err == nil {
+		err = w.gz.Close()
+	}
+	out := w.out.buf
+	w.out.buf = nil
+	if err != nil {
+		return dst, err
+	}
+	return out, nil
+}
+
+// decompress appends the decompressed src to dst, returning ErrTrailingBytes if it would exceed maxSize
+func (r *gzipReader) decompress(dst, src []byte, maxSize int) ([]byte, error) {
+	r.src.Reset(src)
+	if err := r.gz.Reset(&r.src); err != nil {
+		return dst, err
+	}
+
+	// limit the decompressed size, to protect against corrupt or malicious messages
+	dst = withCapacity(dst, maxSize)
+	out := dst[len(dst) : len(dst)+maxSize]
+	n := 0
+	for {
+		buf := out[n:]
+		if n == len(out) {
+			buf = r.extra[:]
+		}
+		m, err := r.gz.Read(buf)
+		if m > 0 && n == len(out) {
+			return dst, ErrTrailingBytes
+		}
+		n += m
+		if err == io.EOF {
+			return dst[:len(dst)+n], nil
+		}
+		if err != nil {
+			return dst, err
+		}
+	}
+}
+
+type zstdCompressor struct {
+	level int
+
+	// the encoder and decoder are created when first used, and are safe for concurrent use
+	encOnce sync.Once
+	enc     *zstd.Encoder
+	decOnce sync.Once
+	dec     *zstd.Decoder
+}
+
+// NewZstdCompressor creates a Zstandard compressor with the given compression level, from 1 (fastest) to 22 (best
+// compression)
+func NewZstdCompressor(level int) PayloadCompressor {
+	return &zstdCompressor{level: level}
+}
+
+func (c *zstdCompressor) ID() byte { return CompressorZstd }
+
+func (c *zstdCompressor) Compress(dst, src []byte) ([]byte, error) {
+	c.encOnce.Do(func() {
+		// the message checksum option is used instead of the zstd frame checksum
+		c.enc, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.level)), zstd.WithEncoderCRC(false))
+	})
+	return c.enc.EncodeAll(src, dst), nil
+}
+
+func (c *zstdCompressor) Decompress(dst, src []byte, maxSize int) ([]byte, error) {
+	c.decOnce.Do(func() {
+		c.dec, _ = zstd.NewReader(nil, zstd.WithDecodeAllCapLimit(true))
+	})
+
+	// the capacity of the destination limits the decompressed size
+	dst = withCapacity(dst, maxSize)
+	out, err := c.dec.DecodeAll(src, dst[:len(dst):len(dst)+maxSize])
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return dst, ErrTrailingBytes
+	}
+	if err != nil {
+		return dst, err
+	}
+	return out, nil
+}
+
+// huff0Compressor uses Huffman coding of single bytes, which is much faster than gzip or zstd, but is limited to
+// payloads of up to 256 kB
+type huff0Compressor struct{}
+
+func (huff0Compressor) ID() byte { return CompressorHuff0 }
+
+func (huff0Compressor) Compress(dst, src []byte) ([]byte, error) {
+	out, _, err := huff0.Compress1X(src, &huff0.Scratch{Reuse: huff0.ReusePolicyNone})
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, out...), nil
+}
+
+func (huff0Compressor) Decompress(dst, src []byte, maxSize int) ([]byte, error) {
+	s, remain, err := huff0.ReadTable(src, nil)
+	if err != nil {
+		return dst, err
+	}
+
+	// the capacity of the destination limits the decompressed size
+	dst = withCapacity(dst, maxSize)
+	out, err := s.Decoder().Decompress1X(dst[len(dst):len(dst):len(dst)+maxSize], remain)
+	if errors.Is(err, huff0.ErrMaxDecodedSizeExceeded) {
+		return dst, ErrTrailingBytes
+	}
+	if err != nil {
+		return dst, err
+	}
+	return dst[:len(dst)+len(out)], nil
+}
+
+// fseCompressor uses finite state entropy coding of single bytes, which is limited to payloads of up to 2 GB
+type fseCompressor struct{}
+
+func (fseCompressor) ID() byte { return CompressorFSE }
+
+func (fseCompressor) Compress(dst, src []byte) ([]byte, error) {
+	out, err := fse.Compress(src, &fse.Scratch{})
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, out...), nil
+}
+
+func (fseCompressor) Decompress(dst, src []byte, maxSize int) ([]byte, error) {
+	out, err := fse.Decompress(src, &fse.Scratch{DecompressLimit: maxSize})
+	if err != nil {
+		return dst, err
+	}
+	if len(out) > maxSize {
+		return dst, ErrTrailingBytes
+	}
+	return append(dst, out...), nil
+}
@@ -0,0 +1,360 @@
+package comtrade
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/synaptecltd/slipstream"
+)
+
+// cfgReader reads the comma-separated lines of a configuration file
+type cfgReader struct {
+	scanner *bufio.Scanner
+}
+
+func (c *cfgReader) next() ([]string, error) {
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidConfig
+	}
+
+	fields := strings.Split(strings.TrimRight(c.scanner.Text(), "\r"), ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields, nil
+}
+
+// readConfig reads a configuration file, populating all fields of the record apart from the samples. It returns the
+// number of samples, and the multiplier of the data file timestamps.
+func (r *Record) readConfig(cfg io.Reader) (int, float64, error) {
+	c := &cfgReader{scanner: bufio.NewScanner(cfg)}
+
+	fields, err := c.next()
+	if err != nil {
+		return 0, 0, err
+	}
+	r.StationName = field(fields, 0)
+	r.DeviceID = field(fields, 1)
+	r.Revision = 1991
+	if rev := field(fields, 2); rev != "" {
+		if r.Revision, err = strconv.Atoi(rev); err != nil {
+			return 0, 0, ErrInvalidConfig
+		}
+	}
+
+	// number and type of channels
+	if fields, err = c.next(); err != nil {
+		return 0, 0, err
+	}
+	if len(fields) < 3 {
+		return 0, 0, ErrInvalidConfig
+	}
+	total, errTotal := strconv.Atoi(fields[0])
+	analogCount, errAnalog := strconv.Atoi(strings.TrimSuffix(fields[1], "A"))
+	digitalCount, errDigital := strconv.Atoi(strings.TrimSuffix(fields[2], "D"))
+	if errTotal != nil || errAnalog != nil || errDigital != nil || analogCount < 0 || digitalCount < 0 || total != analogCount+digitalCount ||
+		total > slipstream.MaxVariableCount {
+		return 0, 0, ErrInvalidConfig
+	}
+
+	r.Analog = make([]AnalogChannel, analogCount)
+	for i := range r.Analog {
+		if fields, err = c.next(); err != nil {
+			return 0, 0, err
+		}
+		if len(fields) < 10 {
+			return 0, 0, ErrInvalidConfig
+		}
+		ch := &r.Analog[i]
+		ch.Name, ch.Phase, ch.CircuitComponent, ch.Unit = fields[1], fields[2], fields[3], fields[4]
+		ch.A, err = parseFloat(fields[5], err)
+		ch.B, err = parseFloat(fields[6], err)
+		ch.Skew, err = parseFloat(fields[7], err)
+		min, err := parseFloat(fields[8], err)
+		max, err := parseFloat(fields[9], err)
+		ch.Min, ch.Max = int32(min), int32(max)
+		if len(fields) >= 13 {
+			ch.Primary, err = parseFloat(fields[10], err)
+			ch.Secondary, err = parseFloat(fields[11], err)
+			ch.PS = fields[12]
+		}
+		if err != nil {
+			return 0, 0, ErrInvalidConfig
+		}
+	}
+
+	r.Digital = make([]DigitalChannel, digitalCount)
+	for i := range r.Digital {
+		if fields, err = c.next(); err != nil {
+			return 0, 0, err
+		}
+		ch := &r.Digital[i]
+		switch {
+		case len(fields) >= 5:
+			ch.Name, ch.Phase, ch.CircuitComponent = fields[1], fields[2], fields[3]
+			ch.Normal, err = strconv.Atoi(fields[4])
+		case len(fields) == 3:
+			// 1991 format
+			ch.Name = fields[1]
+			ch.Normal, err = strconv.Atoi(fields[2])
+		default:
+			return 0, 0, ErrInvalidConfig
+		}
+		if err != nil {
+			return 0, 0, ErrInvalidConfig
+		}
+	}
+
+	// line frequency
+	if fields, err = c.next(); err != nil {
+		return 0, 0, err
+	}
+	if r.LineFrequency, err = parseFloat(field(fields, 0), nil); err != nil {
+		return 0, 0, ErrInvalidConfig
+	}
+
+	// sampling rates, of which only a single rate is supported
+	if fields, err = c.next(); err != nil {
+		return 0, 0, err
+	}
+	nrates, err := strconv.Atoi(field(fields, 0))
+	if err != nil || nrates != 1 {
+		return 0, 0, ErrUnsupported
+	}
+	if fields, err = c.next(); err != nil {
+		return 0, 0, err
+	}
+	rate, err := parseFloat(field(fields, 0), nil)
+	samples, errSamples := strconv.Atoi(field(fields, 1))
+	if err != nil || errSamples != nil || samples < 0 {
+		return 0, 0, ErrInvalidConfig
+	}
+	if rate <= 0 || rate != math.Trunc(rate) {
+		return 0, 0, ErrUnsupported
+	}
+	r.SamplingRate = int(rate)
+
+	// timestamps of the first sample and trigger, which are converted using the time code for 2013 files
+	startFields, err := c.next()
+	if err != nil {
+		return 0, 0, err
+	}
+	triggerFields, err := c.next()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if fields, err = c.next(); err != nil {
+		return 0, 0, err
+	}
+	r.Format = strings.ToUpper(field(fields, 0))
+
+	// the time multiplier only applies to data file timestamps
+	timeMult := 1.0
+	if r.Revision >= 1999 {
+		if fields, err = c.next(); err != nil {
+			return 0, 0, err
+		}
+		if timeMult, err = parseFloat(field(fields, 0), nil); err != nil || timeMult <= 0 {
+			return 0, 0, ErrInvalidConfig
+		}
+	}
+
+	location := time.UTC
+	if r.Revision >= 2013 {
+		if fields, err = c.next(); err == nil {
+			r.TimeCode = field(fields, 0)
+			r.LocalCode = field(fields, 1)
+			if location, err = parseTimeCode(r.TimeCode); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	if r.Start, err = parseTimestamp(startFields, r.Revision, location); err != nil {
+		return 0, 0, err
+	}
+	if r.Trigger, err = parseTimestamp(triggerFields, r.Revision, location); err != nil {
+		return 0, 0, err
+	}
+
+	return samples, timeMult, nil
+}
+
+// writeConfig writes a 1999 or 2013 configuration file
+func (r *Record) writeConfig(cfg io.Writer) error {
+	revision := r.Revision
+	if revision != 2013 {
+		revision = 1999
+	}
+	location := time.UTC
+	if revision == 2013 {
+		var err error
+		if location, err = parseTimeCode(r.TimeCode); err != nil {
+			return err
+		}
+	}
+
+	w := bufio.NewWriter(cfg)
+	fmt.Fprintf(w, "%s,%s,%d\r\n", r.StationName, r.DeviceID, revision)
+	fmt.Fprintf(w, "%d,%dA,%dD\r\n", r.VariableCount(), len(r.Analog), len(r.Digital))
+	for i, ch := range r.Analog {
+		fmt.Fprintf(w, "%d,%s,%s,%s,%s,%s,%s,%s,%d,%d,%s,%s,%s\r\n", i+1, ch.Name, ch.Phase, ch.CircuitComponent, ch.Unit,
+			formatFloat(ch.A), formatFloat(ch.B), formatFloat(ch.Skew), ch.Min, ch.Max,
+			formatFloat(ch.Primary), formatFloat(ch.Secondary), ch.PS)
+	}
+	for i, ch := range r.Digital {
+		fmt.Fprintf(w, "%d,%s,%s,%s,%d\r\n", i+1, ch.Name, ch.Phase, ch.CircuitComponent, ch.Normal)
+	}
+	fmt.Fprintf(w, "%s\r\n", formatFloat(r.LineFrequency))
+	fmt.Fprintf(w, "1\r\n%d,%d\r\n", r.SamplingRate, len(r.Samples))
+	fmt.Fprintf(w, "%s\r\n", formatTimestamp(r.Start.In(location)))
+	fmt.Fprintf(w, "%s\r\n", formatTimestamp(r.Trigger.In(location)))
+	fmt.Fprintf(w, "%s\r\n", r.format())
+	fmt.Fprintf(w, "%d\r\n", r.timeMult())
+	if revision == 2013 {
+		timeCode := r.TimeCode
+		if timeCode == "" {
+			timeCode = "0"
+		}
+		localCode := r.LocalCode
+		if localCode == "" {
+			localCode = timeCode
+		}
+		fmt.Fprintf(w, "%s,%s\r\n", timeCode, localCode)
+		fmt.Fprintf(w, "0,0\r\n")
+	}
+	return w.Flush()
+}
+
+func (r *Record) format() string {
+	if r.Format == "" {
+		return FormatBinary32
+	}
+	return r.Format
+}
+
+func field(fields []string, i int) string {
+	if i < len(fields) {
+		return fields[i]
+	}
+	return ""
+}
+
+// parseFloat parses a number, unless a previous error has occurred. Empty fields are treated as zero.
+func parseFloat(s string, err error) (float64, error) {
+	if err != nil {
+		return 0, err
+	}
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// parseTimestamp parses a timestamp, which uses dd/mm/yyyy,hh:mm:ss.ssssss for 1999 and later, and mm/dd/yy for 1991
+func parseTimestamp(fields []string, revision int, location *time.Location) (time.Time, error) {
+	if len(fields) < 2 {
+		return time.Time{}, ErrInvalidConfig
+	}
+
+	var day, month, year int
+	date := strings.Split(fields[0], "/")
+	if len(date) != 3 {
+		return time.Time{}, ErrInvalidConfig
+	}
+	d0, err0 := strconv.Atoi(date[0])
+	d1, err1 := strconv.Atoi(date[1])
+	year, err2 := strconv.Atoi(date[2])
+	if err0 != nil || err1 != nil || err2 != nil {
+		return time.Time{}, ErrInvalidConfig
+	}
+	if revision == 1991 {
+		month, day = d0, d1
+		if year < 100 {
+			year += 1900
+			if year < 1970 {
+				year += 100
+			}
+		}
+	} else {
+		day, month = d0, d1
+	}
+
+	clock := strings.Split(fields[1], ":")
+	if len(clock) != 3 {
+		return time.Time{}, ErrInvalidConfig
+	}
+	hour, err0 := strconv.Atoi(clock[0])
+	minute, err1 := strconv.Atoi(clock[1])
+	if err0 != nil || err1 != nil {
+		return time.Time{}, ErrInvalidConfig
+	}
+
+	// seconds may have any number of decimal places, up to nanosecond resolution
+	secondParts := strings.SplitN(clock[2], ".", 2)
+	second, err := strconv.Atoi(secondParts[0])
+	if err != nil {
+		return time.Time{}, ErrInvalidConfig
+	}
+	nanoseconds := 0
+	if len(secondParts) == 2 {
+		fraction := (secondParts[1] + "000000000")[:9]
+		if nanoseconds, err = strconv.Atoi(fraction); err != nil {
+			return time.Time{}, ErrInvalidConfig
+		}
+	}
+
+	return time.Date(year, time.Month(month), day, hour, minute, second, nanoseconds, location), nil
+}
+
+// formatTimestamp formats a timestamp with microsecond resolution, or nanosecond resolution if required
+func formatTimestamp(t time.Time) string {
+	if t.Nanosecond()%1000 != 0 {
+		return t.Format("02/01/2006,15:04:05.000000000")
+	}
+	return t.Format("02/01/2006,15:04:05.000000")
+}
+
+// parseTimeCode converts a 2013 time code, such as "-5" or "+10h30", to a location
+func parseTimeCode(code string) (*time.Location, error) {
+	if code == "" || code == "x" {
+		return time.UTC, nil
+	}
+
+	sign := 1
+	s := code
+	switch s[0] {
+	case '-':
+		sign = -1
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	parts := strings.SplitN(s, "h", 2)
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, ErrInvalidConfig
+	}
+	minutes := 0
+	if len(parts) == 2 && parts[1] != "" {
+		if minutes, err = strconv.Atoi(parts[1]); err != nil {
+			return nil, ErrInvalidConfig
+		}
+	}
+
+	return time.FixedZone(code, sign*(hours*3600+minutes*60)), nil
+}
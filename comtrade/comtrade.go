@@ -0,0 +1,155 @@
+// Package comtrade converts between IEEE C37.111 (COMTRADE) records and Slipstream samples.
+//
+// Analog channels are represented by their raw integer values, so that the conversion is lossless. The value of each
+// analog channel in primary or secondary units is a*x + b, where x is the raw value. Digital channels are represented
+// by additional int32 variables, after the analog channels, with values of 0 or 1. Missing samples are given the value
+// 0 with the QualityInvalid quality.
+package comtrade
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/synaptecltd/slipstream"
+)
+
+// Data file formats
+const (
+	FormatASCII    = "ASCII"
+	FormatBinary   = "BINARY"
+	FormatBinary32 = "BINARY32"
+)
+
+// QualityInvalid is the quality of missing samples, with the invalid validity
+const QualityInvalid = uint32(slipstream.ValidityInvalid)
+
+// ErrInvalidConfig is returned when a COMTRADE configuration file is invalid
+var ErrInvalidConfig = errors.New("invalid COMTRADE configuration")
+
+// ErrInvalidData is returned when a COMTRADE data file is invalid, or does not match the configuration
+var ErrInvalidData = errors.New("invalid COMTRADE data")
+
+// ErrUnsupported is returned for COMTRADE features which are not supported, such as multiple sampling rates or the
+// FLOAT32 data format
+var ErrUnsupported = errors.New("unsupported COMTRADE feature")
+
+// AnalogChannel describes an analog channel
+type AnalogChannel struct {
+	Name             string
+	Phase            string
+	CircuitComponent string
+	Unit             string
+	A                float64 // channel multiplier
+	B                float64 // channel offset
+	Skew             float64 // time skew in microseconds
+	Min              int32
+	Max              int32
+	Primary          float64
+	Secondary        float64
+	PS               string // "P" or "S", to indicate whether values are primary or secondary
+}
+
+// Value converts a raw value to primary or secondary units
+func (c *AnalogChannel) Value(raw int32) float64 {
+	return c.A*float64(raw) + c.B
+}
+
+// DigitalChannel describes a digital (status) channel
+type DigitalChannel struct {
+	Name             string
+	Phase            string
+	CircuitComponent string
+	Normal           int // normal state, 0 or 1
+}
+
+// Record is a COMTRADE record, including the samples
+type Record struct {
+	StationName   string
+	DeviceID      string
+	Revision      int // 1991, 1999 or 2013
+	Analog        []AnalogChannel
+	Digital       []DigitalChannel
+	LineFrequency float64
+	SamplingRate  int
+	Start         time.Time // timestamp of the first sample
+	Trigger       time.Time
+	Format        string
+	TimeCode      string // 2013 only, the offset from UTC, such as "+10h30"
+	LocalCode     string // 2013 only
+
+	// Samples contain the analog channels followed by the digital channels in Int32s, with absolute timestamps
+	Samples []slipstream.DatasetWithQuality
+}
+
+// VariableCount returns the number of Slipstream variables needed for the record
+func (r *Record) VariableCount() int {
+	return len(r.Analog) + len(r.Digital)
+}
+
+// Config returns the Slipstream configuration for encoding the record. Digital channels are encoded only once per
+// message while they do not change.
+func (r *Record) Config(ID uuid.UUID, samplesPerMessage int) slipstream.Config {
+	encodings := make([]slipstream.VariableEncoding, r.VariableCount())
+	for i := range encodings {
+		encodings[i] = slipstream.VariableEncoding{DeltaEncodingLayers: slipstream.DefaultDeltaEncodingLayers}
+		if i >= len(r.Analog) {
+			encodings[i] = slipstream.VariableEncoding{Raw: true, ElideConstant: true}
+		}
+	}
+
+	return slipstream.Config{
+		ID:                ID,
+		Int32Count:        r.VariableCount(),
+		SamplingRate:      r.SamplingRate,
+		SamplesPerMessage: samplesPerMessage,
+		VariableEncodings: encodings,
+	}
+}
+
+// Channels returns the Slipstream channel descriptions for the record, for use in capture files
+func (r *Record) Channels() []slipstream.Channel {
+	channels := make([]slipstream.Channel, 0, r.VariableCount())
+	for _, ch := range r.Analog {
+		channels = append(channels, slipstream.Channel{Name: ch.Name, Unit: ch.Unit, Scale: ch.A, Offset: ch.B})
+	}
+	for _, ch := range r.Digital {
+		channels = append(channels, slipstream.Channel{Name: ch.Name, Scale: 1})
+	}
+	return channels
+}
+
+// SampleWriter accepts samples, and is implemented by slipstream.StreamWriter and slipstream.FileWriter
+type SampleWriter interface {
+	Write(data *slipstream.DatasetWithQuality) error
+}
+
+// Encode writes all samples of the record, such as to a slipstream.StreamWriter or slipstream.FileWriter
+func (r *Record) Encode(w SampleWriter) error {
+	for i := range r.Samples {
+		if err := w.Write(&r.Samples[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Append adds a copy of decoded samples, such as from slipstream.Decoder.Out, to the record. The samples must contain
+// the analog channels followed by the digital channels.
+func (r *Record) Append(samples []slipstream.DatasetWithQuality) error {
+	for i := range samples {
+		if len(samples[i].Int32s) != r.VariableCount() || len(samples[i].Q) < r.VariableCount() {
+			return ErrInvalidData
+		}
+		r.Samples = append(r.Samples, slipstream.DatasetWithQuality{
+			T:      samples[i].T,
+			Int32s: append([]int32(nil), samples[i].Int32s...),
+			Q:      append([]uint32(nil), samples[i].Q[:r.VariableCount()]...),
+		})
+	}
+
+	if len(r.Samples) > 0 {
+		r.Start = time.Unix(0, int64(r.Samples[0].T)).UTC()
+	}
+	return nil
+}
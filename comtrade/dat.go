@@ -0,0 +1,349 @@
+package comtrade
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/synaptecltd/slipstream"
+)
+
+// missing sample values for each data file format
+const (
+	missingASCII    = 99999
+	missingBinary   = math.MinInt16
+	missingBinary32 = math.MinInt32
+
+	// missingTimestamp indicates that a sample in a binary data file does not have a timestamp
+	missingTimestamp = math.MaxUint32
+)
+
+// Read reads a COMTRADE record from configuration (.cfg) and data (.dat) files. Sample timestamps are calculated from
+// the start time and sampling rate, and ErrUnsupported is returned if the timestamps in the data file are not
+// consistent with the sampling rate.
+func Read(cfg io.Reader, dat io.Reader) (*Record, error) {
+	r := &Record{}
+	samples, timeMult, err := r.readConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// the number of samples in the configuration file is untrusted, so each sample is allocated after it has been read
+	// from the data file
+	r.Samples = nil
+	switch r.Format {
+	case FormatASCII:
+		err = r.readASCII(dat, samples, timeMult)
+	case FormatBinary, FormatBinary32:
+		err = r.readBinary(dat, samples, timeMult)
+	default:
+		err = ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ReadFiles reads a COMTRADE record from the named configuration file, and the data file with the same name and the
+// .dat extension
+func ReadFiles(cfgName string) (*Record, error) {
+	cfg, err := os.Open(cfgName)
+	if err != nil {
+		return nil, err
+	}
+	defer cfg.Close()
+
+	dat, err := os.Open(datName(cfgName))
+	if err != nil {
+		return nil, err
+	}
+	defer dat.Close()
+
+	return Read(bufio.NewReader(cfg), bufio.NewReader(dat))
+}
+
+// Write writes the record as COMTRADE configuration and data files, using the 1999 revision unless the record is
+// from the 2013 revision. Timestamps in the data file are in microseconds from the first sample, or in larger units
+// for records longer than about 71 minutes.
+func (r *Record) Write(cfg io.Writer, dat io.Writer) error {
+	for i := range r.Samples {
+		if len(r.Samples[i].Int32s) != r.VariableCount() || len(r.Samples[i].Q) < r.VariableCount() {
+			return ErrInvalidData
+		}
+	}
+
+	if err := r.writeConfig(cfg); err != nil {
+		return err
+	}
+
+	switch r.format() {
+	case FormatASCII:
+		return r.writeASCII(dat)
+	case FormatBinary, FormatBinary32:
+		return r.writeBinary(dat)
+	default:
+		return ErrUnsupported
+	}
+}
+
+// WriteFiles writes the record to the named configuration file, and the data file with the same name and the .dat
+// extension
+func (r *Record) WriteFiles(cfgName string) error {
+	cfg, err := os.Create(cfgName)
+	if err != nil {
+		return err
+	}
+	defer cfg.Close()
+
+	dat, err := os.Create(datName(cfgName))
+	if err != nil {
+		return err
+	}
+	defer dat.Close()
+
+	if err := r.Write(cfg, dat); err != nil {
+		return err
+	}
+	if err := cfg.Close(); err != nil {
+		return err
+	}
+	return dat.Close()
+}
+
+// datName returns the data file name corresponding to a configuration file name, matching the case of the extension
+func datName(cfgName string) string {
+	ext := filepath.Ext(cfgName)
+	if ext == ".CFG" {
+		return strings.TrimSuffix(cfgName, ext) + ".DAT"
+	}
+	return strings.TrimSuffix(cfgName, ext) + ".dat"
+}
+
+// appendSample adds a sample, with the timestamp calculated from the start time and sampling rate
+func (r *Record) appendSample() *slipstream.DatasetWithQuality {
+	i := len(r.Samples)
+	r.Samples = append(r.Samples, slipstream.DatasetWithQuality{
+		T:      slipstream.SampleTimestamp(uint64(r.Start.UnixNano()), i, r.SamplingRate),
+		Int32s: make([]int32, r.VariableCount()),
+		Q:      make([]uint32, r.VariableCount()),
+	})
+	return &r.Samples[i]
+}
+
+// timeMult returns the multiplier of the data file timestamps, in microseconds. This is 1 unless the timestamp of the
+// last sample would not fit in the 32-bit timestamp of binary data files (which happens after about 71 minutes).
+func (r *Record) timeMult() uint64 {
+	if len(r.Samples) == 0 {
+		return 1
+	}
+	span := (r.Samples[len(r.Samples)-1].T - r.Samples[0].T + 500) / 1000
+	return span/(missingTimestamp-1) + 1
+}
+
+// timestamp returns the data file timestamp of a sample, in multiples of timeMult microseconds from the first sample
+func (r *Record) timestamp(i int, timeMult uint64) uint32 {
+	return uint32((r.Samples[i].T - r.Samples[0].T + 500*timeMult) / (1000 * timeMult))
+}
+
+// checkTimestamp checks that the data file timestamp of sample i, in multiples of timeMult microseconds, matches the
+// timestamp calculated from the sampling rate to within half a sample period
+func (r *Record) checkTimestamp(i int, timestamp uint64, timeMult float64) error {
+	expected := float64(r.Samples[i].T-r.Samples[0].T) / 1000
+	tolerance := 500_000/float64(r.SamplingRate) + timeMult
+	if math.Abs(float64(timestamp)*timeMult-expected) > tolerance {
+		return ErrUnsupported
+	}
+	return nil
+}
+
+func (r *Record) readASCII(dat io.Reader, samples int, timeMult float64) error {
+	scanner := bufio.NewScanner(dat)
+	analogCount := len(r.Analog)
+
+	for i := 0; i < samples; i++ {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+			return ErrInvalidData
+		}
+
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ",")
+		if len(fields) != 2+r.VariableCount() {
+			return ErrInvalidData
+		}
+		s := r.appendSample()
+
+		// the timestamp is optional
+		if f := strings.TrimSpace(fields[1]); f != "" {
+			timestamp, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return ErrInvalidData
+			}
+			if err := r.checkTimestamp(i, timestamp, timeMult); err != nil {
+				return err
+			}
+		}
+
+		for j, f := range fields[2:] {
+			f = strings.TrimSpace(f)
+			if f == "" || (j < analogCount && f == strconv.Itoa(missingASCII)) {
+				s.Q[j] = QualityInvalid
+				continue
+			}
+
+			v, err := strconv.ParseInt(f, 10, 32)
+			if err != nil || (j >= analogCount && v != 0 && v != 1) {
+				return ErrInvalidData
+			}
+			s.Int32s[j] = int32(v)
+		}
+	}
+
+	return nil
+}
+
+func (r *Record) writeASCII(dat io.Writer) error {
+	w := bufio.NewWriter(dat)
+	analogCount := len(r.Analog)
+	timeMult := r.timeMult()
+	var line []byte
+
+	for i := range r.Samples {
+		s := &r.Samples[i]
+		line = strconv.AppendInt(line[:0], int64(i+1), 10)
+		line = append(line, ',')
+		line = strconv.AppendUint(line, uint64(r.timestamp(i, timeMult)), 10)
+		for j, v := range s.Int32s {
+			line = append(line, ',')
+			if slipstream.Quality(s.Q[j]).Validity() == slipstream.ValidityInvalid {
+				if j < analogCount {
+					line = strconv.AppendInt(line, missingASCII, 10)
+				}
+				continue
+			}
+			line = strconv.AppendInt(line, int64(v), 10)
+		}
+		line = append(line, '\r', '\n')
+
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// binaryLayout returns the size of each analog value, and the total size of each sample
+func (r *Record) binaryLayout() (int, int) {
+	analogSize := 4
+	if r.format() == FormatBinary {
+		analogSize = 2
+	}
+	digitalWords := (len(r.Digital) + 15) / 16
+	return analogSize, 8 + analogSize*len(r.Analog) + 2*digitalWords
+}
+
+func (r *Record) readBinary(dat io.Reader, samples int, timeMult float64) error {
+	analogSize, size := r.binaryLayout()
+	analogCount := len(r.Analog)
+	buf := make([]byte, size)
+
+	for i := 0; i < samples; i++ {
+		if _, err := io.ReadFull(dat, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ErrInvalidData
+			}
+			return err
+		}
+		s := r.appendSample()
+
+		// the timestamp is optional
+		if timestamp := binary.LittleEndian.Uint32(buf[4:]); timestamp != missingTimestamp {
+			if err := r.checkTimestamp(i, uint64(timestamp), timeMult); err != nil {
+				return err
+			}
+		}
+
+		pos := 8
+		for j := 0; j < analogCount; j++ {
+			var v int32
+			var missing bool
+			if analogSize == 2 {
+				v = int32(int16(binary.LittleEndian.Uint16(buf[pos:])))
+				missing = v == missingBinary
+			} else {
+				v = int32(binary.LittleEndian.Uint32(buf[pos:]))
+				missing = v == missingBinary32
+			}
+			pos += analogSize
+
+			if missing {
+				s.Q[j] = QualityInvalid
+				continue
+			}
+			s.Int32s[j] = v
+		}
+
+		for j := range r.Digital {
+			word := binary.LittleEndian.Uint16(buf[pos+2*(j/16):])
+			s.Int32s[analogCount+j] = int32(word>>(j%16)) & 1
+		}
+	}
+
+	return nil
+}
+
+func (r *Record) writeBinary(dat io.Writer) error {
+	w := bufio.NewWriter(dat)
+	analogSize, size := r.binaryLayout()
+	analogCount := len(r.Analog)
+	timeMult := r.timeMult()
+	buf := make([]byte, size)
+
+	for i := range r.Samples {
+		s := &r.Samples[i]
+		binary.LittleEndian.PutUint32(buf[0:], uint32(i+1))
+		binary.LittleEndian.PutUint32(buf[4:], r.timestamp(i, timeMult))
+
+		pos := 8
+		for j := 0; j < analogCount; j++ {
+			v := s.Int32s[j]
+			if analogSize == 2 {
+				if slipstream.Quality(s.Q[j]).Validity() == slipstream.ValidityInvalid {
+					v = missingBinary
+				} else if v < math.MinInt16+1 || v > math.MaxInt16 {
+					return ErrInvalidData
+				}
+				binary.LittleEndian.PutUint16(buf[pos:], uint16(int16(v)))
+			} else {
+				if slipstream.Quality(s.Q[j]).Validity() == slipstream.ValidityInvalid {
+					v = missingBinary32
+				}
+				binary.LittleEndian.PutUint32(buf[pos:], uint32(v))
+			}
+			pos += analogSize
+		}
+
+		for j := pos; j < size; j++ {
+			buf[j] = 0
+		}
+		for j := range r.Digital {
+			if s.Int32s[analogCount+j] != 0 {
+				buf[pos+2*(j/16)+(j%16)/8] |= 1 << (j % 8)
+			}
+		}
+
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,370 @@
+package slipstream
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// configMagic identifies a configuration frame, and distinguishes it from an encoded data message
+var configMagic = [4]byte{'S', 'L', 'P', 'C'}
+
+// config option bits
+const (
+	configOptionXOR = 1 << iota
+	configOptionChecksum
+	configOptionAdaptive
+)
+
+// MaxSamplesPerMessage is the largest number of samples in each message
+const MaxSamplesPerMessage = 1 << 18
+
+// MaxVariableCount is the largest number of variables of all types
+const MaxVariableCount = 1 << 16
+
+// maxMessageValues limits the number of values in each message, which determines the buffer sizes of encoders and
+// decoders
+const maxMessageValues = 1 << 22
+
+// ErrInvalidConfig is returned when a configuration frame cannot be decoded or describes an unusable stream
+var ErrInvalidConfig = errors.New("invalid configuration frame")
+
+// Config describes all the parameters needed to decode a Slipstream data stream. It is similar in purpose to an
+// IEEE C37.118.2 configuration frame, and allows a decoder to be created without out-of-band knowledge of the
+// encoder settings.
+type Config struct {
+	Version                  int
+	ID                       uuid.UUID
+	Int32Count               int
+	Int16Count               int
+	Int64Count               int
+	Float32Count             int
+	Float64Count             int
+	SamplingRate             int
+	SamplesPerMessage        int
+	DeltaEncodingLayers      int
+	UseXOR                   bool
+	UseChecksum              bool
+	AdaptiveEncoding         bool
+	SpatialRefs              []int
+	VariableEncodings        []VariableEncoding // encoding of each integer variable, or nil if all are the same
+	Simple8bThresholdSamples int
+	UseGzipThresholdSamples  int
+}
+
+// Config returns the current configuration of the encoder
+func (s *Encoder) Config() Config {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	refs := make([]int, len(s.spatialRef))
+	copy(refs, s.spatialRef)
+
+	var encodings []VariableEncoding
+	if s.encodings != nil {
+		encodings = append(encodings, s.encodings...)
+	}
+
+	return Config{
+		Version:                  ProtocolVersion,
+		ID:                       s.ID,
+		Int32Count:               s.Int32Count,
+		Int16Count:               s.Int16Count,
+		Int64Count:               s.Int64Count,
+		Float32Count:             s.Float32Count,
+		Float64Count:             s.Float64Count,
+		SamplingRate:             s.SamplingRate,
+		SamplesPerMessage:        s.SamplesPerMessage,
+		DeltaEncodingLayers:      s.deltaEncodingLayers,
+		UseXOR:                   s.useXOR,
+		UseChecksum:              s.useChecksum,
+		AdaptiveEncoding:         s.adaptive,
+		SpatialRefs:              refs,
+		VariableEncodings:        encodings,
+		Simple8bThresholdSamples: s.simple8bThreshold,
+		UseGzipThresholdSamples:  s.gzipThreshold,
+	}
+}
+
+// EncodeConfig creates a configuration frame describing the encoder settings. The frame can be sent to decoders
+// before (or periodically alongside) the encoded data messages, and used with NewDecoderFromConfig().
+func (s *Encoder) EncodeConfig() []byte {
+	cfg := s.Config()
+	return cfg.Encode()
+}
+
+// Encode encodes the configuration as a configuration frame
+func (c *Config) Encode() []byte {
+	// magic, version, ID, twelve varints, options, the spatial references and the variable encodings
+	buf := make([]byte, len(configMagic)+1+len(c.ID)+12*5+1+len(c.SpatialRefs)*5+len(c.VariableEncodings))
+	length := copy(buf, configMagic[:])
+
+	buf[length] = byte(c.Version)
+	length++
+	length += copy(buf[length:], c.ID[:])
+
+	length += putUvarint32(buf[length:], uint32(c.Int32Count))
+	length += putUvarint32(buf[length:], uint32(c.Int16Count))
+	length += putUvarint32(buf[length:], uint32(c.Int64Count))
+	length += putUvarint32(buf[length:], uint32(c.Float32Count))
+	length += putUvarint32(buf[length:], uint32(c.Float64Count))
+	length += putUvarint32(buf[length:], uint32(c.SamplingRate))
+	length += putUvarint32(buf[length:], uint32(c.SamplesPerMessage))
+	length += putUvarint32(buf[length:], uint32(c.DeltaEncodingLayers))
+	length += putUvarint32(buf[length:], uint32(c.Simple8bThresholdSamples))
+	length += putUvarint32(buf[length:], uint32(c.UseGzipThresholdSamples))
+
+	var options byte
+	if c.UseXOR {
+		options |= configOptionXOR
+	}
+	if c.UseChecksum {
+		options |= configOptionChecksum
+	}
+	if c.AdaptiveEncoding {
+		options |= configOptionAdaptive
+	}
+	buf[length] = options
+	length++
+
+	length += putUvarint32(buf[length:], uint32(len(c.SpatialRefs)))
+	for _, ref := range c.SpatialRefs {
+		length += putVarint32(buf[length:], int32(ref))
+	}
+
+	length += putUvarint32(buf[length:], uint32(len(c.VariableEncodings)))
+	for _, encoding := range c.VariableEncodings {
+		buf[length] = encoding.descriptor(encoding.ElideConstant)
+		length++
+	}
+
+	return buf[:length]
+}
+
+// IsConfig reports whether buf contains a valid configuration frame, rather than an encoded data message. The whole
+// frame is decoded, because a data message from a stream with a UUID which starts with the configuration magic bytes
+// must not be mistaken for a configuration frame.
+func IsConfig(buf []byte) bool {
+	if !hasConfigMagic(buf) {
+		return false
+	}
+	_, err := DecodeConfig(buf)
+	return err == nil
+}
+
+// hasConfigMagic reports whether buf starts with the bytes which identify a configuration frame
+func hasConfigMagic(buf []byte) bool {
+	return len(buf) >= len(configMagic) && string(buf[:len(configMagic)]) == string(configMagic[:])
+}
+
+// DecodeConfig decodes and validates a configuration frame
+func DecodeConfig(buf []byte) (*Config, error) {
+	if !hasConfigMagic(buf) {
+		return nil, ErrInvalidConfig
+	}
+	length := len(configMagic)
+
+	if len(buf) < length+1+16 {
+		return nil, ErrInvalidConfig
+	}
+
+	c := &Config{}
+	c.Version = int(buf[length])
+	length++
+	if c.Version != ProtocolVersion {
+		return nil, ErrInvalidConfig
+	}
+	copy(c.ID[:], buf[length:])
+	length += 16
+
+	fields := []*int{
+		&c.Int32Count,
+		&c.Int16Count,
+		&c.Int64Count,
+		&c.Float32Count,
+		&c.Float64Count,
+		&c.SamplingRate,
+		&c.SamplesPerMessage,
+		&c.DeltaEncodingLayers,
+		&c.Simple8bThresholdSamples,
+		&c.UseGzipThresholdSamples,
+	}
+	for _, field := range fields {
+		val, lenB := uvarint32(buf[length:])
+		if lenB <= 0 {
+			return nil, ErrInvalidConfig
+		}
+		*field = int(val)
+		length += lenB
+	}
+
+	if length >= len(buf) {
+		return nil, ErrInvalidConfig
+	}
+	c.UseXOR = buf[length]&configOptionXOR != 0
+	c.UseChecksum = buf[length]&configOptionChecksum != 0
+	c.AdaptiveEncoding = buf[length]&configOptionAdaptive != 0
+	length++
+
+	// each count is checked against the remaining bytes before allocating, because each item uses at least one byte
+	refCount, lenB := uvarint32(buf[length:])
+	if lenB <= 0 || int(refCount) != c.Int32Count || int(refCount) > len(buf)-length-lenB {
+		return nil, ErrInvalidConfig
+	}
+	length += lenB
+	c.SpatialRefs = make([]int, refCount)
+	for i := range c.SpatialRefs {
+		ref, lenB := varint32(buf[length:])
+		if lenB <= 0 {
+			return nil, ErrInvalidConfig
+		}
+		c.SpatialRefs[i] = int(ref)
+		length += lenB
+	}
+
+	encodingCount, lenB := uvarint32(buf[length:])
+	if lenB <= 0 || int(encodingCount) > len(buf)-length-lenB {
+		return nil, ErrInvalidConfig
+	}
+	length += lenB
+	if encodingCount > 0 {
+		c.VariableEncodings = make([]VariableEncoding, encodingCount)
+		for i := range c.VariableEncodings {
+			encoding, elideConstant, err := variableEncodingFromDescriptor(buf[length])
+			if err != nil {
+				return nil, ErrInvalidConfig
+			}
+			encoding.ElideConstant = elideConstant
+			c.VariableEncodings[i] = encoding
+			length++
+		}
+	}
+
+	if length != len(buf) {
+		return nil, ErrInvalidConfig
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// variableCount returns the total number of variables of all types
+func (c *Config) variableCount() int {
+	return c.Int32Count + c.Int16Count + c.Int64Count + c.Float32Count + c.Float64Count
+}
+
+func (c *Config) validate() error {
+	if c.Int32Count < 0 || c.Int16Count < 0 || c.Int64Count < 0 || c.Float32Count < 0 || c.Float64Count < 0 {
+		return ErrInvalidConfig
+	}
+	if c.variableCount() == 0 {
+		return ErrInvalidConfig
+	}
+	if c.variableCount() > MaxVariableCount || c.SamplesPerMessage > MaxSamplesPerMessage ||
+		c.SamplesPerMessage*c.variableCount() > maxMessageValues {
+		return ErrInvalidConfig
+	}
+	if c.SamplesPerMessage <= 0 || c.DeltaEncodingLayers < 1 || c.DeltaEncodingLayers > MaxDeltaEncodingLayers {
+		return ErrInvalidConfig
+	}
+	if len(c.SpatialRefs) != c.Int32Count {
+		return ErrInvalidConfig
+	}
+	for _, ref := range c.SpatialRefs {
+		if ref < -1 || ref >= c.Int32Count {
+			return ErrInvalidConfig
+		}
+	}
+	if c.VariableEncodings != nil && len(c.VariableEncodings) != c.Int32Count+c.Int16Count+c.Int64Count {
+		return ErrInvalidConfig
+	}
+	for _, encoding := range c.VariableEncodings {
+		if !encoding.valid() {
+			return ErrInvalidConfig
+		}
+	}
+	return nil
+}
+
+// NewDecoderFromConfig creates a decoder instance using the settings in a configuration frame produced by
+// Encoder.EncodeConfig()
+func NewDecoderFromConfig(buf []byte) (*Decoder, error) {
+	c, err := DecodeConfig(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDecoder(c), nil
+}
+
+// NewEncoderWithConfig creates a stream protocol encoder instance using the given settings, which allows variables
+// of each data type to be included. Zero values for the sample thresholds use the package defaults.
+func NewEncoderWithConfig(c Config) (*Encoder, error) {
+	if err := c.normalise(); err != nil {
+		return nil, err
+	}
+
+	return newEncoder(&c), nil
+}
+
+// NewDecoderWithConfig creates a stream protocol decoder instance using the given settings, which must match the
+// settings of the encoder
+func NewDecoderWithConfig(c Config) (*Decoder, error) {
+	if err := c.normalise(); err != nil {
+		return nil, err
+	}
+
+	return newDecoder(&c), nil
+}
+
+// defaultConfig returns the settings used by NewEncoder() and NewDecoder()
+func defaultConfig(ID uuid.UUID, int32Count int, samplingRate int, samplesPerMessage int) *Config {
+	c := &Config{
+		Version:           ProtocolVersion,
+		ID:                ID,
+		Int32Count:        int32Count,
+		SamplingRate:      samplingRate,
+		SamplesPerMessage: samplesPerMessage,
+	}
+	c.setDefaults()
+	return c
+}
+
+// setDefaults populates any settings which have not been provided
+func (c *Config) setDefaults() {
+	if c.Version == 0 {
+		c.Version = ProtocolVersion
+	}
+	if c.DeltaEncodingLayers == 0 {
+		c.DeltaEncodingLayers = getDeltaEncoding(c.SamplingRate)
+	}
+	if c.Simple8bThresholdSamples == 0 {
+		c.Simple8bThresholdSamples = Simple8bThresholdSamples
+	}
+	if c.UseGzipThresholdSamples == 0 {
+		c.UseGzipThresholdSamples = UseGzipThresholdSamples
+	}
+	if c.SpatialRefs == nil {
+		c.SpatialRefs = make([]int, c.Int32Count)
+		for i := range c.SpatialRefs {
+			c.SpatialRefs[i] = -1
+		}
+	} else {
+		c.SpatialRefs = append([]int(nil), c.SpatialRefs...)
+	}
+	if c.VariableEncodings != nil {
+		c.VariableEncodings = append([]VariableEncoding(nil), c.VariableEncodings...)
+	}
+}
+
+// normalise populates default settings and validates a configuration provided by the user
+func (c *Config) normalise() error {
+	c.setDefaults()
+	if c.Version != ProtocolVersion {
+		return ErrInvalidConfig
+	}
+	return c.validate()
+}
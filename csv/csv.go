@@ -0,0 +1,270 @@
+// Package csv converts between CSV files of sampled values and Slipstream samples.
+//
+// Each CSV row is a sample. Columns are mapped to int32 variables by name, with a scale factor applied to each value
+// before rounding, so that (for example) currents in amperes can be stored in milliamperes. Empty values are given the
+// value 0 with the QualityInvalid quality. An optional time column, in seconds, is used to infer the sampling rate.
+// Sample timestamps are calculated from the start time and sampling rate, in the same way as for decoded Slipstream
+// messages.
+package csv
+
+import (
+	stdcsv "encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/synaptecltd/slipstream"
+)
+
+// QualityInvalid is the quality of empty values, with the invalid validity
+const QualityInvalid = uint32(slipstream.ValidityInvalid)
+
+// ErrMissingColumn is returned when a CSV file does not contain a column named in the format
+var ErrMissingColumn = errors.New("CSV column not found")
+
+// ErrInvalidValue is returned when a CSV value cannot be parsed, or is out of range after scaling
+var ErrInvalidValue = errors.New("invalid CSV value")
+
+// ErrSamplingRate is returned when the sampling rate is not provided and cannot be inferred from the time column
+var ErrSamplingRate = errors.New("cannot infer sampling rate")
+
+// Column maps a CSV column to a variable
+type Column struct {
+	Name     string  // CSV column header
+	Scale    float64 // multiplier applied to CSV values to give variable values, or 1 if zero
+	Optional bool    // if the column is not present, the variable values are zero rather than returning an error
+}
+
+// Format describes the layout of a CSV file
+type Format struct {
+	TimeColumn   string    // header of the time column, in seconds, or empty if there is no time column
+	Columns      []Column  // columns for each int32 variable, in order
+	SamplingRate int       // sampling rate in Hz, or zero to infer it from the time column
+	Start        time.Time // timestamp of the first sample, or the Unix epoch if zero
+}
+
+func (c *Column) scale() float64 {
+	if c.Scale == 0 {
+		return 1
+	}
+	return c.Scale
+}
+
+func (f *Format) start() uint64 {
+	if f.Start.IsZero() || f.Start.UnixNano() < 0 {
+		return 0
+	}
+	return uint64(f.Start.UnixNano())
+}
+
+// Read reads all samples from a CSV file with a header row, returning the samples and the sampling rate
+func Read(r io.Reader, f Format) ([]slipstream.DatasetWithQuality, int, error) {
+	reader := stdcsv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, 0, ErrMissingColumn
+		}
+		return nil, 0, err
+	}
+
+	// find the index of each column
+	find := func(name string) (int, error) {
+		for i := range header {
+			if strings.TrimSpace(header[i]) == name {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("%w: %q", ErrMissingColumn, name)
+	}
+	columns := make([]int, len(f.Columns))
+	for i := range f.Columns {
+		if columns[i], err = find(f.Columns[i].Name); err != nil {
+			if !f.Columns[i].Optional {
+				return nil, 0, err
+			}
+			columns[i] = -1
+		}
+	}
+	timeColumn := -1
+	if f.TimeColumn != "" {
+		if timeColumn, err = find(f.TimeColumn); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var data []slipstream.DatasetWithQuality
+	var firstTime, lastTime float64
+	var firstSample, lastSample int
+	hasTime := false
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		sample := slipstream.DatasetWithQuality{
+			Int32s: make([]int32, len(columns)),
+			Q:      make([]uint32, len(columns)),
+		}
+		for i, c := range columns {
+			if c < 0 {
+				continue
+			}
+			value := strings.TrimSpace(record[c])
+			if value == "" {
+				sample.Q[i] = QualityInvalid
+				continue
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, 0, fmt.Errorf("%w: line %d, column %q", ErrInvalidValue, line, f.Columns[i].Name)
+			}
+			scaled := math.Round(v * f.Columns[i].scale())
+			if scaled < math.MinInt32 || scaled > math.MaxInt32 {
+				return nil, 0, fmt.Errorf("%w: line %d, column %q", ErrInvalidValue, line, f.Columns[i].Name)
+			}
+			sample.Int32s[i] = int32(scaled)
+		}
+
+		if timeColumn >= 0 && strings.TrimSpace(record[timeColumn]) != "" {
+			t, err := strconv.ParseFloat(strings.TrimSpace(record[timeColumn]), 64)
+			if err != nil || math.IsNaN(t) || math.IsInf(t, 0) {
+				return nil, 0, fmt.Errorf("%w: line %d, column %q", ErrInvalidValue, line, f.TimeColumn)
+			}
+			if !hasTime {
+				firstTime, firstSample = t, len(data)
+				hasTime = true
+			}
+			lastTime, lastSample = t, len(data)
+		}
+
+		data = append(data, sample)
+	}
+
+	// infer the sampling rate from the mean sampling period, assuming an integer value in Hz
+	samplingRate := f.SamplingRate
+	if samplingRate <= 0 {
+		if lastSample <= firstSample || lastTime <= firstTime {
+			return nil, 0, ErrSamplingRate
+		}
+		samplingRate = int(math.Round(float64(lastSample-firstSample) / (lastTime - firstTime)))
+		if samplingRate <= 0 {
+			return nil, 0, ErrSamplingRate
+		}
+	}
+
+	start := f.start()
+	for i := range data {
+		data[i].T = slipstream.SampleTimestamp(start, i, samplingRate)
+	}
+
+	return data, samplingRate, nil
+}
+
+// ReadFile reads all samples from the named CSV file
+func ReadFile(name string, f Format) ([]slipstream.DatasetWithQuality, int, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	return Read(file, f)
+}
+
+// Writer writes samples, such as from slipstream.Decoder.Out, to a CSV file with a header row. Values are divided by
+// the scale factor of each column, and values with the QualityInvalid quality are left empty. The time column (if
+// any) contains the time since the start, in seconds.
+type Writer struct {
+	w      *stdcsv.Writer
+	format Format
+	header bool
+	record []string
+}
+
+// NewWriter creates a CSV writer with the given format
+func NewWriter(w io.Writer, f Format) *Writer {
+	columns := len(f.Columns)
+	if f.TimeColumn != "" {
+		columns++
+	}
+	return &Writer{
+		w:      stdcsv.NewWriter(w),
+		format: f,
+		record: make([]string, columns),
+	}
+}
+
+// Write writes samples as CSV rows. Each sample must have at least as many int32 variables as there are columns.
+func (w *Writer) Write(samples []slipstream.DatasetWithQuality) error {
+	if !w.header {
+		w.header = true
+		i := 0
+		if w.format.TimeColumn != "" {
+			w.record[i] = w.format.TimeColumn
+			i++
+		}
+		for _, c := range w.format.Columns {
+			w.record[i] = c.Name
+			i++
+		}
+		if err := w.w.Write(w.record); err != nil {
+			return err
+		}
+	}
+
+	start := w.format.start()
+	for s := range samples {
+		if len(samples[s].Int32s) < len(w.format.Columns) {
+			return ErrInvalidValue
+		}
+
+		i := 0
+		if w.format.TimeColumn != "" {
+			seconds := float64(int64(samples[s].T-start)) / float64(time.Second)
+			w.record[i] = strconv.FormatFloat(seconds, 'g', -1, 64)
+			i++
+		}
+		for c := range w.format.Columns {
+			if c < len(samples[s].Q) && samples[s].Quality(c).Validity() == slipstream.ValidityInvalid {
+				w.record[i] = ""
+				i++
+				continue
+			}
+			w.record[i] = strconv.FormatFloat(float64(samples[s].Int32s[c])/w.format.Columns[c].scale(), 'g', -1, 64)
+			i++
+		}
+		if err := w.w.Write(w.record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush writes any buffered rows to the underlying writer
+func (w *Writer) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// Write writes all samples to a CSV file with a header row
+func Write(w io.Writer, f Format, samples []slipstream.DatasetWithQuality) error {
+	writer := NewWriter(w, f)
+	if err := writer.Write(samples); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
@@ -3,12 +3,13 @@ package slipstream
 import (
 	"errors"
 
-	gzip "github.com/klauspost/compress/gzip"
 	"github.com/synaptecltd/encoding/bitops"
 
 	"bytes"
 	"encoding/binary"
+	"hash/crc32"
 	"io"
+	"math"
 	"sync"
 
 	"github.com/google/uuid"
@@ -23,282 +24,523 @@ type Decoder struct {
 	SamplesPerMessage   int
 	encodedSamples      int
 	Int32Count          int
-	gzBuf               *bytes.Buffer
+	Int16Count          int
+	Int64Count          int
+	Float32Count        int
+	Float64Count        int
+	payload             []byte
 	Out                 []DatasetWithQuality
 	startTimestamp      uint64
 	usingSimple8b       bool
 	deltaEncodingLayers int
-	deltaSum            [][]int32
+	simple8bValues      [240]uint64
+	values              [][]int32
+	int64Values         []int64
+	floatBits           []uint64
 	mutex               sync.Mutex
 
 	useXOR     bool
 	spatialRef []int
+
+	// encoding of each integer variable in the most recent message, and the value of elided int32 (or int16) variables
+	encodings []VariableEncoding
+	elided    []bool
+	constants []int32
+
+	// storage for a single column of values
+	column        []int32
+	qualityColumn []uint32
+
+	relativeTimestamps bool
+	sampleOffsets      []uint64
+
+	gzip gzipReader // used instead of the shared gzip compressor
 }
 
 // NewDecoder creates a stream protocol decoder instance for pre-allocated output
 func NewDecoder(ID uuid.UUID, int32Count int, samplingRate int, samplesPerMessage int) *Decoder {
-	d := &Decoder{
-		ID:                ID,
-		Int32Count:        int32Count,
-		SamplingRate:      samplingRate,
-		SamplesPerMessage: samplesPerMessage,
-		Out:               make([]DatasetWithQuality, samplesPerMessage),
-	}
-
-	// d.useXOR = true
+	return newDecoder(defaultConfig(ID, int32Count, samplingRate, samplesPerMessage))
+}
 
-	if samplesPerMessage > Simple8bThresholdSamples {
-		d.usingSimple8b = true
+func newDecoder(c *Config) *Decoder {
+	d := &Decoder{
+		ID:                c.ID,
+		Int32Count:        c.Int32Count,
+		Int16Count:        c.Int16Count,
+		Int64Count:        c.Int64Count,
+		Float32Count:      c.Float32Count,
+		Float64Count:      c.Float64Count,
+		SamplingRate:      c.SamplingRate,
+		SamplesPerMessage: c.SamplesPerMessage,
+		Out:               make([]DatasetWithQuality, c.SamplesPerMessage),
+		useXOR:            c.UseXOR,
+		spatialRef:        c.SpatialRefs,
 	}
 
 	// TODO make this conditional on message size to reduce memory use
-	bufSize := samplesPerMessage*int32Count*8 + int32Count*4
-	d.gzBuf = bytes.NewBuffer(make([]byte, 0, bufSize))
+	bufSize := maxPayloadSize(c.SamplesPerMessage, c.Int32Count, c.Int16Count, c.Int64Count, c.Float32Count, c.Float64Count)
+	d.payload = make([]byte, 0, bufSize)
 
-	d.deltaEncodingLayers = getDeltaEncoding(samplingRate)
+	d.deltaEncodingLayers = c.DeltaEncodingLayers
 
-	// storage for delta-delta decoding
-	d.deltaSum = make([][]int32, d.deltaEncodingLayers-1)
-	for i := range d.deltaSum {
-		d.deltaSum[i] = make([]int32, int32Count)
+	// pre-calculate the time offset of each sample in a message
+	d.sampleOffsets = make([]uint64, c.SamplesPerMessage)
+	for i := range d.sampleOffsets {
+		d.sampleOffsets[i] = SampleTimestamp(0, i, c.SamplingRate)
 	}
 
-	// initialise each set of outputs in data stucture
+	// initialise each set of outputs in data stucture. int16 variables are decoded as int32 values, which are stored
+	// after the int32 variables.
+	d.values = make([][]int32, c.SamplesPerMessage)
 	for i := range d.Out {
-		d.Out[i].Int32s = make([]int32, int32Count)
-		d.Out[i].Q = make([]uint32, int32Count)
-	}
-
-	d.spatialRef = make([]int, int32Count)
-	for i := range d.spatialRef {
-		d.spatialRef[i] = -1
+		d.values[i] = make([]int32, c.Int32Count+c.Int16Count)
+		d.Out[i].Int32s = d.values[i][:c.Int32Count:c.Int32Count]
+		d.Out[i].Int16s = make([]int16, c.Int16Count)
+		d.Out[i].Int64s = make([]int64, c.Int64Count)
+		d.Out[i].Float32s = make([]float32, c.Float32Count)
+		d.Out[i].Float64s = make([]float64, c.Float64Count)
+		d.Out[i].Q = make([]uint32, c.variableCount())
 	}
+	d.encodings = make([]VariableEncoding, c.Int32Count+c.Int16Count+c.Int64Count)
+	d.elided = make([]bool, len(d.encodings))
+	d.constants = make([]int32, c.Int32Count+c.Int16Count)
+	d.column = make([]int32, c.SamplesPerMessage)
+	d.qualityColumn = make([]uint32, c.SamplesPerMessage)
+	d.int64Values = make([]int64, c.SamplesPerMessage)
+	d.floatBits = make([]uint64, c.SamplesPerMessage)
 
 	return d
 }
 
-// SetXOR uses XOR delta instead of arithmetic delta
+// SetXOR uses XOR delta instead of arithmetic delta. Note that this is overridden by the flags in each message header.
 func (s *Decoder) SetXOR(xor bool) {
 	s.useXOR = xor
 }
 
+// SetRelativeTimestamps sets the timestamp of each decoded sample (after the first sample) to its index within the
+// message, rather than an absolute timestamp. This matches the behaviour of earlier versions of the decoder.
+func (s *Decoder) SetRelativeTimestamps(relative bool) {
+	s.relativeTimestamps = relative
+}
+
 // SetSpatialRefs automatically maps adjacent sets of three-phase currents for spatial compression
 func (s *Decoder) SetSpatialRefs(count int, countV int, countI int, includeNeutral bool) {
 	s.spatialRef = createSpatialRefs(count, countV, countI, includeNeutral)
 }
 
-// DecodeToBuffer decodes to a pre-allocated buffer
-func (s *Decoder) DecodeToBuffer(buf []byte, totalLength int) (int, error) {
+// VariableEncoding returns the encoding of an integer variable in the most recently decoded message, where the index
+// includes the int32, int16 and int64 variables in that order
+func (s *Decoder) VariableEncoding(index int) VariableEncoding {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	var length int = 16
-	var valSigned int32 = 0
-	var valUnsigned uint32 = 0
-	var lenB int = 0
+	return s.encodings[index]
+}
 
-	// check ID
-	res := bytes.Compare(buf[:length], s.ID[:])
-	if res != 0 {
-		return 0, errors.New("IDs did not match")
+func (s *Decoder) hasSpatialRefs() bool {
+	for _, ref := range s.spatialRef {
+		if ref >= 0 {
+			return true
+		}
 	}
+	return false
+}
 
-	// decode timestamp
-	s.startTimestamp = binary.BigEndian.Uint64(buf[length:])
-	length += 8
+// applySpatialRefs restores the values of variables which were encoded relative to another variable
+func (s *Decoder) applySpatialRefs(samples int) {
+	for indexTs := range s.Out[:samples] {
+		for i := range s.Out[indexTs].Int32s {
+			if s.spatialRef[i] >= 0 {
+				s.Out[indexTs].Int32s[i] += s.Out[indexTs].Int32s[s.spatialRef[i]]
+			}
+		}
+	}
+}
 
-	// the first timestamp is the starting value encoded in the header
-	s.Out[0].T = s.startTimestamp
+// decodeDescriptors decodes the encoding of each integer variable, followed by the value of any variables which have
+// been elided because the value is constant. It returns the number of bytes read from buf.
+func (s *Decoder) decodeDescriptors(buf []byte, samples int) (int, error) {
+	if len(buf) < len(s.encodings) {
+		return 0, ErrTruncated
+	}
 
-	// decode number of samples
-	valSigned, lenB = varint32(buf[length:])
-	s.encodedSamples = int(valSigned)
-	length += lenB
+	length := 0
+	for i := range s.encodings {
+		encoding, elided, err := variableEncodingFromDescriptor(buf[length])
+		if err != nil {
+			return 0, err
+		}
+		s.encodings[i] = encoding
+		s.elided[i] = elided
+		length++
+	}
 
-	actualSamples := min(s.encodedSamples, s.SamplesPerMessage)
+	int32Count := s.Int32Count + s.Int16Count
+	for i := range s.elided {
+		if !s.elided[i] {
+			continue
+		}
 
-	// TODO inspect performance here
-	s.gzBuf.Reset()
-	if actualSamples > UseGzipThresholdSamples {
-		gr, err := gzip.NewReader(bytes.NewBuffer(buf[length:]))
+		if i < int32Count {
+			value, lenB := varint32(buf[length:])
+			if err := varintError(lenB); err != nil {
+				return 0, err
+			}
+			length += lenB
+			s.constants[i] = value
+		} else {
+			value, lenB := binary.Uvarint(buf[length:])
+			if err := varintError(lenB); err != nil {
+				return 0, err
+			}
+			length += lenB
+			for j := range s.Out[:samples] {
+				s.Out[j].Int64s[i-int32Count] = bitops.ZigZagDecode64(value)
+			}
+		}
+	}
+	return length, nil
+}
+
+// decodeSimple8bColumn decodes the simple-8b encoded values of an int32 (or int16) variable, before delta decoding,
+// returning the number of bytes read from buf
+func (s *Decoder) decodeSimple8bColumn(buf []byte, column []int32) (int, error) {
+	// simple8b.ForEach() is not used because it decodes runs of ones (selectors 0 and 1) as zeros
+	length := 0
+	indexTs := 0
+	for indexTs < len(column) {
+		// the data ended before all values were decoded
+		if length+8 > len(buf) {
+			return 0, ErrTruncated
+		}
+
+		n, err := simple8b.Decode(&s.simple8bValues, binary.BigEndian.Uint64(buf[length:]))
 		if err != nil {
 			return 0, err
 		}
+		length += 8
 
-		_, errRead := io.Copy(s.gzBuf, gr)
-		// origLen, errRead := gr.Read((buf[length:]))
-		if errRead != nil {
-			return 0, errRead
+		// get signed values back with zig-zag decoding
+		n = min(n, len(column)-indexTs)
+		zigZagDecode32(column[indexTs:], s.simple8bValues[:n])
+		indexTs += n
+	}
+	return length, nil
+}
+
+// DecodeToBuffer decodes to a pre-allocated buffer. Every read is checked against totalLength, so that truncated
+// or corrupt messages return an error rather than causing a panic.
+func (s *Decoder) DecodeToBuffer(buf []byte, totalLength int) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	outBytes, flags, err := s.decodeHeader(buf, totalLength)
+	if err != nil {
+		return 0, err
+	}
+	actualSamples := s.encodedSamples
+
+	// use the encoding of each variable described in the message, or otherwise the same encoding for all variables
+	length, err := s.decodeEncodings(outBytes, flags, actualSamples)
+	if err != nil {
+		return 0, err
+	}
+	for i := range s.values[0] {
+		if s.elided[i] {
+			for j := range s.values[:actualSamples] {
+				s.values[j][i] = s.constants[i]
+			}
 		}
-		gr.Close()
-	} else {
-		s.gzBuf = bytes.NewBuffer(buf[length:])
 	}
-	// log.Debug().Int("gz len", totalLength).Int64("original len", origLen).Msg("decoding")
-	outBytes := s.gzBuf.Bytes()
-	length = 0
 
 	if s.usingSimple8b {
-		// for simple-8b encoding, iterate through every value
-		decodeCounter := 0
-		indexTs := 0
-		i := 0
-
-		decodedUnit64s, _ := simple8b.ForEach( /*buf[length:]*/ outBytes[length:], func(v uint64) bool {
-			// manage 2D slice indices
-			indexTs = decodeCounter % actualSamples
-			if decodeCounter > 0 && indexTs == 0 {
-				i++
+		// for simple-8b encoding, each variable is encoded separately
+		for i := range s.values[0] {
+			if s.elided[i] {
+				continue
 			}
 
-			// get signed value back with zig-zag decoding
-			decodedValue := int32(bitops.ZigZagDecode64(v))
-
-			if indexTs == 0 {
-				s.Out[indexTs].Int32s[i] = decodedValue
-			} else {
-				s.Out[indexTs].T = uint64(indexTs)
-
-				// delta decoding
-				maxIndex := min(indexTs, s.deltaEncodingLayers-1) - 1
-				if s.useXOR {
-					s.deltaSum[maxIndex][i] ^= decodedValue
-				} else {
-					s.deltaSum[maxIndex][i] += decodedValue
-				}
+			column := s.column[:actualSamples]
+			lenB, err := s.decodeSimple8bColumn(outBytes[length:], column)
+			if err != nil {
+				return 0, err
+			}
+			length += lenB
 
-				for k := maxIndex; k >= 1; k-- {
-					if s.useXOR {
-						s.deltaSum[k-1][i] ^= s.deltaSum[k][i]
-					} else {
-						s.deltaSum[k-1][i] += s.deltaSum[k][i]
-					}
+			encoding := s.encodings[i]
+			deltaDecode32(column, encoding.layers(), encoding.UseXOR)
+			for j, v := range column {
+				s.values[j][i] = v
+			}
+		}
+	} else {
+		// varints are interleaved, with a value for each variable in turn
+		for j := range s.values[:actualSamples] {
+			for i := range s.values[j] {
+				if s.elided[i] {
+					continue
 				}
 
-				if s.useXOR {
-					s.Out[indexTs].Int32s[i] = s.Out[indexTs-1].Int32s[i] ^ s.deltaSum[0][i]
-				} else {
-					s.Out[indexTs].Int32s[i] = s.Out[indexTs-1].Int32s[i] + s.deltaSum[0][i]
+				value, lenB := varint32(outBytes[length:])
+				if err := varintError(lenB); err != nil {
+					return 0, err
 				}
+				s.values[j][i] = value
+				length += lenB
 			}
+		}
 
-			decodeCounter++
-
-			// all variables and timesteps have been decoded
-			if decodeCounter == actualSamples*s.Int32Count {
-				// take care of spatial references (cannot do this piecemeal above because it disrupts the previous value history)
-				for indexTs := range s.Out {
-					for i := range s.Out[indexTs].Int32s {
-						if s.spatialRef[i] >= 0 {
-							s.Out[indexTs].Int32s[i] += s.Out[indexTs].Int32s[s.spatialRef[i]]
-						}
-					}
-				}
+		// delta decoding is applied to each variable as a column
+		for i := range s.values[0] {
+			if s.elided[i] || s.encodings[i].layers() == 0 {
+				continue
+			}
 
-				// stop decoding
-				return false
+			column := s.column[:actualSamples]
+			for j := range column {
+				column[j] = s.values[j][i]
+			}
+			encoding := s.encodings[i]
+			deltaDecode32(column, encoding.layers(), encoding.UseXOR)
+			for j, v := range column {
+				s.values[j][i] = v
 			}
+		}
+	}
 
-			return true
-		})
+	// take care of spatial references (cannot do this piecemeal above because it disrupts the previous value history)
+	if flags&FlagSpatialRefs != 0 {
+		s.applySpatialRefs(actualSamples)
+	}
 
-		// add length of decoded unit64 blocks (8 bytes each)
-		length += decodedUnit64s * 8
-	} else {
-		// get first set of samples using delta-delta encoding
-		for i := 0; i < s.Int32Count; i++ {
-			valSigned, lenB = varint32( /*buf[length:]*/ outBytes[length:])
-			s.Out[0].Int32s[i] = int32(valSigned)
-			length += lenB
+	// populate timestamps, relative to the starting value encoded in the header
+	for i := range s.Out[:actualSamples] {
+		if s.relativeTimestamps && i > 0 {
+			// encode the sample number relative to the starting timestamp
+			s.Out[i].T = uint64(i)
+		} else {
+			s.Out[i].T = s.startTimestamp + s.sampleOffsets[i]
 		}
+	}
 
-		// decode remaining delta-delta encoded values
-		if actualSamples > 1 {
-			var totalSamples int = 1
-			for {
-				// encode the sample number relative to the starting timestamp
-				s.Out[totalSamples].T = uint64(totalSamples)
-
-				// delta decoding
-				for i := 0; i < s.Int32Count; i++ {
-					decodedValue, lenB := varint32( /*buf[length:]*/ outBytes[length:])
-					length += lenB
-
-					maxIndex := min(totalSamples, s.deltaEncodingLayers-1) - 1
-					if s.useXOR {
-						s.deltaSum[maxIndex][i] ^= decodedValue
-					} else {
-						s.deltaSum[maxIndex][i] += decodedValue
-					}
-
-					for k := maxIndex; k >= 1; k-- {
-						if s.useXOR {
-							s.deltaSum[k-1][i] ^= s.deltaSum[k][i]
-						} else {
-							s.deltaSum[k-1][i] += s.deltaSum[k][i]
-						}
-					}
-
-					if s.useXOR {
-						s.Out[totalSamples].Int32s[i] = s.Out[totalSamples-1].Int32s[i] ^ s.deltaSum[0][i]
-					} else {
-						s.Out[totalSamples].Int32s[i] = s.Out[totalSamples-1].Int32s[i] + s.deltaSum[0][i]
-					}
-				}
-				totalSamples++
-
-				if totalSamples >= actualSamples {
-					// take care of spatial references (cannot do this piecemeal above because it disrupts the previous value history)
-					for indexTs := range s.Out {
-						for i := range s.Out[indexTs].Int32s {
-							// skip the first time index
-							if s.spatialRef[i] >= 0 {
-								s.Out[indexTs].Int32s[i] += s.Out[indexTs].Int32s[s.spatialRef[i]]
-							}
-						}
-					}
-
-					// end decoding
-					break
-				}
-			}
+	// narrow int16 variables, which are decoded as int32 values
+	for j := range s.Out[:actualSamples] {
+		for i := range s.Out[j].Int16s {
+			s.Out[j].Int16s[i] = int16(s.values[j][s.Int32Count+i])
 		}
 	}
 
+	lenB, err := s.decodeInt64s(outBytes[length:], actualSamples)
+	if err != nil {
+		return 0, err
+	}
+	length += lenB
+
+	// decode floating-point values from a single bit stream
+	r := bitReader{buf: outBytes[length:]}
+	for i := 0; i < s.Float32Count; i++ {
+		if err := decodeXOR(&r, s.floatBits[:actualSamples], 32); err != nil {
+			return 0, err
+		}
+		for j, bits := range s.floatBits[:actualSamples] {
+			s.Out[j].Float32s[i] = math.Float32frombits(uint32(bits))
+		}
+	}
+	for i := 0; i < s.Float64Count; i++ {
+		if err := decodeXOR(&r, s.floatBits[:actualSamples], 64); err != nil {
+			return 0, err
+		}
+		for j, bits := range s.floatBits[:actualSamples] {
+			s.Out[j].Float64s[i] = math.Float64frombits(bits)
+		}
+	}
+	length += r.len()
+
 	// populate quality structure
-	for i := 0; i < s.Int32Count; i++ {
-		sampleNumber := 0
-		for sampleNumber < actualSamples {
-			valUnsigned, lenB = uvarint32( /*buf[length:]*/ outBytes[length:])
-			length += lenB
-			s.Out[sampleNumber].Q[i] = uint32(valUnsigned)
+	column := s.qualityColumn[:actualSamples]
+	for i := range s.Out[0].Q {
+		lenB, err := decodeQualityColumn(outBytes[length:], column)
+		if err != nil {
+			return 0, err
+		}
+		length += lenB
+		for j, value := range column {
+			s.Out[j].Q[i] = value
+		}
+	}
 
-			valUnsigned, lenB = uvarint32( /*buf[length:]*/ outBytes[length:])
-			length += lenB
+	if length != len(outBytes) {
+		return 0, ErrTrailingBytes
+	}
 
-			if valUnsigned == 0 {
-				// write all remaining Q values for this variable
-				for j := sampleNumber + 1; j < len(s.Out); j++ {
-					s.Out[j].Q[i] = s.Out[sampleNumber].Q[i]
-				}
-				sampleNumber = actualSamples
-			} else {
-				// write up to valUnsigned remaining Q values for this variable
-				for j := sampleNumber + 1; j < int(valUnsigned); j++ {
-					if sampleNumber < len(s.Out) && j < len(s.Out) {
-						s.Out[j].Q[i] = s.Out[sampleNumber].Q[i]
-					}
-				}
-				sampleNumber += int(valUnsigned)
+	return actualSamples, nil
+}
+
+// decodeHeader checks the header of a message, and returns the payload (after decompression) and the flags. The
+// timestamp and number of samples are stored in the decoder.
+func (s *Decoder) decodeHeader(buf []byte, totalLength int) ([]byte, byte, error) {
+	var length int = 16
+
+	if totalLength < 0 || totalLength > len(buf) {
+		return nil, 0, ErrTruncated
+	}
+	buf = buf[:totalLength]
+
+	// the fixed-size part of the header must be present
+	if len(buf) < length+2+8 {
+		return nil, 0, ErrTruncated
+	}
+
+	// check ID
+	res := bytes.Compare(buf[:length], s.ID[:])
+	if res != 0 {
+		return nil, 0, ErrIDMismatch
+	}
+
+	// check protocol version and encoding options
+	if version := int(buf[length]); version != ProtocolVersion {
+		return nil, 0, &UnsupportedVersionError{Version: version}
+	}
+	flags := buf[length+1]
+	length += 2
+	if flags&^supportedFlags != 0 {
+		return nil, 0, ErrUnsupportedFlags
+	}
+
+	// verify and remove the checksum, before decoding the remainder of the message
+	if flags&FlagChecksum != 0 {
+		if len(buf) < length+8+ChecksumSize {
+			return nil, 0, ErrTruncated
+		}
+		end := len(buf) - ChecksumSize
+		if crc32.Checksum(buf[:end], crc32cTable) != binary.BigEndian.Uint32(buf[end:]) {
+			return nil, 0, ErrChecksum
+		}
+		buf = buf[:end]
+	}
+
+	// adapt to the encoding options used for this message
+	s.usingSimple8b = flags&FlagSimple8b != 0
+	s.useXOR = flags&FlagXOR != 0
+	if flags&FlagSpatialRefs != 0 && !s.hasSpatialRefs() {
+		return nil, 0, ErrMissingSpatialRefs
+	}
+
+	// decode timestamp
+	s.startTimestamp = binary.BigEndian.Uint64(buf[length:])
+	length += 8
+
+	// decode number of samples
+	valSigned, lenB := varint32(buf[length:])
+	if err := varintError(lenB); err != nil {
+		return nil, 0, err
+	}
+	s.encodedSamples = int(valSigned)
+	length += lenB
+
+	if s.encodedSamples < 1 || s.encodedSamples > s.SamplesPerMessage {
+		return nil, 0, ErrSampleCountOverflow
+	}
+
+	// the gzip flag is used without a compressor ID, for compatibility with older encoders
+	outBytes := buf[length:]
+	if flags&(FlagGzip|FlagCompressed) != 0 {
+		compressor := GzipCompression
+		if flags&FlagCompressed != 0 {
+			if len(outBytes) == 0 {
+				return nil, 0, ErrTruncated
 			}
+			if compressor = compressorByID(outBytes[0]); compressor == nil {
+				return nil, 0, ErrUnsupportedCompressor
+			}
+			outBytes = outBytes[1:]
+		}
+
+		// limit the decompressed size, to protect against corrupt or malicious messages
+		maxPayloadSize := maxPayloadSize(s.encodedSamples, s.Int32Count, s.Int16Count, s.Int64Count, s.Float32Count, s.Float64Count)
+		var err error
+		if _, ok := compressor.(*gzipCompressor); ok {
+			s.payload, err = s.gzip.decompress(s.payload[:0], outBytes, maxPayloadSize)
+		} else {
+			s.payload, err = compressor.Decompress(s.payload[:0], outBytes, maxPayloadSize)
+		}
+		if err != nil {
+			return nil, 0, payloadError(err)
 		}
+		outBytes = s.payload
 	}
+	return outBytes, flags, nil
+}
 
-	for j := range s.deltaSum {
-		for i := 0; i < s.Int32Count; i++ {
-			s.deltaSum[j][i] = 0
+// decodeEncodings decodes the encoding of each integer variable, and the value of any elided int32 (or int16)
+// variables, returning the number of bytes read from buf
+func (s *Decoder) decodeEncodings(buf []byte, flags byte, samples int) (int, error) {
+	if flags&FlagColumnDescriptors != 0 {
+		return s.decodeDescriptors(buf, samples)
+	}
+	for i := range s.encodings {
+		s.encodings[i] = VariableEncoding{DeltaEncodingLayers: s.deltaEncodingLayers, UseXOR: s.useXOR}
+		s.elided[i] = false
+	}
+	return 0, nil
+}
+
+// decodeQualityColumn decodes the run-length encoded quality values of a variable, returning the number of bytes
+// read from buf
+func decodeQualityColumn(buf []byte, column []uint32) (int, error) {
+	length := 0
+	sampleNumber := 0
+	for sampleNumber < len(column) {
+		value, lenB := uvarint32(buf[length:])
+		if err := varintError(lenB); err != nil {
+			return 0, err
+		}
+		length += lenB
+
+		runLength, lenB := uvarint32(buf[length:])
+		if err := varintError(lenB); err != nil {
+			return 0, err
+		}
+		length += lenB
+
+		// a run length of zero means that the value applies to all remaining samples for this variable
+		end := len(column)
+		if runLength != 0 {
+			end = sampleNumber + int(runLength)
+			if end > len(column) {
+				return 0, ErrSampleCountOverflow
+			}
+		}
+
+		for j := sampleNumber; j < end; j++ {
+			column[j] = value
 		}
+		sampleNumber = end
 	}
+	return length, nil
+}
 
-	return actualSamples, nil
+// maxMessageSize returns the size of the largest valid message, allowing for the worst case expansion by compression
+func (s *Decoder) maxMessageSize() int {
+	size := MaxHeaderSize + maxPayloadSize(s.SamplesPerMessage, s.Int32Count, s.Int16Count, s.Int64Count, s.Float32Count, s.Float64Count)
+	return size + size/1000 + 1024
+}
+
+// varintError converts the length returned by varint decoding into an error, if the varint was not valid
+func varintError(lenB int) error {
+	if lenB == 0 {
+		return ErrTruncated
+	}
+	if lenB < 0 {
+		return ErrMalformedVarint
+	}
+	return nil
+}
+
+// payloadError converts errors from decompression of a message payload
+func payloadError(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return ErrTruncated
+	}
+	return err
 }
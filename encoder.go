@@ -1,13 +1,12 @@
 package slipstream
 
 import (
-	"bytes"
 	"encoding/binary"
+	"hash/crc32"
+	"math"
 	"sync"
 
 	"github.com/google/uuid"
-	gzip "github.com/klauspost/compress/gzip"
-	"github.com/rs/zerolog/log"
 	"github.com/synaptecltd/encoding/bitops"
 	"github.com/synaptecltd/encoding/simple8b"
 )
@@ -18,77 +17,123 @@ type Encoder struct {
 	SamplingRate        int
 	SamplesPerMessage   int
 	Int32Count          int
+	Int16Count          int
+	Int64Count          int
+	Float32Count        int
+	Float64Count        int
 	buf                 []byte
-	bufA                []byte
-	bufB                []byte
-	outBufA             *bytes.Buffer
-	outBufB             *bytes.Buffer
+	outBufA             []byte
+	outBufB             []byte
 	useBufA             bool
 	len                 int
 	encodedSamples      int
 	usingSimple8b       bool
 	deltaEncodingLayers int
 	simple8bValues      []uint64
-	prevData            []Dataset
-	deltaN              []int32
 
 	qualityHistory [][]qualityHistory
-	diffs          [][]uint64
-	values         [][]int32
+	int64Values    [][]int64
+	int64Diffs     []uint64
+	floatBits      [][]uint64
 	mutex          sync.Mutex
 
-	useXOR     bool
-	spatialRef []int
+	useXOR      bool
+	useChecksum bool
+	spatialRef  []int
+
+	// per-variable encoding of integer variables, which is nil if all variables use the same encoding
+	encodings        []VariableEncoding
+	messageEncodings []VariableEncoding
+	constant         []bool
+	constantValue    []int32
+	elided           []bool
+
+	// unencoded int32 (and int16) values are stored until the end of each message, and then delta encoded by column
+	adaptive  bool
+	raw       [][]int32
+	scratch32 []int32
+	scratch64 []int64
+	scratchQ  []uint32
+
+	simple8bThreshold int
+	gzipThreshold     int
+	compressor        PayloadCompressor
+	gzip              gzipWriter // used instead of the shared gzip compressor
 }
 
 // NewEncoder creates a stream protocol encoder instance
 func NewEncoder(ID uuid.UUID, int32Count int, samplingRate int, samplesPerMessage int) *Encoder {
-	// estimate maximum buffer space required
-	bufSize := MaxHeaderSize + samplesPerMessage*int32Count*8 + int32Count*4
+	return newEncoder(defaultConfig(ID, int32Count, samplingRate, samplesPerMessage))
+}
+
+func newEncoder(c *Config) *Encoder {
+	samplesPerMessage := c.SamplesPerMessage
+
+	// int16 variables are stored after the int32 variables, and are encoded in the same way
+	int32Count := c.Int32Count + c.Int16Count
+
+	// maximum buffer space required, including a quality change for every sample
+	bufSize := MaxHeaderSize + maxPayloadSize(samplesPerMessage, c.Int32Count, c.Int16Count, c.Int64Count, c.Float32Count, c.Float64Count)
 
 	s := &Encoder{
-		ID:                ID,
-		SamplingRate:      samplingRate,
+		ID:                c.ID,
+		SamplingRate:      c.SamplingRate,
 		SamplesPerMessage: samplesPerMessage,
-		bufA:              make([]byte, bufSize),
-		bufB:              make([]byte, bufSize),
-		Int32Count:        int32Count,
+		buf:               make([]byte, bufSize),
+		Int32Count:        c.Int32Count,
+		Int16Count:        c.Int16Count,
+		Int64Count:        c.Int64Count,
+		Float32Count:      c.Float32Count,
+		Float64Count:      c.Float64Count,
 		simple8bValues:    make([]uint64, samplesPerMessage),
+		useXOR:            c.UseXOR,
+		useChecksum:       c.UseChecksum,
+		spatialRef:        c.SpatialRefs,
+		encodings:         c.VariableEncodings,
+		simple8bThreshold: c.Simple8bThresholdSamples,
+		gzipThreshold:     c.UseGzipThresholdSamples,
+		compressor:        GzipCompression,
 	}
 
-	// s.useXOR = true
+	s.deltaEncodingLayers = c.DeltaEncodingLayers
+
+	s.usingSimple8b = samplesPerMessage > s.simple8bThreshold
 
-	// initialise ping-pong buffer
-	s.useBufA = true
-	s.buf = s.bufA
+	// storage for int32 (and int16) values, which are encoded at the end of the message
+	s.raw = make([][]int32, int32Count)
+	for i := range s.raw {
+		s.raw[i] = make([]int32, samplesPerMessage)
+	}
 
-	// TODO make this conditional on message size to reduce memory use
-	s.outBufA = bytes.NewBuffer(make([]byte, 0, bufSize))
-	s.outBufB = bytes.NewBuffer(make([]byte, 0, bufSize))
+	// storage for int64 values, which are encoded at the end of the message
+	s.int64Values = make([][]int64, c.Int64Count)
+	for i := range s.int64Values {
+		s.int64Values[i] = make([]int64, samplesPerMessage)
+	}
+	s.int64Diffs = make([]uint64, samplesPerMessage)
 
-	s.deltaEncodingLayers = getDeltaEncoding(samplingRate)
+	// storage for a single column of values, used by the adaptive encoder and when encoding complete messages
+	s.scratch32 = make([]int32, samplesPerMessage)
+	s.scratchQ = make([]uint32, samplesPerMessage)
 
-	if samplesPerMessage > Simple8bThresholdSamples {
-		s.usingSimple8b = true
-		s.diffs = make([][]uint64, int32Count)
-		for i := range s.diffs {
-			s.diffs[i] = make([]uint64, samplesPerMessage)
-		}
-	} else {
-		s.values = make([][]int32, samplesPerMessage)
-		for i := range s.values {
-			s.values[i] = make([]int32, int32Count)
-		}
+	// storage for the bit patterns of floating-point values, with float32 variables first
+	s.floatBits = make([][]uint64, c.Float32Count+c.Float64Count)
+	for i := range s.floatBits {
+		s.floatBits[i] = make([]uint64, samplesPerMessage)
 	}
 
-	// storage for delta-delta encoding
-	s.prevData = make([]Dataset, s.deltaEncodingLayers)
-	for i := range s.prevData {
-		s.prevData[i].Int32s = make([]int32, int32Count)
+	// storage for detecting constant values
+	s.constant = make([]bool, int32Count)
+	s.constantValue = make([]int32, int32Count)
+	s.elided = make([]bool, int32Count+c.Int64Count)
+	s.messageEncodings = make([]VariableEncoding, len(s.elided))
+
+	if c.AdaptiveEncoding {
+		s.adaptive = true
+		s.scratch64 = make([]int64, samplesPerMessage)
 	}
-	s.deltaN = make([]int32, s.deltaEncodingLayers)
 
-	s.qualityHistory = make([][]qualityHistory, int32Count)
+	s.qualityHistory = make([][]qualityHistory, c.variableCount())
 	for i := range s.qualityHistory {
 		// set capacity to avoid some possible allocations during encoding
 		s.qualityHistory[i] = make([]qualityHistory, 1, 16)
@@ -96,11 +141,6 @@ func NewEncoder(ID uuid.UUID, int32Count int, samplingRate int, samplesPerMessag
 		s.qualityHistory[i][0].samples = 0
 	}
 
-	s.spatialRef = make([]int, int32Count)
-	for i := range s.spatialRef {
-		s.spatialRef[i] = -1
-	}
-
 	return s
 }
 
@@ -109,32 +149,155 @@ func (s *Encoder) SetXOR(xor bool) {
 	s.useXOR = xor
 }
 
+// SetChecksum appends a CRC32C checksum to each message, so that the decoder can detect corrupted messages
+func (s *Encoder) SetChecksum(checksum bool) {
+	s.useChecksum = checksum
+}
+
+// SetCompressor sets the compressor which is applied to the payload of messages with more than
+// UseGzipThresholdSamples samples. A nil compressor disables compression.
+func (s *Encoder) SetCompressor(c PayloadCompressor) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c == nil {
+		c = NoCompression
+	}
+	s.compressor = c
+}
+
 // SetSpatialRefs automatically maps adjacent sets of three-phase currents for spatial compression
 func (s *Encoder) SetSpatialRefs(count int, countV int, countI int, includeNeutral bool) {
 	s.spatialRef = createSpatialRefs(count, countV, countI, includeNeutral)
 }
 
-func (s *Encoder) encodeSingleSample(index int, value int32) {
-	if s.usingSimple8b {
-		s.diffs[index][s.encodedSamples] = bitops.ZigZagEncode64(int64(value))
-	} else {
-		s.values[s.encodedSamples][index] = value
+// SetVariableEncoding sets the encoding of an integer variable, where the index includes the int32, int16 and int64
+// variables in that order. Until this is called, all integer variables use the same encoding. The encoding of each
+// variable is included in every message, so it does not need to be provided to the decoder.
+func (s *Encoder) SetVariableEncoding(index int, encoding VariableEncoding) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if index < 0 || index >= len(s.elided) || !encoding.valid() {
+		return ErrInvalidConfig
+	}
+
+	if s.encodings == nil {
+		encodings := make([]VariableEncoding, len(s.elided))
+		for i := range encodings {
+			encodings[i] = s.encoding(i)
+		}
+		s.encodings = encodings
 	}
+	s.encodings[index] = encoding
+	return nil
+}
+
+// encoding returns the encoding of an integer variable
+func (s *Encoder) encoding(i int) VariableEncoding {
+	if s.encodings != nil {
+		return s.encodings[i]
+	}
+	return VariableEncoding{DeltaEncodingLayers: s.deltaEncodingLayers, UseXOR: s.useXOR}
+}
+
+func (s *Encoder) hasSpatialRefs() bool {
+	for _, ref := range s.spatialRef {
+		if ref >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeInt32 stores the next value of the int32 (or int16) variable at the given index
+func (s *Encoder) encodeInt32(i int, val int32) {
+	j := s.encodedSamples // copy for conciseness
+
+	// track whether the value is the same for all samples
+	if j == 0 {
+		s.constant[i] = true
+		s.constantValue[i] = val
+	} else if val != s.constantValue[i] {
+		s.constant[i] = false
+	}
+
+	s.raw[i][j] = val
+}
+
+// encodeDescriptors writes the encoding of each integer variable, followed by the value of any variables which have
+// been elided because the value is constant. It returns the number of bytes written to buf.
+func (s *Encoder) encodeDescriptors(buf []byte) int {
+	int32Count := len(s.constant)
+	length := 0
+	for i := range s.elided {
+		encoding := s.messageEncodings[i]
+		if i < int32Count {
+			s.elided[i] = encoding.ElideConstant && s.constant[i]
+		} else {
+			s.elided[i] = encoding.ElideConstant && isConstant64(s.int64Values[i-int32Count][:s.encodedSamples])
+		}
+		buf[length] = encoding.descriptor(s.elided[i])
+		length++
+	}
+
+	for i := range s.elided {
+		if !s.elided[i] {
+			continue
+		}
+		if i < int32Count {
+			length += putVarint32(buf[length:], s.constantValue[i])
+		} else {
+			length += binary.PutUvarint(buf[length:], bitops.ZigZagEncode64(s.int64Values[i-int32Count][0]))
+		}
+	}
+	return length
 }
 
 // Encode encodes the next set of samples. It is called iteratively until the pre-defined number of samples are provided.
+// The returned message is stored in one of two alternating buffers, so it must be saved or copied before a third
+// message is started. EncodeTo() avoids this by using a buffer owned by the caller.
 func (s *Encoder) Encode(data *DatasetWithQuality) ([]byte, int, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	if s.encodeSample(data) {
+		return s.endEncode()
+	}
+	return nil, 0, nil
+}
+
+// EncodeTo encodes the next set of samples, in the same way as Encode(). When a message is complete, it is appended to
+// dst and the extended slice is returned. Otherwise, dst is returned unchanged. The message is encoded directly into
+// dst, without any allocation, if dst has at least MaxMessageSize() bytes of spare capacity.
+func (s *Encoder) EncodeTo(dst []byte, data *DatasetWithQuality) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.encodeSample(data) {
+		return s.appendMessage(dst)
+	}
+	return dst
+}
+
+// AppendMessage ends the encoding early, in the same way as EndEncode(), and appends the message to dst. It returns
+// dst unchanged if no samples have been encoded.
+func (s *Encoder) AppendMessage(dst []byte) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.appendMessage(dst)
+}
+
+// MaxMessageSize returns the size of the largest message which can be produced by the encoder
+func (s *Encoder) MaxMessageSize() int {
+	return len(s.buf) + ChecksumSize
+}
+
+// encodeSample encodes the next set of samples, and returns true if the message is complete
+func (s *Encoder) encodeSample(data *DatasetWithQuality) bool {
 	// encode header and prepare quality values
 	if s.encodedSamples == 0 {
-		s.len = 0
-		s.len += copy(s.buf[s.len:], s.ID[:])
-
-		// encode timestamp
-		binary.BigEndian.PutUint64(s.buf[s.len:], data.T)
-		s.len += 8
+		s.encodeHeader(data.T)
 
 		// record first set of quality
 		for i := range data.Q {
@@ -152,51 +315,48 @@ func (s *Encoder) Encode(data *DatasetWithQuality) ([]byte, int, error) {
 		}
 	}
 
-	for i := range data.Int32s {
-		j := s.encodedSamples // copy for conciseness
-		val := data.Int32s[i]
-
+	for i, val := range data.Int32s {
 		// check if another data stream is to be used the spatial reference
 		if s.spatialRef[i] >= 0 {
 			val -= data.Int32s[s.spatialRef[i]]
 		}
+		s.encodeInt32(i, val)
+	}
 
-		// prepare data for delta encoding
-		if j > 0 {
-			if s.useXOR {
-				s.deltaN[0] = val ^ s.prevData[0].Int32s[i]
-			} else {
-				s.deltaN[0] = val - s.prevData[0].Int32s[i]
-			}
-		}
-		for k := 1; k < min(j, s.deltaEncodingLayers); k++ {
-			if s.useXOR {
-				s.deltaN[k] = s.deltaN[k-1] ^ s.prevData[k].Int32s[i]
-			} else {
-				s.deltaN[k] = s.deltaN[k-1] - s.prevData[k].Int32s[i]
-			}
-		}
+	// int16 values are widened to int32, which cannot overflow during delta encoding
+	for i, val := range data.Int16s {
+		s.encodeInt32(s.Int32Count+i, int32(val))
+	}
 
-		// encode the value
-		if j == 0 {
-			s.encodeSingleSample(i, val)
-		} else {
-			s.encodeSingleSample(i, s.deltaN[min(j-1, s.deltaEncodingLayers-1)])
-		}
+	// store int64 values for encoding at the end of the message
+	for i, val := range data.Int64s {
+		s.int64Values[i][s.encodedSamples] = val
+	}
 
-		// save samples and deltas for next iteration
-		s.prevData[0].Int32s[i] = val
-		for k := 1; k <= min(j, s.deltaEncodingLayers-1); k++ {
-			s.prevData[k].Int32s[i] = s.deltaN[k-1]
-		}
+	// store floating-point values for XOR encoding at the end of the message
+	for i, val := range data.Float32s {
+		s.floatBits[i][s.encodedSamples] = uint64(math.Float32bits(val))
+	}
+	for i, val := range data.Float64s {
+		s.floatBits[s.Float32Count+i][s.encodedSamples] = math.Float64bits(val)
 	}
 
 	s.encodedSamples++
-	if s.encodedSamples >= s.SamplesPerMessage {
-		return s.endEncode()
-	}
+	return s.encodedSamples >= s.SamplesPerMessage
+}
 
-	return nil, 0, nil
+// encodeHeader starts a new message, with the given timestamp of the first sample
+func (s *Encoder) encodeHeader(t uint64) {
+	s.len = 0
+	s.len += copy(s.buf[s.len:], s.ID[:])
+
+	// encode protocol version, and reserve space for the flags which are finalised at the end of the message
+	s.buf[s.len] = ProtocolVersion
+	s.len += 2
+
+	// encode timestamp
+	binary.BigEndian.PutUint64(s.buf[s.len:], t)
+	s.len += 8
 }
 
 // EndEncode ends the encoding early, and completes the buffer so far
@@ -222,45 +382,155 @@ func (s *Encoder) CancelEncode() {
 	// reset previous values
 	s.encodedSamples = 0
 	s.len = 0
+}
+
+// internal version does not need the mutex. The message is written to alternating output buffers, which are
+// allocated when first used.
+func (s *Encoder) endEncode() ([]byte, int, error) {
+	// there is nothing to send if no samples have been encoded
+	if s.encodedSamples == 0 {
+		return nil, 0, nil
+	}
+
+	if s.outBufA == nil {
+		s.outBufA = make([]byte, 0, s.MaxMessageSize())
+		s.outBufB = make([]byte, 0, s.MaxMessageSize())
+	}
 
 	// send data and swap ping-pong buffer
+	s.useBufA = !s.useBufA
 	if s.useBufA {
-		s.useBufA = false
-		s.buf = s.bufB
+		s.outBufA = s.appendMessage(s.outBufA[:0])
+		return s.outBufA, len(s.outBufA), nil
 	}
+	s.outBufB = s.appendMessage(s.outBufB[:0])
+	return s.outBufB, len(s.outBufB), nil
 }
 
-// internal version does not need the mutex
-func (s *Encoder) endEncode() ([]byte, int, error) {
+// appendMessage completes the message, and appends it to dst
+func (s *Encoder) appendMessage(dst []byte) []byte {
+	// there is nothing to send if no samples have been encoded
+	if s.encodedSamples == 0 {
+		return dst
+	}
+
 	// write encoded samples
 	s.len += putVarint32(s.buf[s.len:], int32(s.encodedSamples))
-	actualHeaderLen := s.len
+	headerLen := s.len
+
+	// select the encoding of each integer variable
+	useDescriptors := s.encodings != nil || s.adaptive
+	if s.adaptive {
+		s.chooseEncodings()
+	} else {
+		for i := range s.messageEncodings {
+			s.messageEncodings[i] = s.encoding(i)
+		}
+	}
 
+	// write flags describing the encoding options used for this message
+	var flags byte
 	if s.usingSimple8b {
-		for i := range s.diffs {
-			// ensure slice only contains up to s.encodedSamples
-			actualSamples := min(s.encodedSamples, s.SamplesPerMessage)
+		flags |= FlagSimple8b
+	}
+	if s.useXOR {
+		flags |= FlagXOR
+	}
+	if s.hasSpatialRefs() {
+		flags |= FlagSpatialRefs
+	}
+	if s.useChecksum {
+		flags |= FlagChecksum
+	}
+	if useDescriptors {
+		flags |= FlagColumnDescriptors
+	}
+	s.buf[flagsIndex] = flags
+
+	// the header is never compressed, and an uncompressed payload is written directly to dst
+	start := len(dst)
+	if s.encodedSamples > s.gzipThreshold && s.compressor.ID() != CompressorNone {
+		payloadLen := s.encodePayload(s.buf[headerLen:], useDescriptors)
+		dst = append(dst, s.buf[:headerLen]...)
+		dst = s.compress(dst, start, s.buf[headerLen:headerLen+payloadLen])
+	} else {
+		dst = append(withCapacity(dst, s.MaxMessageSize()), s.buf[:headerLen]...)
+		payloadLen := s.encodePayload(dst[len(dst):cap(dst)], useDescriptors)
+		dst = dst[:len(dst)+payloadLen]
+	}
+
+	// append checksum of the complete message
+	if s.useChecksum {
+		var checksum [ChecksumSize]byte
+		binary.BigEndian.PutUint32(checksum[:], crc32.Checksum(dst[start:], crc32cTable))
+		dst = append(dst, checksum[:]...)
+	}
+
+	// reset previous values
+	s.encodedSamples = 0
+	s.len = 0
 
-			numberOfSimple8b, _ := simple8b.EncodeAllRef(&s.simple8bValues, s.diffs[i][:actualSamples])
+	return dst
+}
+
+// encodePayload writes the message contents after the header to buf, and resets the quality history. It returns the
+// number of bytes written.
+func (s *Encoder) encodePayload(buf []byte, useDescriptors bool) int {
+	length := 0
 
-			// calculate efficiency of simple8b
-			// multiply number of simple8b units by 2 because input is 32-bit, output is 64-bit
-			// simple8bRatio := float64(2*numberOfSimple8b) / float64(actualSamples)
-			// fmt.Println("simple8b efficiency:", simple8bRatio)
+	// write the encoding of each integer variable, if they are not all the same
+	if useDescriptors {
+		length += s.encodeDescriptors(buf[length:])
+	}
+
+	// apply the delta encoding of each int32 (or int16) variable to all the values in the message
+	n := s.encodedSamples
+	for i := range s.raw {
+		if !s.elided[i] {
+			encoding := s.messageEncodings[i]
+			deltaEncode32(s.raw[i][:n], encoding.layers(), encoding.UseXOR)
+		}
+	}
+
+	if s.usingSimple8b {
+		// for simple-8b encoding, each variable is encoded separately
+		diffs := s.int64Diffs[:n]
+		for i := range s.raw {
+			if s.elided[i] {
+				continue
+			}
 
+			zigZagEncode32(diffs, s.raw[i][:n])
+			numberOfSimple8b, _ := simple8b.EncodeAllRef(&s.simple8bValues, diffs)
 			for j := 0; j < numberOfSimple8b; j++ {
-				binary.BigEndian.PutUint64(s.buf[s.len:], s.simple8bValues[j])
-				s.len += 8
+				binary.BigEndian.PutUint64(buf[length:], s.simple8bValues[j])
+				length += 8
 			}
 		}
 	} else {
-		for i := 0; i < s.encodedSamples; i++ {
-			for j := 0; j < s.Int32Count; j++ {
-				s.len += putVarint32(s.buf[s.len:], s.values[i][j])
+		for j := 0; j < n; j++ {
+			for i := range s.raw {
+				if s.elided[i] {
+					continue
+				}
+				length += putVarint32(buf[length:], s.raw[i][j])
 			}
 		}
 	}
 
+	length += s.encodeInt64s(buf[length:])
+
+	// encode floating-point values as a single bit stream
+	w := bitWriter{buf: buf[length:]}
+	for i := range s.floatBits {
+		width := 64
+		if i < s.Float32Count {
+			width = 32
+		}
+		encodeXOR(&w, s.floatBits[i][:s.encodedSamples], width)
+	}
+	length += w.len()
+
 	// encode final quality values using RLE
 	for i := range s.qualityHistory {
 		// override final number of samples to zero
@@ -268,8 +538,8 @@ func (s *Encoder) endEncode() ([]byte, int, error) {
 
 		// otherwise, encode each value
 		for j := range s.qualityHistory[i] {
-			s.len += putUvarint32(s.buf[s.len:], s.qualityHistory[i][j].value)
-			s.len += putUvarint32(s.buf[s.len:], s.qualityHistory[i][j].samples)
+			length += putUvarint32(buf[length:], s.qualityHistory[i][j].value)
+			length += putUvarint32(buf[length:], s.qualityHistory[i][j].samples)
 		}
 	}
 
@@ -280,58 +550,32 @@ func (s *Encoder) endEncode() ([]byte, int, error) {
 		s.qualityHistory[i][0].samples = 0
 	}
 
-	// experiment with Huffman coding
-	// var enc huff0.Scratch
-	// comp, _, err := huff0.Compress4X(s.buf[0:s.len], &enc)
-	// if err == huff0.ErrIncompressible || err == huff0.ErrUseRLE || err == huff0.ErrTooBig {
-	// 	log.Error().Err(err).Msg("huff0 error")
-	// }
-	// log.Debug().Int("huff0 len", len(comp)).Int("original len", s.len).Msg("huff0 output")
+	return length
+}
 
-	// experiment with gzip
-	// TODO determine if bufA/bufB can be replaced with this internal double buffering
-	activeOutBuf := s.outBufA
-	if !s.useBufA {
-		activeOutBuf = s.outBufB
+// compress appends the compressed payload to the message header at the end of dst, starting at the given index, and
+// sets the corresponding header flag. The payload is sent uncompressed if the compressor fails, or if compression would
+// not reduce the size of the message.
+func (s *Encoder) compress(dst []byte, start int, payload []byte) []byte {
+	length := len(dst)
+
+	// gzip is identified by its own flag, so that messages can be read by older decoders
+	flag := byte(FlagGzip)
+	out := dst
+	if s.compressor.ID() != CompressorGzip {
+		flag = FlagCompressed
+		out = append(out, s.compressor.ID())
 	}
 
-	// TODO inspect performance here
-	activeOutBuf.Reset()
-	if s.encodedSamples > UseGzipThresholdSamples {
-		// do not compress header
-		activeOutBuf.Write(s.buf[0:actualHeaderLen])
-
-		gz, _ := gzip.NewWriterLevel(activeOutBuf, gzip.BestCompression) // can test entropy coding by using gzip.HuffmanOnly
-		if _, err := gz.Write(s.buf[actualHeaderLen:s.len]); err != nil {
-			log.Error().Err(err).Msg("could not write gz")
-		}
-		if err := gz.Close(); err != nil {
-			log.Error().Err(err).Msg("could not close gz")
-		}
-
-		// ensure that gzip size is never greater that input for all input sizes
-		if activeOutBuf.Len() > s.len && s.encodedSamples == s.SamplesPerMessage {
-			log.Error().Int("gz", activeOutBuf.Len()).Int("original", s.len).Int("SamplesPerMessage", s.SamplesPerMessage).Msg("gzip encoding length greater")
-		}
+	var err error
+	if c, ok := s.compressor.(*gzipCompressor); ok {
+		out, err = s.gzip.compress(out, payload, c.level)
 	} else {
-		activeOutBuf.Write(s.buf[0:s.len])
+		out, err = s.compressor.Compress(out, payload)
 	}
-
-	// reset previous values
-	// finalLen := s.len
-	s.encodedSamples = 0
-	s.len = 0
-
-	// send data and swap ping-pong buffer
-	if s.useBufA {
-		s.useBufA = false
-		s.buf = s.bufB
-		return activeOutBuf.Bytes(), activeOutBuf.Len(), nil
-		// return s.bufA[0:finalLen], finalLen, nil
+	if err != nil || len(out) >= length+len(payload) {
+		return append(dst[:length], payload...)
 	}
-
-	s.useBufA = true
-	s.buf = s.bufA
-	return activeOutBuf.Bytes(), activeOutBuf.Len(), nil
-	// return s.bufB[0:finalLen], finalLen, nil
+	out[start+flagsIndex] |= flag
+	return out
 }
@@ -0,0 +1,478 @@
+package slipstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// A Slipstream capture file (.slip) contains:
+//
+//	file header:  "SLIP" | version (1 byte) | uvarint length + configuration frame | uvarint channel count |
+//	              for each channel: uvarint length + name | uvarint length + unit | scale (float64) | offset (float64)
+//	messages:     for each message: uvarint length + encoded message
+//	end marker:   uvarint 0
+//	index:        for each message: offset of the length prefix (uint64) | start timestamp (uint64)
+//	trailer:      offset of the index (uint64) | message count (uint64) | "SLIX"
+//
+// All fixed-size integers are big-endian. The messages use the same framing as StreamWriter. The index is sorted by
+// timestamp. If the index is missing, the messages can still be found by reading the length prefix of each message.
+
+// FileVersion is the version of the capture file format
+const FileVersion = 1
+
+var (
+	fileMagic    = [4]byte{'S', 'L', 'I', 'P'}
+	trailerMagic = [4]byte{'S', 'L', 'I', 'X'}
+)
+
+const (
+	indexEntrySize = 16
+	trailerSize    = 8 + 8 + len(trailerMagic)
+)
+
+// ErrInvalidFile is returned when a capture file is invalid or incomplete
+var ErrInvalidFile = errors.New("invalid capture file")
+
+// errMissingIndex indicates that a capture file does not end with an index
+var errMissingIndex = errors.New("missing capture file index")
+
+// Channel describes a variable in a capture file. Values are converted to engineering units as Scale*x + Offset.
+type Channel struct {
+	Name   string
+	Unit   string
+	Scale  float64
+	Offset float64
+}
+
+// Value converts a value to engineering units
+func (c *Channel) Value(x int64) float64 {
+	return c.Scale*float64(x) + c.Offset
+}
+
+// IndexEntry describes the location and start timestamp of a message in a capture file
+type IndexEntry struct {
+	Offset int64
+	T      uint64
+}
+
+// FileWriter writes a capture file, containing the samples encoded by an Encoder
+type FileWriter struct {
+	w      io.Writer
+	enc    *Encoder
+	offset int64
+	index  []IndexEntry
+	closed bool
+}
+
+// NewFileWriter creates a FileWriter and writes the file header. The channels may be nil, or must describe every
+// variable of the encoder in the order used by DatasetWithQuality.Q.
+func NewFileWriter(w io.Writer, enc *Encoder, channels []Channel) (*FileWriter, error) {
+	cfg := enc.Config()
+	if channels != nil && len(channels) != cfg.variableCount() {
+		return nil, ErrInvalidConfig
+	}
+
+	header := append([]byte(nil), fileMagic[:]...)
+	header = append(header, FileVersion)
+	header = appendBytes(header, cfg.Encode())
+	header = appendUvarint(header, uint64(len(channels)))
+	for _, ch := range channels {
+		header = appendBytes(header, []byte(ch.Name))
+		header = appendBytes(header, []byte(ch.Unit))
+		header = appendUint64(header, math.Float64bits(ch.Scale))
+		header = appendUint64(header, math.Float64bits(ch.Offset))
+	}
+
+	f := &FileWriter{w: w, enc: enc}
+	if err := f.write(header); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// CreateFile creates a capture file with the given name
+func CreateFile(name string, enc *Encoder, channels []Channel) (*FileWriter, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := NewFileWriter(file, enc, channels)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write encodes the next sample, and writes a message to the file when it is complete
+func (f *FileWriter) Write(data *DatasetWithQuality) error {
+	buf, length, err := f.enc.Encode(data)
+	if err != nil {
+		return err
+	}
+	return f.writeMessage(buf[:length])
+}
+
+// Close writes any partially encoded message, followed by the index. If the underlying writer is an io.Closer, it is
+// also closed.
+func (f *FileWriter) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+
+	buf, length, err := f.enc.EndEncode()
+	if err != nil {
+		return err
+	}
+	if err := f.writeMessage(buf[:length]); err != nil {
+		return err
+	}
+
+	footer := appendUvarint(nil, 0)
+	indexOffset := f.offset + int64(len(footer))
+	for _, entry := range f.index {
+		footer = appendUint64(footer, uint64(entry.Offset))
+		footer = appendUint64(footer, entry.T)
+	}
+	footer = appendUint64(footer, uint64(indexOffset))
+	footer = appendUint64(footer, uint64(len(f.index)))
+	footer = append(footer, trailerMagic[:]...)
+	if err := f.write(footer); err != nil {
+		return err
+	}
+
+	if closer, ok := f.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (f *FileWriter) writeMessage(msg []byte) error {
+	if len(msg) == 0 {
+		return nil
+	}
+
+	f.index = append(f.index, IndexEntry{Offset: f.offset, T: messageTimestamp(msg)})
+	if err := f.write(appendUvarint(nil, uint64(len(msg)))); err != nil {
+		return err
+	}
+	return f.write(msg)
+}
+
+func (f *FileWriter) write(buf []byte) error {
+	n, err := f.w.Write(buf)
+	f.offset += int64(n)
+	return err
+}
+
+// FileReader provides random access to the messages in a capture file
+type FileReader struct {
+	r        io.ReaderAt
+	closer   io.Closer
+	config   *Config
+	channels []Channel
+	index    []IndexEntry
+	dec      *Decoder
+	buf      []byte
+}
+
+// NewFileReader reads the header and index of a capture file of the given size. If the file does not end with an
+// index, such as when the writer stopped before Close() was called, the messages are scanned instead, up to the first
+// incomplete message.
+func NewFileReader(r io.ReaderAt, size int64) (*FileReader, error) {
+	f := &FileReader{r: r}
+	offset, err := f.readHeader(size)
+	if err != nil {
+		return nil, err
+	}
+	f.dec = newDecoder(f.config)
+
+	err = f.readIndex(size)
+	if err == errMissingIndex {
+		f.index, _, _ = scanFrames(r, offset, size, f.dec, &f.buf)
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// OpenFile opens a capture file with the given name
+func OpenFile(name string) (*FileReader, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f, err := NewFileReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f.closer = file
+	return f, nil
+}
+
+// Close closes the file, if it was opened using OpenFile()
+func (f *FileReader) Close() error {
+	if f.closer != nil {
+		return f.closer.Close()
+	}
+	return nil
+}
+
+// Config returns the stream configuration
+func (f *FileReader) Config() Config {
+	return *f.config
+}
+
+// Channels returns the channel descriptions, which may be empty
+func (f *FileReader) Channels() []Channel {
+	return f.channels
+}
+
+// Index returns the location and start timestamp of every message
+func (f *FileReader) Index() []IndexEntry {
+	return f.index
+}
+
+// Decoder returns the decoder used for reading messages
+func (f *FileReader) Decoder() *Decoder {
+	return f.dec
+}
+
+// FindMessage returns the index of the message containing the sample with timestamp t (in nanoseconds), which is the
+// last message starting at or before t. It returns -1 if t is before the first message.
+func (f *FileReader) FindMessage(t uint64) int {
+	return sort.Search(len(f.index), func(i int) bool { return f.index[i].T > t }) - 1
+}
+
+// ReadMessage decodes the message with the given index. The returned samples are only valid until the next call.
+func (f *FileReader) ReadMessage(i int) ([]DatasetWithQuality, error) {
+	if i < 0 || i >= len(f.index) {
+		return nil, ErrInvalidFile
+	}
+
+	msg, err := f.readFrame(f.index[i].Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := f.dec.DecodeToBuffer(msg, len(msg))
+	if err != nil {
+		return nil, err
+	}
+	return f.dec.Out[:samples], nil
+}
+
+// readFrame reads the length-prefixed frame at the given offset
+func (f *FileReader) readFrame(offset int64) ([]byte, error) {
+	frame, _, err := readFrameAt(f.r, offset, uint64(f.dec.maxMessageSize()), &f.buf)
+	if err != nil {
+		return nil, ErrInvalidFile
+	}
+	return frame, nil
+}
+
+// readFrameAt reads the length-prefixed frame at the given offset into buf, which is grown if required. It returns
+// the frame and the total length including the length prefix.
+func readFrameAt(r io.ReaderAt, offset int64, maxLength uint64, buf *[]byte) ([]byte, int64, error) {
+	length, lenB, err := readFrameLength(r, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if length > maxLength {
+		return nil, 0, ErrFrameTooLarge
+	}
+
+	frame, err := readAt(r, offset+int64(lenB), int(length), buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	return frame, int64(lenB) + int64(length), nil
+}
+
+// readFrameLength reads the length prefix of the frame at the given offset. It returns the length of the frame and
+// the length of the prefix.
+func readFrameLength(r io.ReaderAt, offset int64) (uint64, int, error) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n, err := r.ReadAt(lenBuf[:], offset)
+	if n == 0 {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, err
+	}
+	length, lenB := binary.Uvarint(lenBuf[:n])
+	if lenB == 0 {
+		return 0, 0, ErrTruncated
+	}
+	if lenB < 0 {
+		return 0, 0, ErrMalformedVarint
+	}
+	return length, lenB, nil
+}
+
+// readAt reads n bytes at the given offset into buf, which is grown if required
+func readAt(r io.ReaderAt, offset int64, n int, buf *[]byte) ([]byte, error) {
+	if cap(*buf) < n {
+		*buf = make([]byte, n)
+	}
+	*buf = (*buf)[:n]
+	if _, err := r.ReadAt(*buf, offset); err != nil {
+		return nil, payloadError(err)
+	}
+	return *buf, nil
+}
+
+// readHeader reads the file header, and returns the offset of the first message
+func (f *FileReader) readHeader(size int64) (int64, error) {
+	r := io.NewSectionReader(f.r, 0, size)
+	br := &byteReader{r: r}
+
+	var magic [len(fileMagic)]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil || magic != fileMagic {
+		return 0, ErrInvalidFile
+	}
+	version, err := br.ReadByte()
+	if err != nil || version != FileVersion {
+		return 0, ErrInvalidFile
+	}
+
+	cfg, err := readBytes(br, maxConfigFrameSize)
+	if err != nil {
+		return 0, ErrInvalidFile
+	}
+	if f.config, err = DecodeConfig(cfg); err != nil {
+		return 0, err
+	}
+
+	channelCount, err := binary.ReadUvarint(br)
+	if err != nil || (channelCount != 0 && channelCount != uint64(f.config.variableCount())) {
+		return 0, ErrInvalidFile
+	}
+	f.channels = make([]Channel, channelCount)
+	for i := range f.channels {
+		name, err := readBytes(br, maxConfigFrameSize)
+		if err != nil {
+			return 0, ErrInvalidFile
+		}
+		unit, err := readBytes(br, maxConfigFrameSize)
+		if err != nil {
+			return 0, ErrInvalidFile
+		}
+		var scaling [8 * 2]byte
+		if _, err := io.ReadFull(br, scaling[:]); err != nil {
+			return 0, ErrInvalidFile
+		}
+		f.channels[i] = Channel{
+			Name:   string(name),
+			Unit:   string(unit),
+			Scale:  math.Float64frombits(binary.BigEndian.Uint64(scaling[0:])),
+			Offset: math.Float64frombits(binary.BigEndian.Uint64(scaling[8:])),
+		}
+	}
+	return r.Seek(0, io.SeekCurrent)
+}
+
+func (f *FileReader) readIndex(size int64) error {
+	if size < int64(trailerSize) {
+		return errMissingIndex
+	}
+
+	var trailer [trailerSize]byte
+	if _, err := f.r.ReadAt(trailer[:], size-int64(trailerSize)); err != nil {
+		return ErrInvalidFile
+	}
+	if string(trailer[16:]) != string(trailerMagic[:]) {
+		return errMissingIndex
+	}
+	indexOffset := binary.BigEndian.Uint64(trailer[0:])
+	count := binary.BigEndian.Uint64(trailer[8:])
+	if indexOffset > uint64(size) || count > (uint64(size)-indexOffset)/indexEntrySize ||
+		indexOffset+count*indexEntrySize != uint64(size)-uint64(trailerSize) {
+		return ErrInvalidFile
+	}
+
+	buf := make([]byte, count*indexEntrySize)
+	if _, err := f.r.ReadAt(buf, int64(indexOffset)); err != nil {
+		return ErrInvalidFile
+	}
+	f.index = make([]IndexEntry, count)
+	for i := range f.index {
+		f.index[i].Offset = int64(binary.BigEndian.Uint64(buf[i*indexEntrySize:]))
+		f.index[i].T = binary.BigEndian.Uint64(buf[i*indexEntrySize+8:])
+		if f.index[i].Offset < 0 || uint64(f.index[i].Offset) >= indexOffset {
+			return ErrInvalidFile
+		}
+
+		// FindMessage requires the index to be sorted by timestamp
+		if i > 0 && f.index[i].T < f.index[i-1].T {
+			return ErrInvalidFile
+		}
+	}
+	return nil
+}
+
+// messageTimestamp returns the start timestamp of an encoded message
+func messageTimestamp(msg []byte) uint64 {
+	return binary.BigEndian.Uint64(msg[flagsIndex+1:])
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+// appendBytes appends a uvarint length followed by the bytes
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// readBytes reads a uvarint length followed by the bytes
+func readBytes(r *byteReader, maxLength uint64) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxLength {
+		return nil, ErrInvalidFile
+	}
+	buf := make([]byte, length)
+	_, err = io.ReadFull(r, buf)
+	return buf, err
+}
+
+// byteReader adds io.ByteReader to an io.Reader
+type byteReader struct {
+	r io.Reader
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
+
+func (b *byteReader) ReadByte() (byte, error) {
+	var buf [1]byte
+	_, err := io.ReadFull(b.r, buf[:])
+	return buf[0], err
+}
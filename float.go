@@ -0,0 +1,182 @@
+package slipstream
+
+import "math/bits"
+
+// Floating-point variables are encoded using the XOR of each value with the previous value, similar to the
+// Facebook Gorilla method (also used by BTrDB). Each XOR result is encoded as:
+//
+//	'0'                                   value is identical to the previous value
+//	'10' <meaningful bits>                meaningful bits fit within the previous leading and trailing zero window
+//	'11' <leading> <length-1> <meaningful bits>
+//
+// where the number of leading zeros and the meaningful bit length use 5 bits for 32-bit values and 6 bits for
+// 64-bit values. All floating-point columns in a message are written to a single bit stream, which is padded to a
+// whole number of bytes.
+
+// bitWriter writes a sequence of bits into a byte slice, which must be large enough for all bits written
+type bitWriter struct {
+	buf []byte
+	pos int // number of bits written
+}
+
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for n > 0 {
+		byteIndex := w.pos >> 3
+		free := 8 - w.pos&7
+		if free == 8 {
+			w.buf[byteIndex] = 0
+		}
+
+		take := min(free, n)
+		chunk := byte(v>>(n-take)) & byte(1<<take-1)
+		w.buf[byteIndex] |= chunk << (free - take)
+
+		n -= take
+		w.pos += take
+	}
+}
+
+// len returns the number of bytes used, including any padding in the final byte
+func (w *bitWriter) len() int {
+	return (w.pos + 7) >> 3
+}
+
+// bitReader reads a sequence of bits from a byte slice
+type bitReader struct {
+	buf []byte
+	pos int // number of bits read
+}
+
+func (r *bitReader) readBits(n int) (uint64, error) {
+	if r.pos+n > len(r.buf)*8 {
+		return 0, ErrTruncated
+	}
+
+	var v uint64
+	for n > 0 {
+		byteIndex := r.pos >> 3
+		available := 8 - r.pos&7
+
+		take := min(available, n)
+		chunk := (r.buf[byteIndex] >> (available - take)) & byte(1<<take-1)
+		v = v<<take | uint64(chunk)
+
+		n -= take
+		r.pos += take
+	}
+	return v, nil
+}
+
+// len returns the number of bytes read, including any padding in the final byte
+func (r *bitReader) len() int {
+	return (r.pos + 7) >> 3
+}
+
+// xorLengthBits returns the number of bits used to encode the leading zeros and meaningful bit length for values of
+// the given width
+func xorLengthBits(width int) int {
+	return bits.Len(uint(width - 1))
+}
+
+// maxXORBytes returns the maximum number of bytes needed to encode the given number of values
+func maxXORBytes(samples int, width int) int {
+	return (samples*(2+2*xorLengthBits(width)+width) + 7) / 8
+}
+
+// encodeXOR encodes a column of floating-point values (provided as their IEEE 754 bit patterns, with the given width
+// in bits)
+func encodeXOR(w *bitWriter, values []uint64, width int) {
+	if len(values) == 0 {
+		return
+	}
+
+	lengthBits := xorLengthBits(width)
+	w.writeBits(values[0], width)
+
+	prevLeading := -1
+	prevTrailing := 0
+	for i := 1; i < len(values); i++ {
+		x := values[i] ^ values[i-1]
+		if x == 0 {
+			w.writeBits(0, 1)
+			continue
+		}
+
+		leading := bits.LeadingZeros64(x) - (64 - width)
+		trailing := bits.TrailingZeros64(x)
+
+		if prevLeading >= 0 && leading >= prevLeading && trailing >= prevTrailing {
+			// re-use the previous window of meaningful bits
+			w.writeBits(0b10, 2)
+			w.writeBits(x>>prevTrailing, width-prevLeading-prevTrailing)
+		} else {
+			meaningful := width - leading - trailing
+			w.writeBits(0b11, 2)
+			w.writeBits(uint64(leading), lengthBits)
+			w.writeBits(uint64(meaningful-1), lengthBits)
+			w.writeBits(x>>trailing, meaningful)
+			prevLeading = leading
+			prevTrailing = trailing
+		}
+	}
+}
+
+// decodeXOR decodes a column of floating-point values into dst, as their bit patterns
+func decodeXOR(r *bitReader, dst []uint64, width int) error {
+	if len(dst) == 0 {
+		return nil
+	}
+
+	lengthBits := xorLengthBits(width)
+	value, err := r.readBits(width)
+	if err != nil {
+		return err
+	}
+	dst[0] = value
+
+	prevLeading := -1
+	prevTrailing := 0
+	for i := 1; i < len(dst); i++ {
+		changed, err := r.readBits(1)
+		if err != nil {
+			return err
+		}
+		if changed == 0 {
+			dst[i] = value
+			continue
+		}
+
+		newWindow, err := r.readBits(1)
+		if err != nil {
+			return err
+		}
+
+		if newWindow == 1 {
+			leading, err := r.readBits(lengthBits)
+			if err != nil {
+				return err
+			}
+			meaningful, err := r.readBits(lengthBits)
+			if err != nil {
+				return err
+			}
+			prevLeading = int(leading)
+			prevTrailing = width - prevLeading - int(meaningful) - 1
+			if prevTrailing < 0 {
+				return ErrMalformedFloat
+			}
+		} else if prevLeading < 0 {
+			// the previous window must have been defined
+			return ErrMalformedFloat
+		}
+
+		x, err := r.readBits(width - prevLeading - prevTrailing)
+		if err != nil {
+			return err
+		}
+		value ^= x << prevTrailing
+		dst[i] = value
+	}
+
+	return nil
+}
@@ -3,7 +3,6 @@ module github.com/synaptecltd/slipstream
 go 1.18
 
 require (
-	github.com/gocarina/gocsv v0.0.0-20230223115542-dc4ee9de5fe2
 	github.com/google/uuid v1.3.0
 	github.com/jedib0t/go-pretty/v6 v6.4.4
 	github.com/klauspost/compress v1.15.15
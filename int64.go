@@ -0,0 +1,134 @@
+package slipstream
+
+import (
+	"encoding/binary"
+
+	"github.com/synaptecltd/encoding/bitops"
+	"github.com/synaptecltd/encoding/simple8b"
+)
+
+// Int64 variables use the same delta encoding as int32 variables, but are encoded one variable at a time. For messages
+// using simple-8b, each variable is preceded by an encoding byte because some int64 values cannot be represented
+// using simple-8b. Otherwise, each value is encoded as a varint.
+const (
+	int64EncodingSimple8b byte = 0
+	int64EncodingVarint   byte = 1
+)
+
+// isConstant64 reports whether all values are the same
+func isConstant64(values []int64) bool {
+	for _, v := range values {
+		if v != values[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeInt64s encodes the int64 variables, returning the number of bytes written to buf
+func (s *Encoder) encodeInt64s(buf []byte) int {
+	length := 0
+	int32Count := s.Int32Count + s.Int16Count
+	for i := range s.int64Values {
+		if s.elided[int32Count+i] {
+			continue
+		}
+
+		encoding := s.messageEncodings[int32Count+i]
+		values := s.int64Values[i][:s.encodedSamples]
+		deltaEncode64(values, encoding.layers(), encoding.UseXOR)
+
+		useSimple8b := s.usingSimple8b
+		for j, v := range values {
+			s.int64Diffs[j] = bitops.ZigZagEncode64(v)
+			if s.int64Diffs[j] > simple8b.MaxValue {
+				useSimple8b = false
+			}
+		}
+		diffs := s.int64Diffs[:s.encodedSamples]
+
+		if s.usingSimple8b {
+			if useSimple8b {
+				buf[length] = int64EncodingSimple8b
+			} else {
+				buf[length] = int64EncodingVarint
+			}
+			length++
+		}
+
+		if useSimple8b {
+			numberOfSimple8b, _ := simple8b.EncodeAllRef(&s.simple8bValues, diffs)
+			for j := 0; j < numberOfSimple8b; j++ {
+				binary.BigEndian.PutUint64(buf[length:], s.simple8bValues[j])
+				length += 8
+			}
+		} else {
+			for _, v := range diffs {
+				length += binary.PutUvarint(buf[length:], v)
+			}
+		}
+	}
+	return length
+}
+
+// decodeInt64s decodes the int64 variables, returning the number of bytes read from buf
+func (s *Decoder) decodeInt64s(buf []byte, samples int) (int, error) {
+	length := 0
+	int32Count := s.Int32Count + s.Int16Count
+	values := s.int64Values[:samples]
+	for i := 0; i < s.Int64Count; i++ {
+		if s.elided[int32Count+i] {
+			continue
+		}
+
+		useSimple8b := s.usingSimple8b
+		if s.usingSimple8b {
+			if length >= len(buf) {
+				return 0, ErrTruncated
+			}
+			switch buf[length] {
+			case int64EncodingSimple8b:
+			case int64EncodingVarint:
+				useSimple8b = false
+			default:
+				return 0, ErrMalformedInt64
+			}
+			length++
+		}
+
+		if useSimple8b {
+			decoded := 0
+			for decoded < samples {
+				if length+8 > len(buf) {
+					return 0, ErrTruncated
+				}
+				n, err := simple8b.Decode(&s.simple8bValues, binary.BigEndian.Uint64(buf[length:]))
+				if err != nil {
+					return 0, err
+				}
+				length += 8
+
+				for _, v := range s.simple8bValues[:min(n, samples-decoded)] {
+					values[decoded] = bitops.ZigZagDecode64(v)
+					decoded++
+				}
+			}
+		} else {
+			for j := range values {
+				v, lenB := binary.Uvarint(buf[length:])
+				if err := varintError(lenB); err != nil {
+					return 0, err
+				}
+				values[j] = bitops.ZigZagDecode64(v)
+				length += lenB
+			}
+		}
+
+		encoding := s.encodings[int32Count+i]
+		deltaDecode64(values, encoding.layers(), encoding.UseXOR)
+		for j, v := range values {
+			s.Out[j].Int64s[i] = v
+		}
+	}
+	return length, nil
+}
@@ -0,0 +1,146 @@
+package slipstream
+
+// Column kernels apply delta encoding, zig-zag encoding and their inverses to all the values of a variable in a
+// message. The type of delta encoding is selected once for each layer, rather than for each value, and each loop only
+// accesses sub-slices which the compiler can prove are in range, so there are no branches or bounds checks in the
+// loop bodies. Delta encoding with k layers replaces sample j by the min(j, k)-th order difference, which is applied
+// as k passes of first-order differences, with pass k starting at sample k-1. Decoding applies the corresponding
+// prefix sums in reverse order.
+
+// deltaEncode32 applies delta encoding to a column of values in place
+func deltaEncode32(values []int32, layers int, useXOR bool) {
+	for k := 1; k <= layers && k < len(values); k++ {
+		if useXOR {
+			xorDelta32(values[k-1:])
+		} else {
+			subDelta32(values[k-1:])
+		}
+	}
+}
+
+// deltaDecode32 reverses deltaEncode32
+func deltaDecode32(values []int32, layers int, useXOR bool) {
+	for k := min(layers, len(values)-1); k >= 1; k-- {
+		if useXOR {
+			prefixXOR32(values[k-1:])
+		} else {
+			prefixSum32(values[k-1:])
+		}
+	}
+}
+
+// subDelta32 replaces each value, after the first, by the difference from the previous value
+func subDelta32(values []int32) {
+	prev := values[0]
+	rest := values[1:]
+	for j, v := range rest {
+		rest[j] = v - prev
+		prev = v
+	}
+}
+
+// xorDelta32 replaces each value, after the first, by the XOR with the previous value
+func xorDelta32(values []int32) {
+	prev := values[0]
+	rest := values[1:]
+	for j, v := range rest {
+		rest[j] = v ^ prev
+		prev = v
+	}
+}
+
+// prefixSum32 reverses subDelta32
+func prefixSum32(values []int32) {
+	sum := values[0]
+	rest := values[1:]
+	for j, v := range rest {
+		sum += v
+		rest[j] = sum
+	}
+}
+
+// prefixXOR32 reverses xorDelta32
+func prefixXOR32(values []int32) {
+	sum := values[0]
+	rest := values[1:]
+	for j, v := range rest {
+		sum ^= v
+		rest[j] = sum
+	}
+}
+
+// zigZagEncode32 writes the zig-zag encoding of each value to dst, which must be at least as long as values. This is
+// equivalent to bitops.ZigZagEncode64(int64(v)), without widening to 64 bits.
+func zigZagEncode32(dst []uint64, values []int32) {
+	dst = dst[:len(values)]
+	for j, v := range values {
+		dst[j] = uint64(uint32(v<<1) ^ uint32(v>>31))
+	}
+}
+
+// zigZagDecode32 writes the value of each zig-zag encoded value to dst, which must be at least as long as src. This
+// is equivalent to int32(bitops.ZigZagDecode64(v)), including for values which are too large for an int32.
+func zigZagDecode32(dst []int32, src []uint64) {
+	dst = dst[:len(src)]
+	for j, v := range src {
+		dst[j] = int32(uint32(v>>1)) ^ -int32(v&1)
+	}
+}
+
+// deltaEncode64 applies delta encoding to a column of int64 values in place
+func deltaEncode64(values []int64, layers int, useXOR bool) {
+	for k := 1; k <= layers && k < len(values); k++ {
+		if useXOR {
+			xorDelta64(values[k-1:])
+		} else {
+			subDelta64(values[k-1:])
+		}
+	}
+}
+
+// deltaDecode64 reverses deltaEncode64
+func deltaDecode64(values []int64, layers int, useXOR bool) {
+	for k := min(layers, len(values)-1); k >= 1; k-- {
+		if useXOR {
+			prefixXOR64(values[k-1:])
+		} else {
+			prefixSum64(values[k-1:])
+		}
+	}
+}
+
+func subDelta64(values []int64) {
+	prev := values[0]
+	rest := values[1:]
+	for j, v := range rest {
+		rest[j] = v - prev
+		prev = v
+	}
+}
+
+func xorDelta64(values []int64) {
+	prev := values[0]
+	rest := values[1:]
+	for j, v := range rest {
+		rest[j] = v ^ prev
+		prev = v
+	}
+}
+
+func prefixSum64(values []int64) {
+	sum := values[0]
+	rest := values[1:]
+	for j, v := range rest {
+		sum += v
+		rest[j] = sum
+	}
+}
+
+func prefixXOR64(values []int64) {
+	sum := values[0]
+	rest := values[1:]
+	for j, v := range rest {
+		sum ^= v
+		rest[j] = sum
+	}
+}
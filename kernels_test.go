@@ -0,0 +1,238 @@
+package slipstream
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/encoding/bitops"
+)
+
+// The column kernels are tested and benchmarked against a reference implementation of the per-value loops which the
+// encoder and decoder used before the kernels. The reference processes one sample of every variable at a time, and
+// branches on the type of delta encoding and the number of layers for each value.
+
+// deltaReference holds the delta encoding state of the reference implementation for each variable
+type deltaReference struct {
+	prevData [MaxDeltaEncodingLayers][]int32
+	deltaN   [MaxDeltaEncodingLayers]int32
+	deltaSum [MaxDeltaEncodingLayers - 1][]int32
+}
+
+func newDeltaReference(variables int) *deltaReference {
+	r := &deltaReference{}
+	for k := range r.prevData {
+		r.prevData[k] = make([]int32, variables)
+	}
+	for k := range r.deltaSum {
+		r.deltaSum[k] = make([]int32, variables)
+	}
+	return r
+}
+
+// encode applies delta and zig-zag encoding to the columns of values, one sample at a time
+func (r *deltaReference) encode(out [][]uint64, values [][]int32, layers int, useXOR bool) {
+	for j := range values[0] {
+		for i := range values {
+			val := values[i][j]
+
+			// raw values do not need delta encoding
+			if layers == 0 {
+				out[i][j] = bitops.ZigZagEncode64(int64(val))
+				continue
+			}
+
+			if j > 0 {
+				if useXOR {
+					r.deltaN[0] = val ^ r.prevData[0][i]
+				} else {
+					r.deltaN[0] = val - r.prevData[0][i]
+				}
+			}
+			for k := 1; k < min(j, layers); k++ {
+				if useXOR {
+					r.deltaN[k] = r.deltaN[k-1] ^ r.prevData[k][i]
+				} else {
+					r.deltaN[k] = r.deltaN[k-1] - r.prevData[k][i]
+				}
+			}
+
+			if j == 0 {
+				out[i][j] = bitops.ZigZagEncode64(int64(val))
+			} else {
+				out[i][j] = bitops.ZigZagEncode64(int64(r.deltaN[min(j-1, layers-1)]))
+			}
+
+			// save samples and deltas for the next sample
+			r.prevData[0][i] = val
+			for k := 1; k <= min(j, layers-1); k++ {
+				r.prevData[k][i] = r.deltaN[k-1]
+			}
+		}
+	}
+}
+
+// decode reverses encode, one sample at a time
+func (r *deltaReference) decode(out [][]int32, in [][]uint64, layers int, useXOR bool) {
+	for k := range r.deltaSum {
+		for i := range r.deltaSum[k] {
+			r.deltaSum[k][i] = 0
+		}
+	}
+
+	for j := range in[0] {
+		for i := range in {
+			decodedValue := int32(bitops.ZigZagDecode64(in[i][j]))
+			if j == 0 || layers == 0 {
+				out[i][j] = decodedValue
+				continue
+			}
+
+			maxIndex := min(j, layers-1) - 1
+			delta := decodedValue
+			if maxIndex >= 0 {
+				if useXOR {
+					r.deltaSum[maxIndex][i] ^= decodedValue
+				} else {
+					r.deltaSum[maxIndex][i] += decodedValue
+				}
+				for k := maxIndex; k >= 1; k-- {
+					if useXOR {
+						r.deltaSum[k-1][i] ^= r.deltaSum[k][i]
+					} else {
+						r.deltaSum[k-1][i] += r.deltaSum[k][i]
+					}
+				}
+				delta = r.deltaSum[0][i]
+			}
+
+			if useXOR {
+				out[i][j] = out[i][j-1] ^ delta
+			} else {
+				out[i][j] = out[i][j-1] + delta
+			}
+		}
+	}
+}
+
+// kernelEncode applies delta and zig-zag encoding to the columns of values using the column kernels, in the same way
+// as the encoder
+func kernelEncode(out [][]uint64, values [][]int32, column []int32, layers int, useXOR bool) {
+	for i := range values {
+		column = column[:len(values[i])]
+		copy(column, values[i])
+		deltaEncode32(column, layers, useXOR)
+		zigZagEncode32(out[i], column)
+	}
+}
+
+// kernelDecode reverses kernelEncode, in the same way as the decoder
+func kernelDecode(out [][]int32, in [][]uint64, layers int, useXOR bool) {
+	for i := range in {
+		column := out[i][:len(in[i])]
+		zigZagDecode32(column, in[i])
+		deltaDecode32(column, layers, useXOR)
+	}
+}
+
+// kernelTestData returns columns of sinusoidal values with noise, similar to sampled voltages and currents
+func kernelTestData(variables int, samples int, samplingRate int) [][]int32 {
+	rnd := rand.New(rand.NewSource(1))
+	values := make([][]int32, variables)
+	for i := range values {
+		values[i] = make([]int32, samples)
+		phase := 2 * math.Pi * float64(i%3) / 3
+		for j := range values[i] {
+			t := float64(j) / float64(samplingRate)
+			values[i][j] = int32(30000*math.Sin(2*math.Pi*50*t+phase) + 20*rnd.NormFloat64())
+		}
+	}
+	return values
+}
+
+func makeColumns(variables int, samples int) ([][]uint64, [][]int32) {
+	encoded := make([][]uint64, variables)
+	decoded := make([][]int32, variables)
+	for i := range encoded {
+		encoded[i] = make([]uint64, samples)
+		decoded[i] = make([]int32, samples)
+	}
+	return encoded, decoded
+}
+
+func TestColumnKernels(t *testing.T) {
+	for _, samples := range []int{1, 2, 5, 80, 4000} {
+		values := kernelTestData(4, samples, 4000)
+
+		// extreme values cause every layer of arithmetic delta encoding to overflow
+		extremes := []int32{math.MinInt32, math.MaxInt32, 0, -1, 1, math.MinInt32 + 1, math.MaxInt32 - 1}
+		for j := 0; j < samples; j++ {
+			values[2][j] = extremes[j%len(extremes)]
+			values[3][j] = extremes[(j*j)%len(extremes)]
+		}
+
+		for layers := 0; layers <= MaxDeltaEncodingLayers; layers++ {
+			for _, useXOR := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%d/%d/xor=%v", samples, layers, useXOR), func(t *testing.T) {
+					ref := newDeltaReference(len(values))
+					expected, expectedValues := makeColumns(len(values), samples)
+					ref.encode(expected, values, layers, useXOR)
+					ref.decode(expectedValues, expected, layers, useXOR)
+					assert.Equal(t, values, expectedValues)
+
+					encoded, decoded := makeColumns(len(values), samples)
+					kernelEncode(encoded, values, make([]int32, samples), layers, useXOR)
+					assert.Equal(t, expected, encoded)
+					kernelDecode(decoded, encoded, layers, useXOR)
+					assert.Equal(t, values, decoded)
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkDeltaKernels compares the column kernels with the reference per-value implementation, for one second of
+// eight variables at high sampling rates
+func BenchmarkDeltaKernels(b1 *testing.B) {
+	const variables = 8
+	for _, samplingRate := range []int{14400, 40000} {
+		values := kernelTestData(variables, samplingRate, samplingRate)
+		ref := newDeltaReference(variables)
+		column := make([]int32, samplingRate)
+		encoded, decoded := makeColumns(variables, samplingRate)
+
+		for _, layers := range []int{1, 2, 3, 4, MaxDeltaEncodingLayers} {
+			for _, useXOR := range []bool{false, true} {
+				name := fmt.Sprintf("%d/layers=%d/xor=%v", samplingRate, layers, useXOR)
+				bytes := int64(samplingRate * variables * 4)
+
+				b1.Run(name+"/encode/reference", func(b *testing.B) {
+					b.SetBytes(bytes)
+					for i := 0; i < b.N; i++ {
+						ref.encode(encoded, values, layers, useXOR)
+					}
+				})
+				b1.Run(name+"/encode/kernel", func(b *testing.B) {
+					b.SetBytes(bytes)
+					for i := 0; i < b.N; i++ {
+						kernelEncode(encoded, values, column, layers, useXOR)
+					}
+				})
+				b1.Run(name+"/decode/reference", func(b *testing.B) {
+					b.SetBytes(bytes)
+					for i := 0; i < b.N; i++ {
+						ref.decode(decoded, encoded, layers, useXOR)
+					}
+				})
+				b1.Run(name+"/decode/kernel", func(b *testing.B) {
+					b.SetBytes(bytes)
+					for i := 0; i < b.N; i++ {
+						kernelDecode(decoded, encoded, layers, useXOR)
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package slipstream
+
+// Quality is the quality of a sample, stored in DatasetWithQuality.Q as a uint32. The two least significant bytes
+// contain the IEC 61850 data quality, using the same bit order as IEC 61850-9-2 SV, and the most significant byte
+// contains the IEC 61850 time quality. Quality values can be converted to and from uint32 directly:
+//
+//	q := slipstream.Quality(data.Q[i])
+//	q.SetValidity(slipstream.ValidityQuestionable)
+//	data.Q[i] = uint32(q)
+type Quality uint32
+
+// Validity is the validity of a sample
+type Validity uint32
+
+// Validity values
+const (
+	ValidityGood         Validity = 0
+	ValidityInvalid      Validity = 1
+	ValidityReserved     Validity = 2
+	ValidityQuestionable Validity = 3
+)
+
+// Source indicates whether a sample is from the process, or has been substituted
+type Source uint32
+
+// Source values
+const (
+	SourceProcess     Source = 0
+	SourceSubstituted Source = 1
+)
+
+// Data quality bits. The detail quality bits give the reason for an invalid or questionable validity.
+const (
+	QualityOverflow        Quality = 1 << 2
+	QualityOutOfRange      Quality = 1 << 3
+	QualityBadReference    Quality = 1 << 4
+	QualityOscillatory     Quality = 1 << 5
+	QualityFailure         Quality = 1 << 6
+	QualityOldData         Quality = 1 << 7
+	QualityInconsistent    Quality = 1 << 8
+	QualityInaccurate      Quality = 1 << 9
+	QualitySubstituted     Quality = 1 << 10
+	QualityTest            Quality = 1 << 11
+	QualityOperatorBlocked Quality = 1 << 12
+	QualityDerived         Quality = 1 << 13
+
+	qualityValidityMask Quality = 0x3
+	qualityDetailMask   Quality = 0xff << 2
+)
+
+// Time quality bits
+const (
+	QualityLeapSecondKnown      Quality = 1 << 31
+	QualityClockFailure         Quality = 1 << 30
+	QualityClockNotSynchronised Quality = 1 << 29
+
+	qualityTimeAccuracyShift         = 24
+	qualityTimeAccuracyMask  Quality = 0x1f << qualityTimeAccuracyShift
+)
+
+// TimeAccuracyUnspecified is the time accuracy of a time source with unknown accuracy
+const TimeAccuracyUnspecified = 31
+
+// Has returns true if all of the given quality bits are set
+func (q Quality) Has(bits Quality) bool {
+	return q&bits == bits
+}
+
+// Set sets or clears the given quality bits
+func (q *Quality) Set(bits Quality, set bool) {
+	if set {
+		*q |= bits
+	} else {
+		*q &^= bits
+	}
+}
+
+// Validity returns the validity
+func (q Quality) Validity() Validity {
+	return Validity(q & qualityValidityMask)
+}
+
+// SetValidity sets the validity
+func (q *Quality) SetValidity(v Validity) {
+	*q = *q&^qualityValidityMask | Quality(v)&qualityValidityMask
+}
+
+// Detail returns the detail quality bits, from QualityOverflow to QualityInaccurate
+func (q Quality) Detail() Quality {
+	return q & qualityDetailMask
+}
+
+// Source returns the source
+func (q Quality) Source() Source {
+	if q.Has(QualitySubstituted) {
+		return SourceSubstituted
+	}
+	return SourceProcess
+}
+
+// SetSource sets the source
+func (q *Quality) SetSource(s Source) {
+	q.Set(QualitySubstituted, s == SourceSubstituted)
+}
+
+// Test returns true if the sample is from a test
+func (q Quality) Test() bool {
+	return q.Has(QualityTest)
+}
+
+// SetTest sets the test bit
+func (q *Quality) SetTest(test bool) {
+	q.Set(QualityTest, test)
+}
+
+// OperatorBlocked returns true if the sample is blocked by an operator
+func (q Quality) OperatorBlocked() bool {
+	return q.Has(QualityOperatorBlocked)
+}
+
+// SetOperatorBlocked sets the operator blocked bit
+func (q *Quality) SetOperatorBlocked(blocked bool) {
+	q.Set(QualityOperatorBlocked, blocked)
+}
+
+// Derived returns true if the sample is calculated from other samples, rather than measured
+func (q Quality) Derived() bool {
+	return q.Has(QualityDerived)
+}
+
+// SetDerived sets the derived bit
+func (q *Quality) SetDerived(derived bool) {
+	q.Set(QualityDerived, derived)
+}
+
+// LeapSecondKnown returns true if the time source is aware of leap seconds
+func (q Quality) LeapSecondKnown() bool {
+	return q.Has(QualityLeapSecondKnown)
+}
+
+// SetLeapSecondKnown sets the leap second known bit
+func (q *Quality) SetLeapSecondKnown(known bool) {
+	q.Set(QualityLeapSecondKnown, known)
+}
+
+// ClockFailure returns true if the time source is unreliable
+func (q Quality) ClockFailure() bool {
+	return q.Has(QualityClockFailure)
+}
+
+// SetClockFailure sets the clock failure bit
+func (q *Quality) SetClockFailure(failure bool) {
+	q.Set(QualityClockFailure, failure)
+}
+
+// ClockNotSynchronised returns true if the time source is not synchronised to an external reference
+func (q Quality) ClockNotSynchronised() bool {
+	return q.Has(QualityClockNotSynchronised)
+}
+
+// SetClockNotSynchronised sets the clock not synchronised bit
+func (q *Quality) SetClockNotSynchronised(notSynchronised bool) {
+	q.Set(QualityClockNotSynchronised, notSynchronised)
+}
+
+// TimeAccuracy returns the time accuracy, as the number of significant bits in the fraction of a second (so that an
+// accuracy of n bits is better than 2^-n seconds). TimeAccuracyUnspecified means that the accuracy is not known.
+func (q Quality) TimeAccuracy() int {
+	return int(q&qualityTimeAccuracyMask) >> qualityTimeAccuracyShift
+}
+
+// SetTimeAccuracy sets the time accuracy, from 0 to 24 bits, or TimeAccuracyUnspecified
+func (q *Quality) SetTimeAccuracy(bits int) {
+	*q = *q&^qualityTimeAccuracyMask | Quality(bits)<<qualityTimeAccuracyShift&qualityTimeAccuracyMask
+}
+
+// Quality returns the quality of the variable with the given index
+func (d *DatasetWithQuality) Quality(index int) Quality {
+	return Quality(d.Q[index])
+}
+
+// SetQuality sets the quality of the variable with the given index
+func (d *DatasetWithQuality) SetQuality(index int, q Quality) {
+	d.Q[index] = uint32(q)
+}
@@ -0,0 +1,259 @@
+package slipstream
+
+import (
+	"bytes"
+	"io"
+	"math/bits"
+	"os"
+	"sort"
+	"time"
+)
+
+// StreamFile provides random access by time to a file of length-prefixed messages, as written by StreamWriter. An
+// index of the start timestamp of each message is built when it is first needed, and is then cached.
+type StreamFile struct {
+	r      io.ReaderAt
+	size   int64
+	closer io.Closer
+
+	index    []IndexEntry
+	decoders []*Decoder // the decoder for each message, which depends on the preceding configuration frame
+	indexed  bool
+	buf      []byte
+}
+
+// NewStreamFile creates a StreamFile for a stream of the given size
+func NewStreamFile(r io.ReaderAt, size int64) *StreamFile {
+	return &StreamFile{r: r, size: size}
+}
+
+// OpenStreamFile opens a file of length-prefixed messages with the given name
+func OpenStreamFile(name string) (*StreamFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	s := NewStreamFile(file, info.Size())
+	s.closer = file
+	return s, nil
+}
+
+// Close closes the file, if it was opened using OpenStreamFile()
+func (s *StreamFile) Close() error {
+	if s.closer != nil {
+		return s.closer.Close()
+	}
+	return nil
+}
+
+// Index returns the location and start timestamp of every message, building the index if required
+func (s *StreamFile) Index() ([]IndexEntry, error) {
+	if err := s.buildIndex(); err != nil {
+		return nil, err
+	}
+	return s.index, nil
+}
+
+// ReadRange returns the samples with timestamps at or after start, and before end. Only the messages which overlap
+// the time range are read and decoded.
+func (s *StreamFile) ReadRange(start, end time.Time) ([]DatasetWithQuality, error) {
+	if err := s.buildIndex(); err != nil {
+		return nil, err
+	}
+
+	return readRange(s.index, s.readMessage, start, end)
+}
+
+// buildIndex reads the header of every message, and records the configuration frames. The index is only kept if the
+// whole stream is valid.
+func (s *StreamFile) buildIndex() error {
+	if s.indexed {
+		return nil
+	}
+
+	index, decoders, err := scanFrames(s.r, 0, s.size, nil, &s.buf)
+	if err != nil {
+		return err
+	}
+	s.index = index
+	s.decoders = decoders
+	s.indexed = true
+	return nil
+}
+
+// messageHeaderSize is the number of bytes at the start of a message which are needed to read its timestamp
+const messageHeaderSize = flagsIndex + 1 + 8
+
+// scanFrames builds an index of the length-prefixed messages from offset to the end of the stream, or to a zero length
+// end marker, and returns the decoder for each message. dec is used for messages before the first configuration frame,
+// and may be nil. Only the header of each message is read. If an error occurs, the messages indexed before the error
+// are also returned.
+func scanFrames(r io.ReaderAt, offset, size int64, dec *Decoder, buf *[]byte) ([]IndexEntry, []*Decoder, error) {
+	var index []IndexEntry
+	var decoders []*Decoder
+	var config []byte
+	for offset < size {
+		length, lenB, err := readFrameLength(r, offset)
+		if err != nil {
+			return index, decoders, err
+		}
+
+		// a zero length marks the end of the messages in a capture file
+		if length == 0 {
+			break
+		}
+
+		frameOffset := offset + int64(lenB)
+		if length > uint64(size-frameOffset) {
+			return index, decoders, ErrTruncated
+		}
+		headerLength := messageHeaderSize
+		if length < uint64(headerLength) {
+			headerLength = int(length)
+		}
+		header, err := readAt(r, frameOffset, headerLength, buf)
+		if err != nil {
+			return index, decoders, err
+		}
+
+		// the whole frame is only read if it may be a configuration frame
+		isConfig := false
+		if hasConfigMagic(header) && length <= maxConfigFrameSize {
+			frame, err := readAt(r, frameOffset, int(length), buf)
+			if err != nil {
+				return index, decoders, err
+			}
+			if isConfig = IsConfig(frame); isConfig && !bytes.Equal(frame, config) {
+				// only create a new decoder if the configuration has changed
+				if dec, err = NewDecoderFromConfig(frame); err != nil {
+					return index, decoders, err
+				}
+				config = append(config[:0], frame...)
+			}
+		}
+
+		if !isConfig {
+			if dec == nil {
+				return index, decoders, ErrMissingConfig
+			}
+			if length > uint64(dec.maxMessageSize()) {
+				return index, decoders, ErrFrameTooLarge
+			}
+			if len(header) < messageHeaderSize {
+				return index, decoders, ErrTruncated
+			}
+			index = append(index, IndexEntry{Offset: offset, T: messageTimestamp(header)})
+			decoders = append(decoders, dec)
+		}
+		offset = frameOffset + int64(length)
+	}
+	return index, decoders, nil
+}
+
+func (s *StreamFile) readMessage(i int) ([]DatasetWithQuality, *Decoder, error) {
+	dec := s.decoders[i]
+	frame, _, err := readFrameAt(s.r, s.index[i].Offset, uint64(dec.maxMessageSize()), &s.buf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	samples, err := dec.DecodeToBuffer(frame, len(frame))
+	if err != nil {
+		return nil, nil, err
+	}
+	return dec.Out[:samples], dec, nil
+}
+
+// ReadRange returns the samples with timestamps at or after start, and before end. Only the messages which overlap
+// the time range are read and decoded.
+func (f *FileReader) ReadRange(start, end time.Time) ([]DatasetWithQuality, error) {
+	return readRange(f.index, func(i int) ([]DatasetWithQuality, *Decoder, error) {
+		out, err := f.ReadMessage(i)
+		return out, f.dec, err
+	}, start, end)
+}
+
+// readRange reads the messages which overlap a time range, and returns a copy of the samples within the range
+func readRange(index []IndexEntry, readMessage func(i int) ([]DatasetWithQuality, *Decoder, error), start, end time.Time) ([]DatasetWithQuality, error) {
+	startT := timeToTimestamp(start)
+	endT := timeToTimestamp(end)
+	if endT <= startT {
+		return nil, nil
+	}
+
+	// find the last message which starts at or before the start of the range
+	first := sort.Search(len(index), func(i int) bool { return index[i].T > startT }) - 1
+	if first < 0 {
+		first = 0
+	}
+
+	var out []DatasetWithQuality
+	for i := first; i < len(index) && index[i].T < endT; i++ {
+		samples, dec, err := readMessage(i)
+		if err != nil {
+			return nil, err
+		}
+
+		// trim the samples to the range, using the sample period
+		from := sampleIndex(index[i].T, startT, dec.SamplingRate, len(samples))
+		to := sampleIndex(index[i].T, endT, dec.SamplingRate, len(samples))
+		for j := from; j < to; j++ {
+			out = append(out, copyDataset(&samples[j]))
+		}
+	}
+	return out, nil
+}
+
+// sampleIndex returns the index of the first sample with a timestamp at or after t, in a message with the given start
+// timestamp and number of samples. It returns the number of samples if all samples are before t.
+func sampleIndex(start uint64, t uint64, samplingRate int, samples int) int {
+	if t <= start || samplingRate <= 0 {
+		return 0
+	}
+
+	// estimate the index from the sample period, and then correct for the rounding of sample timestamps
+	hi, lo := bits.Mul64(t-start, uint64(samplingRate))
+	if hi >= uint64(time.Second) {
+		return samples
+	}
+	quo, _ := bits.Div64(hi, lo, uint64(time.Second))
+	if quo >= uint64(samples) {
+		return samples
+	}
+
+	i := int(quo)
+	for i > 0 && SampleTimestamp(start, i-1, samplingRate) >= t {
+		i--
+	}
+	for i < samples && SampleTimestamp(start, i, samplingRate) < t {
+		i++
+	}
+	return i
+}
+
+// timeToTimestamp converts a time to a timestamp in nanoseconds, limited to times after 1970
+func timeToTimestamp(t time.Time) uint64 {
+	if t.UnixNano() < 0 {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// copyDataset returns a copy of a sample, which does not share any storage
+func copyDataset(d *DatasetWithQuality) DatasetWithQuality {
+	return DatasetWithQuality{
+		T:        d.T,
+		Int32s:   append([]int32(nil), d.Int32s...),
+		Int16s:   append([]int16(nil), d.Int16s...),
+		Int64s:   append([]int64(nil), d.Int64s...),
+		Float32s: append([]float32(nil), d.Float32s...),
+		Float64s: append([]float64(nil), d.Float64s...),
+		Q:        append([]uint32(nil), d.Q...),
+	}
+}
@@ -0,0 +1,364 @@
+// Package server fans out Slipstream streams to many TCP and WebSocket subscribers.
+//
+// Messages are published for one or more streams, identified by UUID. Each subscriber receives the configuration
+// frame of its stream when it connects (or as soon as it is published), followed by the messages. Each subscriber has
+// a bounded queue, so that slow subscribers cannot block the encoder, and a drop policy which determines what happens
+// when the queue is full. The configuration frame is always sent before the next message if the configuration has
+// changed, even if messages have been dropped.
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/synaptecltd/slipstream"
+)
+
+// DefaultQueueSize is the default number of messages queued for each subscriber
+const DefaultQueueSize = 64
+
+// DefaultWriteTimeout is the default time allowed to write each frame to a TCP or WebSocket subscriber
+const DefaultWriteTimeout = 10 * time.Second
+
+// DropPolicy determines how messages are handled when a subscriber's queue is full
+type DropPolicy int
+
+// Drop policies
+const (
+	DropNewest DropPolicy = iota // discard the new message
+	DropOldest                   // discard the oldest queued message, and queue the new message
+	Disconnect                   // disconnect the subscriber
+)
+
+// ErrInvalidMessage is returned when publishing a frame which is neither a configuration frame nor a message
+var ErrInvalidMessage = errors.New("invalid message")
+
+// ErrServerClosed is returned when publishing to or serving from a closed server
+var ErrServerClosed = errors.New("server closed")
+
+// ErrSubscriptionClosed is returned when the subscription or server has been closed, or the subscriber was
+// disconnected due to a full queue
+var ErrSubscriptionClosed = errors.New("subscription closed")
+
+// Stats are the statistics of a stream
+type Stats struct {
+	Subscribers  int    // current number of subscribers
+	Messages     uint64 // messages published
+	Dropped      uint64 // messages dropped due to full subscriber queues
+	Disconnected uint64 // subscribers disconnected due to the Disconnect policy
+}
+
+// Server distributes the messages of each stream to its subscribers
+type Server struct {
+	QueueSize int        // number of messages queued for each subscriber, or DefaultQueueSize if zero
+	Policy    DropPolicy // policy for subscribers with full queues
+
+	// WriteTimeout is the time allowed to write each frame to a TCP or WebSocket subscriber, or DefaultWriteTimeout if
+	// zero. A subscriber which does not read the frame within this time is disconnected.
+	WriteTimeout time.Duration
+
+	mutex   sync.Mutex
+	streams map[uuid.UUID]*stream
+	closed  bool
+}
+
+type stream struct {
+	config      []byte
+	subscribers map[*subscriber]struct{}
+	stats       Stats
+}
+
+// queued is a message, with the configuration frame which applies to it
+type queued struct {
+	config  []byte
+	message []byte
+}
+
+type subscriber struct {
+	queue   chan queued
+	done    chan struct{}
+	once    sync.Once
+	sent    []byte // the most recent configuration frame sent
+	pending []byte // a message to be sent after a new configuration frame
+}
+
+func (s *subscriber) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// next waits for the next frame to send, returning false if the subscriber is closed
+func (s *subscriber) next() ([]byte, bool) {
+	select {
+	case <-s.done:
+		return nil, false
+	default:
+	}
+
+	if s.pending != nil {
+		frame := s.pending
+		s.pending = nil
+		return frame, true
+	}
+
+	select {
+	case <-s.done:
+		return nil, false
+	case item := <-s.queue:
+		if !sameFrame(item.config, s.sent) {
+			s.sent = item.config
+			s.pending = item.message
+			return item.config, true
+		}
+		return item.message, true
+	}
+}
+
+// NewServer creates a server
+func NewServer() *Server {
+	return &Server{streams: make(map[uuid.UUID]*stream)}
+}
+
+func (s *Server) stream(ID uuid.UUID) *stream {
+	st, ok := s.streams[ID]
+	if !ok {
+		st = &stream{subscribers: make(map[*subscriber]struct{})}
+		s.streams[ID] = st
+	}
+	return st
+}
+
+// Publish publishes a configuration frame or message. Messages are only sent to subscribers after the configuration
+// of the stream has been published. The frame is copied, so the buffer can be re-used.
+func (s *Server) Publish(frame []byte) error {
+	if slipstream.IsConfig(frame) {
+		c, err := slipstream.DecodeConfig(frame)
+		if err != nil {
+			return err
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.closed {
+			return ErrServerClosed
+		}
+		s.stream(c.ID).config = append([]byte(nil), frame...)
+		return nil
+	}
+
+	if len(frame) < len(uuid.UUID{}) {
+		return ErrInvalidMessage
+	}
+	var ID uuid.UUID
+	copy(ID[:], frame)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	st, ok := s.streams[ID]
+	if !ok || st.config == nil {
+		return nil
+	}
+
+	st.stats.Messages++
+	item := queued{config: st.config, message: append([]byte(nil), frame...)}
+	for sub := range st.subscribers {
+		select {
+		case sub.queue <- item:
+			continue
+		default:
+		}
+
+		st.stats.Dropped++
+		switch s.Policy {
+		case DropOldest:
+			// the subscriber may take a message concurrently, so that there is no message to discard
+			select {
+			case <-sub.queue:
+			default:
+			}
+			select {
+			case sub.queue <- item:
+			default:
+			}
+		case Disconnect:
+			st.stats.Disconnected++
+			delete(st.subscribers, sub)
+			sub.close()
+		}
+	}
+	return nil
+}
+
+// Stats returns the statistics of a stream
+func (s *Server) Stats(ID uuid.UUID) Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	st, ok := s.streams[ID]
+	if !ok {
+		return Stats{}
+	}
+	stats := st.stats
+	stats.Subscribers = len(st.subscribers)
+	return stats
+}
+
+// Close disconnects all subscribers. Listeners passed to ServeTCP() must be closed separately.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	for _, st := range s.streams {
+		for sub := range st.subscribers {
+			sub.close()
+		}
+		st.subscribers = nil
+	}
+	return nil
+}
+
+func (s *Server) subscribe(ID uuid.UUID) (*subscriber, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil, ErrServerClosed
+	}
+
+	size := s.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	sub := &subscriber{queue: make(chan queued, size), done: make(chan struct{})}
+	st := s.stream(ID)
+	if st.config != nil {
+		sub.sent = st.config
+		sub.pending = st.config
+	}
+	st.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+func (s *Server) unsubscribe(ID uuid.UUID, sub *subscriber) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if st, ok := s.streams[ID]; ok {
+		delete(st.subscribers, sub)
+
+		// subscribers can name any stream, so a stream which has not been published is removed with its last subscriber
+		if st.config == nil && len(st.subscribers) == 0 {
+			delete(s.streams, ID)
+		}
+	}
+	sub.close()
+}
+
+func (s *Server) writeTimeout() time.Duration {
+	if s.WriteTimeout <= 0 {
+		return DefaultWriteTimeout
+	}
+	return s.WriteTimeout
+}
+
+// serve sends the configuration and messages of a stream to a subscriber, until the subscriber is closed or a write
+// fails
+func (s *Server) serve(ID uuid.UUID, sub *subscriber, write func(frame []byte) error) error {
+	defer s.unsubscribe(ID, sub)
+
+	for {
+		frame, ok := sub.next()
+		if !ok {
+			return nil
+		}
+		if err := write(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// Subscription is an in-process subscriber to a stream
+type Subscription struct {
+	server *Server
+	ID     uuid.UUID
+	sub    *subscriber
+}
+
+// Subscribe subscribes to a stream within the same process
+func (s *Server) Subscribe(ID uuid.UUID) (*Subscription, error) {
+	sub, err := s.subscribe(ID)
+	if err != nil {
+		return nil, err
+	}
+	return &Subscription{server: s, ID: ID, sub: sub}, nil
+}
+
+// Next waits for the next configuration frame or message of the stream
+func (s *Subscription) Next() ([]byte, error) {
+	frame, ok := s.sub.next()
+	if !ok {
+		return nil, ErrSubscriptionClosed
+	}
+	return frame, nil
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.server.unsubscribe(s.ID, s.sub)
+}
+
+// sameFrame returns true if the frames share the same storage, which is the case for configuration frames which have
+// not changed
+func sameFrame(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// Publisher encodes samples and publishes the messages to a server. It implements the same Write() method as
+// slipstream.StreamWriter.
+type Publisher struct {
+	server      *Server
+	enc         *slipstream.Encoder
+	wroteConfig bool
+}
+
+// NewPublisher creates a Publisher which uses the given encoder. The configuration of the encoder is published
+// before the first message.
+func NewPublisher(s *Server, enc *slipstream.Encoder) *Publisher {
+	return &Publisher{server: s, enc: enc}
+}
+
+// Write encodes the next sample, and publishes a message when it is complete
+func (p *Publisher) Write(data *slipstream.DatasetWithQuality) error {
+	if err := p.publishConfig(); err != nil {
+		return err
+	}
+	buf, length, err := p.enc.Encode(data)
+	if err != nil || length == 0 {
+		return err
+	}
+	return p.server.Publish(buf[:length])
+}
+
+// Flush publishes any partially encoded message
+func (p *Publisher) Flush() error {
+	if err := p.publishConfig(); err != nil {
+		return err
+	}
+	buf, length, err := p.enc.EndEncode()
+	if err != nil || length == 0 {
+		return err
+	}
+	return p.server.Publish(buf[:length])
+}
+
+func (p *Publisher) publishConfig() error {
+	if p.wroteConfig {
+		return nil
+	}
+	p.wroteConfig = true
+	return p.server.Publish(p.enc.EncodeConfig())
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// subscribeTimeout is the time allowed for a TCP client to send the stream ID
+const subscribeTimeout = 10 * time.Second
+
+// ServeTCP accepts TCP subscribers until the listener is closed. Each client sends the 16-byte UUID of a stream, and
+// then receives the stream as length-prefixed frames, which can be read using slipstream.NewStreamReader().
+func (s *Server) ServeTCP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveTCP(conn)
+	}
+}
+
+func (s *Server) serveTCP(conn net.Conn) {
+	defer conn.Close()
+
+	var ID uuid.UUID
+	conn.SetReadDeadline(time.Now().Add(subscribeTimeout))
+	if _, err := io.ReadFull(conn, ID[:]); err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	sub, err := s.subscribe(ID)
+	if err != nil {
+		return
+	}
+
+	// the client does not send anything else, so a read only completes when the connection is closed
+	go func() {
+		io.Copy(io.Discard, conn)
+		sub.close()
+	}()
+
+	var lenBuf [binary.MaxVarintLen64]byte
+	timeout := s.writeTimeout()
+	s.serve(ID, sub, func(frame []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		n := binary.PutUvarint(lenBuf[:], uint64(len(frame)))
+		buffers := net.Buffers{lenBuf[:n], frame}
+		_, err := buffers.WriteTo(conn)
+		return err
+	})
+}
+
+// DialTCP connects to a server and subscribes to a stream. The returned connection can be read using
+// slipstream.NewStreamReader().
+func DialTCP(address string, ID uuid.UUID) (net.Conn, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(ID[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// webSocketGUID is used to calculate the Sec-WebSocket-Accept header, as defined by RFC 6455
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	opBinary = 0x2
+	opClose  = 0x8
+	opPing   = 0x9
+	opPong   = 0xa
+)
+
+// maxClientFrameSize is the largest frame accepted from WebSocket clients, which are not expected to send data
+const maxClientFrameSize = 4096
+
+// maxControlFrameSize is the largest payload of a control frame, as defined by RFC 6455
+const maxControlFrameSize = 125
+
+// ServeHTTP accepts WebSocket subscribers. The stream ID is given by the "id" query parameter, or the last element of
+// the URL path. Each configuration frame and message is sent as a binary WebSocket message.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	idString := r.URL.Query().Get("id")
+	if idString == "" {
+		idString = path.Base(r.URL.Path)
+	}
+	ID, err := uuid.Parse(idString)
+	if err != nil {
+		http.Error(w, "invalid stream ID", http.StatusNotFound)
+		return
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub, err := s.subscribe(ID)
+	if err != nil {
+		return
+	}
+
+	ws := &webSocket{conn: conn, timeout: s.writeTimeout()}
+	conn.SetWriteDeadline(time.Now().Add(ws.timeout))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		s.unsubscribe(ID, sub)
+		return
+	}
+
+	go func() {
+		ws.readFrames(rw.Reader)
+		sub.close()
+	}()
+
+	if err := s.serve(ID, sub, func(frame []byte) error { return ws.writeFrame(opBinary, frame) }); err == nil {
+		ws.writeFrame(opClose, nil)
+	}
+}
+
+func headerContains(h http.Header, name string, value string) bool {
+	for _, v := range h.Values(name) {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func webSocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// webSocket is the server side of a WebSocket connection
+type webSocket struct {
+	conn    net.Conn
+	timeout time.Duration // the time allowed to write each frame
+	mutex   sync.Mutex
+	header  [10]byte // the header of the frame being written
+}
+
+// writeFrame writes an unmasked frame, which is not fragmented
+func (ws *webSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	header := ws.header[:2]
+	header[0] = 0x80 | opcode
+	switch {
+	case len(payload) < 126:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		header[1] = 126
+		header = ws.header[:4]
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header[1] = 127
+		header = ws.header[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+
+	ws.conn.SetWriteDeadline(time.Now().Add(ws.timeout))
+	buffers := net.Buffers{header, payload}
+	_, err := buffers.WriteTo(ws.conn)
+	return err
+}
+
+// readFrames reads frames from the client, responding to pings, until the connection is closed
+func (ws *webSocket) readFrames(r *bufio.Reader) {
+	var header [2]byte
+	var mask [4]byte
+	payload := make([]byte, maxClientFrameSize)
+
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		opcode := header[0] & 0x0f
+		length := uint64(header[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+
+		// frames from clients must be masked, and control frames must not be fragmented or exceed 125 bytes
+		if header[1]&0x80 == 0 || length > maxClientFrameSize {
+			return
+		}
+		if opcode&0x8 != 0 && (header[0]&0x80 == 0 || length > maxControlFrameSize) {
+			return
+		}
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+		data := payload[:length]
+		if _, err := io.ReadFull(r, data); err != nil {
+			return
+		}
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case opClose:
+			return
+		case opPing:
+			if ws.writeFrame(opPong, data) != nil {
+				return
+			}
+		}
+	}
+}
@@ -1,5 +1,14 @@
 package slipstream
 
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+	"time"
+)
+
 // Simple8bThresholdSamples defines the number of samples per message required before using simple-8b encoding
 const Simple8bThresholdSamples = 16
 
@@ -12,20 +21,97 @@ const HighDeltaEncodingLayers = 3
 // MaxHeaderSize is the size of the message header in bytes
 const MaxHeaderSize = 36
 
-// UseGzipThresholdSamples is the minimum number of samples per message to use gzip on the payload
+// ProtocolVersion is the version of the Slipstream wire format produced by this implementation
+const ProtocolVersion = 1
+
+// Message header flags, describing the encoding options used for each message
+const (
+	FlagSimple8b          = 1 << iota // values are packed using simple-8b, rather than varint
+	FlagXOR                           // XOR delta encoding is used, rather than arithmetic delta
+	FlagSpatialRefs                   // some variables are encoded relative to other variables
+	FlagGzip                          // the message payload is compressed with gzip
+	FlagChecksum                      // the message ends with a CRC32C checksum
+	FlagColumnDescriptors             // each integer variable has a descriptor of its encoding
+	FlagCompressed                    // the payload starts with the ID of the compressor used for the remainder
+)
+
+// flagsIndex is the position of the flags in the message header, after the UUID and protocol version
+const flagsIndex = 17
+
+// supportedFlags is the set of flags which this implementation can decode
+const supportedFlags = FlagSimple8b | FlagXOR | FlagSpatialRefs | FlagGzip | FlagChecksum | FlagColumnDescriptors | FlagCompressed
+
+// UnsupportedVersionError is returned when decoding a message which uses an unknown protocol version
+type UnsupportedVersionError struct {
+	Version int
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("unsupported protocol version: %d", e.Version)
+}
+
+// ChecksumSize is the size of the optional CRC32C checksum at the end of each message
+const ChecksumSize = 4
+
+// crc32cTable is used to calculate message checksums, using the Castagnoli polynomial
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksum is returned when the checksum of a message does not match its contents
+var ErrChecksum = errors.New("message checksum mismatch")
+
+// ErrIDMismatch is returned when decoding a message which was encoded with a different UUID
+var ErrIDMismatch = errors.New("IDs did not match")
+
+// ErrTruncated is returned when a message ends before all of its contents have been decoded
+var ErrTruncated = errors.New("message is truncated")
+
+// ErrSampleCountOverflow is returned when the number of samples in a message, or in a quality run, is outside the
+// range supported by the decoder
+var ErrSampleCountOverflow = errors.New("sample count out of range")
+
+// ErrTrailingBytes is returned when a message contains unexpected data after all of its contents have been decoded
+var ErrTrailingBytes = errors.New("unexpected trailing bytes in message")
+
+// ErrMalformedVarint is returned when a variable length integer in a message overflows 32 bits
+var ErrMalformedVarint = errors.New("malformed varint")
+
+// ErrMalformedFloat is returned when the encoding of a floating-point variable is invalid
+var ErrMalformedFloat = errors.New("malformed floating-point encoding")
+
+// ErrMalformedInt64 is returned when the encoding of an int64 variable is invalid
+var ErrMalformedInt64 = errors.New("malformed int64 encoding")
+
+// ErrInvalidDescriptor is returned when the descriptor of a variable encoding is invalid
+var ErrInvalidDescriptor = errors.New("invalid variable encoding descriptor")
+
+// ErrUnsupportedFlags is returned when a message header contains encoding options which the decoder does not support
+var ErrUnsupportedFlags = errors.New("unsupported encoding options in message header")
+
+// ErrMissingSpatialRefs is returned when a message uses spatial references, but none are defined for the decoder
+var ErrMissingSpatialRefs = errors.New("message uses spatial references but decoder has none")
+
+// UseGzipThresholdSamples is the number of samples per message above which the payload is compressed
 const UseGzipThresholdSamples = 4096
 
 // Dataset defines lists of variables to be encoded
 type Dataset struct {
-	Int32s []int32
-	// can extend with other data types
+	Int32s   []int32
+	Int16s   []int16
+	Int64s   []int64
+	Float32s []float32
+	Float64s []float64
 }
 
-// DatasetWithQuality defines lists of decoded variables with a timestamp and quality
+// DatasetWithQuality defines lists of decoded variables with a timestamp and quality. There is a quality value for
+// each variable, in the order Int32s, Int16s, Int64s, Float32s, then Float64s.
 type DatasetWithQuality struct {
-	T      uint64
-	Int32s []int32
-	Q      []uint32
+	T        uint64
+	Int32s   []int32
+	Int16s   []int16
+	Int64s   []int64
+	Float32s []float32
+	Float64s []float64
+	Q        []uint32
 }
 
 type qualityHistory struct {
@@ -65,6 +151,38 @@ func getDeltaEncoding(samplingRate int) int {
 	return DefaultDeltaEncodingLayers
 }
 
+// SampleTimestamp returns the timestamp, in nanoseconds, of the sample at the given index within a message which
+// starts at the start timestamp. The offset is calculated from the start of the message using exact integer
+// arithmetic and rounded to the nearest nanosecond, so that there is no cumulative drift for sampling rates (such as
+// 14.4 kHz) which do not have an integer sampling period. If the sampling rate is unknown, the start timestamp is
+// returned.
+func SampleTimestamp(start uint64, index int, samplingRate int) uint64 {
+	if samplingRate <= 0 || index <= 0 {
+		return start
+	}
+
+	rate := uint64(samplingRate)
+	hi, lo := bits.Mul64(uint64(index), uint64(time.Second))
+	lo, carry := bits.Add64(lo, rate/2, 0)
+	offset, _ := bits.Div64(hi+carry, lo, rate)
+
+	return start + offset
+}
+
+// maxPayloadSize returns the largest possible payload (excluding the header) for the given message dimensions
+func maxPayloadSize(samples int, int32Count int, int16Count int, int64Count int, float32Count int, float64Count int) int {
+	variables := int32Count + int16Count + int64Count + float32Count + float64Count
+
+	// integer variables may have a descriptor byte, simple-8b uses at most 8 bytes per value, int64 values use at
+	// most a maximum length varint (plus an encoding byte per variable), and each quality run uses at most two
+	// maximum length varints
+	return int32Count + int16Count + int64Count +
+		samples*(int32Count+int16Count)*8 +
+		samples*int64Count*binary.MaxVarintLen64 + int64Count +
+		maxXORBytes(samples*float32Count, 32) + maxXORBytes(samples*float64Count, 64) +
+		samples*variables*2*maxVarintLen32 + ChecksumSize
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -72,13 +190,19 @@ func min(a, b int) int {
 	return b
 }
 
+// maxVarintLen32 is the maximum length of a varint-encoded 32-bit integer
+const maxVarintLen32 = 5
+
 // copied from encoding/binary/varint.go to provide 32-bit version to avoid casting
 func uvarint32(buf []byte) (uint32, int) {
 	var x uint32
 	var s uint
 	for i, b := range buf {
+		if i == maxVarintLen32 {
+			return 0, -(i + 1) // overflow
+		}
 		if b < 0x80 {
-			if i > 9 || i == 9 && b > 1 {
+			if i == maxVarintLen32-1 && b > 0x0f {
 				return 0, -(i + 1) // overflow
 			}
 			return x | uint32(b)<<s, i + 1
@@ -0,0 +1,192 @@
+package slipstream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Streams consist of frames, each of which is a uvarint length followed by a configuration frame or an encoded
+// message. The first frame in a stream is always a configuration frame, so that the stream can be decoded without any
+// other information.
+
+// maxConfigFrameSize limits the size of a configuration frame read from a stream
+const maxConfigFrameSize = 1 << 20
+
+// ErrFrameTooLarge is returned when a stream contains a frame which is larger than the maximum possible message size
+var ErrFrameTooLarge = errors.New("stream frame too large")
+
+// ErrMissingConfig is returned when a stream does not start with a configuration frame
+var ErrMissingConfig = errors.New("stream does not start with a configuration frame")
+
+// StreamWriter encodes samples and writes length-prefixed messages to an io.Writer
+type StreamWriter struct {
+	w           io.Writer
+	enc         *Encoder
+	wroteConfig bool
+	lenBuf      [binary.MaxVarintLen64]byte
+}
+
+// NewStreamWriter creates a StreamWriter which uses the given encoder. The configuration of the encoder is written
+// before the first message.
+func NewStreamWriter(w io.Writer, enc *Encoder) *StreamWriter {
+	return &StreamWriter{w: w, enc: enc}
+}
+
+// Write encodes the next sample, and writes a message to the underlying writer when it is complete
+func (s *StreamWriter) Write(data *DatasetWithQuality) error {
+	if err := s.writeConfig(); err != nil {
+		return err
+	}
+
+	buf, length, err := s.enc.Encode(data)
+	if err != nil {
+		return err
+	}
+	return s.writeFrame(buf[:length])
+}
+
+// Flush writes any partially encoded message to the underlying writer
+func (s *StreamWriter) Flush() error {
+	if err := s.writeConfig(); err != nil {
+		return err
+	}
+
+	buf, length, err := s.enc.EndEncode()
+	if err != nil {
+		return err
+	}
+	return s.writeFrame(buf[:length])
+}
+
+// Close flushes any partially encoded message. It does not close the underlying writer.
+func (s *StreamWriter) Close() error {
+	return s.Flush()
+}
+
+func (s *StreamWriter) writeConfig() error {
+	if s.wroteConfig {
+		return nil
+	}
+	s.wroteConfig = true
+	return s.writeFrame(s.enc.EncodeConfig())
+}
+
+func (s *StreamWriter) writeFrame(frame []byte) error {
+	if len(frame) == 0 {
+		return nil
+	}
+
+	n := binary.PutUvarint(s.lenBuf[:], uint64(len(frame)))
+	if _, err := s.w.Write(s.lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err := s.w.Write(frame)
+	return err
+}
+
+// StreamReader reads length-prefixed messages from an io.Reader, and provides the decoded samples one at a time:
+//
+//	reader := slipstream.NewStreamReader(r)
+//	for reader.Next() {
+//		sample := reader.Sample()
+//		...
+//	}
+//	if err := reader.Err(); err != nil {
+//		...
+//	}
+type StreamReader struct {
+	r       *bufio.Reader
+	dec     *Decoder
+	buf     []byte
+	samples int
+	index   int
+	err     error
+}
+
+// NewStreamReader creates a StreamReader. The decoder is created using the configuration frame at the start of the
+// stream.
+func NewStreamReader(r io.Reader) *StreamReader {
+	return &StreamReader{r: bufio.NewReader(r)}
+}
+
+// Next advances to the next sample, reading and decoding the next message if required. It returns false at the end of
+// the stream, or if an error occurs.
+func (s *StreamReader) Next() bool {
+	if s.err != nil {
+		return false
+	}
+
+	s.index++
+	for s.index >= s.samples {
+		frame, err := s.readFrame()
+		if err != nil {
+			if err != io.EOF {
+				s.err = err
+			}
+			return false
+		}
+
+		// the configuration may be repeated, or changed, during the stream
+		if IsConfig(frame) {
+			if s.dec, s.err = NewDecoderFromConfig(frame); s.err != nil {
+				return false
+			}
+			continue
+		}
+		if s.dec == nil {
+			s.err = ErrMissingConfig
+			return false
+		}
+
+		if s.samples, s.err = s.dec.DecodeToBuffer(frame, len(frame)); s.err != nil {
+			return false
+		}
+		s.index = 0
+	}
+	return true
+}
+
+// Sample returns the current sample. It is only valid until the next call to Next().
+func (s *StreamReader) Sample() *DatasetWithQuality {
+	return &s.dec.Out[s.index]
+}
+
+// Decoder returns the decoder for the stream, which is nil until the configuration frame has been read
+func (s *StreamReader) Decoder() *Decoder {
+	return s.dec
+}
+
+// Err returns the first error which occurred while reading the stream, excluding io.EOF at the end of the stream
+func (s *StreamReader) Err() error {
+	return s.err
+}
+
+// readFrame reads the next frame, returning io.EOF only if the stream ended at a frame boundary
+func (s *StreamReader) readFrame() ([]byte, error) {
+	length, err := binary.ReadUvarint(s.r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, payloadError(err)
+	}
+
+	maxSize := uint64(maxConfigFrameSize)
+	if s.dec != nil {
+		maxSize = uint64(s.dec.maxMessageSize())
+	}
+	if length > maxSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	if uint64(cap(s.buf)) < length {
+		s.buf = make([]byte, length)
+	}
+	s.buf = s.buf[:length]
+	if _, err := io.ReadFull(s.r, s.buf); err != nil {
+		return nil, payloadError(err)
+	}
+	return s.buf, nil
+}
@@ -0,0 +1,349 @@
+// Package sv parses and emits IEC 61850-9-2 Sampled Values (SV) frames, including the IEC 61850-9-2 LE and
+// IEC 61869-9 datasets, and transcodes between SV and Slipstream.
+//
+// Each ASDU contains one sample of a dataset of INT32 values, each followed by a 32-bit quality. Frames are Ethernet
+// frames with the SV EtherType, optionally with a VLAN tag, and the APDU is encoded using ASN.1 BER.
+package sv
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// EtherType is the Ethernet type of SV frames
+const EtherType = 0x88ba
+
+// etherTypeVLAN is the Ethernet type of an IEEE 802.1Q VLAN tag
+const etherTypeVLAN = 0x8100
+
+// BER tags of the APDU and ASDU fields
+const (
+	tagSavPdu   = 0x60
+	tagNoASDU   = 0x80
+	tagSeqASDU  = 0xa2
+	tagASDU     = 0x30
+	tagSvID     = 0x80
+	tagDatSet   = 0x81
+	tagSmpCnt   = 0x82
+	tagConfRev  = 0x83
+	tagRefrTm   = 0x84
+	tagSmpSynch = 0x85
+	tagSmpRate  = 0x86
+	tagSeqData  = 0x87
+	tagSmpMod   = 0x88
+	tagGmID     = 0x89
+)
+
+// ErrMalformed is returned when a frame or APDU cannot be parsed
+var ErrMalformed = errors.New("malformed SV frame")
+
+// ErrNotSV is returned when an Ethernet frame does not have the SV EtherType
+var ErrNotSV = errors.New("not an SV frame")
+
+// ASDU is a single sample of an SV stream
+type ASDU struct {
+	SvID       string
+	DatSet     string // optional
+	SmpCnt     uint16
+	ConfRev    uint32
+	RefrTm     uint64 // optional, IEC 61850 UtcTime encoding (seconds, fraction of second and time quality)
+	HasRefrTm  bool
+	SmpSynch   uint8
+	SmpRate    uint16 // optional, zero if not present
+	SmpMod     uint16 // optional
+	HasSmpMod  bool
+	GmIdentity []byte // optional, IEC 61869-9 grandmaster identity
+	Values     []int32
+	Quality    []uint32
+}
+
+// Frame is an Ethernet frame containing an SV APDU
+type Frame struct {
+	Destination [6]byte
+	Source      [6]byte
+	VLAN        uint16 // priority and VLAN ID, if HasVLAN is set
+	HasVLAN     bool
+	APPID       uint16
+	Simulate    bool // the simulation bit of the first reserved field
+	ASDUs       []ASDU
+}
+
+// Timestamp returns the reference time, in nanoseconds since the Unix epoch
+func (a *ASDU) Timestamp() uint64 {
+	seconds := a.RefrTm >> 32
+	fraction := (a.RefrTm >> 8) & 0xffffff
+	return seconds*1e9 + (fraction*1e9+1<<23)>>24
+}
+
+// SetTimestamp sets the reference time from a timestamp in nanoseconds since the Unix epoch
+func (a *ASDU) SetTimestamp(t uint64) {
+	seconds := t / 1e9
+	fraction := ((t%1e9)<<24 + 5e8) / 1e9
+	if fraction > 0xffffff {
+		fraction = 0xffffff
+	}
+	a.RefrTm = seconds<<32 | fraction<<8
+	a.HasRefrTm = true
+}
+
+// ParseFrame parses an Ethernet frame containing an SV APDU
+func ParseFrame(buf []byte) (*Frame, error) {
+	f := &Frame{}
+	if len(buf) < 14 {
+		return nil, ErrMalformed
+	}
+	copy(f.Destination[:], buf[0:6])
+	copy(f.Source[:], buf[6:12])
+	pos := 12
+
+	etherType := binary.BigEndian.Uint16(buf[pos:])
+	if etherType == etherTypeVLAN {
+		if len(buf) < 18 {
+			return nil, ErrMalformed
+		}
+		f.VLAN = binary.BigEndian.Uint16(buf[pos+2:])
+		f.HasVLAN = true
+		pos += 4
+		etherType = binary.BigEndian.Uint16(buf[pos:])
+	}
+	if etherType != EtherType {
+		return nil, ErrNotSV
+	}
+	pos += 2
+
+	// APPID, length, and two reserved fields
+	if len(buf) < pos+8 {
+		return nil, ErrMalformed
+	}
+	f.APPID = binary.BigEndian.Uint16(buf[pos:])
+	length := int(binary.BigEndian.Uint16(buf[pos+2:]))
+	f.Simulate = buf[pos+4]&0x80 != 0
+	if length < 8 || pos+length > len(buf) {
+		return nil, ErrMalformed
+	}
+
+	var err error
+	f.ASDUs, err = ParseAPDU(buf[pos+8 : pos+length])
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ParseAPDU parses an SV APDU (savPdu), returning the ASDUs
+func ParseAPDU(buf []byte) ([]ASDU, error) {
+	tag, pdu, _, err := readTLV(buf)
+	if err != nil || tag != tagSavPdu {
+		return nil, ErrMalformed
+	}
+
+	var count int
+	var asdus []ASDU
+	for len(pdu) > 0 {
+		tag, value, n, err := readTLV(pdu)
+		if err != nil {
+			return nil, err
+		}
+		pdu = pdu[n:]
+
+		switch tag {
+		case tagNoASDU:
+			count = int(readUint(value))
+		case tagSeqASDU:
+			for len(value) > 0 {
+				tag, asdu, n, err := readTLV(value)
+				if err != nil || tag != tagASDU {
+					return nil, ErrMalformed
+				}
+				value = value[n:]
+
+				a, err := parseASDU(asdu)
+				if err != nil {
+					return nil, err
+				}
+				asdus = append(asdus, a)
+			}
+		}
+	}
+
+	if count != len(asdus) || count == 0 {
+		return nil, ErrMalformed
+	}
+	return asdus, nil
+}
+
+func parseASDU(buf []byte) (ASDU, error) {
+	a := ASDU{}
+	for len(buf) > 0 {
+		tag, value, n, err := readTLV(buf)
+		if err != nil {
+			return a, err
+		}
+		buf = buf[n:]
+
+		switch tag {
+		case tagSvID:
+			a.SvID = string(value)
+		case tagDatSet:
+			a.DatSet = string(value)
+		case tagSmpCnt:
+			a.SmpCnt = uint16(readUint(value))
+		case tagConfRev:
+			a.ConfRev = uint32(readUint(value))
+		case tagRefrTm:
+			if len(value) != 8 {
+				return a, ErrMalformed
+			}
+			a.RefrTm = binary.BigEndian.Uint64(value)
+			a.HasRefrTm = true
+		case tagSmpSynch:
+			a.SmpSynch = uint8(readUint(value))
+		case tagSmpRate:
+			a.SmpRate = uint16(readUint(value))
+		case tagSmpMod:
+			a.SmpMod = uint16(readUint(value))
+			a.HasSmpMod = true
+		case tagGmID:
+			a.GmIdentity = append([]byte(nil), value...)
+		case tagSeqData:
+			if len(value)%8 != 0 {
+				return a, ErrMalformed
+			}
+			a.Values = make([]int32, len(value)/8)
+			a.Quality = make([]uint32, len(value)/8)
+			for i := range a.Values {
+				a.Values[i] = int32(binary.BigEndian.Uint32(value[8*i:]))
+				a.Quality[i] = binary.BigEndian.Uint32(value[8*i+4:])
+			}
+		}
+	}
+
+	if a.SvID == "" {
+		return a, ErrMalformed
+	}
+	return a, nil
+}
+
+// Encode encodes the frame, including the Ethernet header
+func (f *Frame) Encode() []byte {
+	apdu := EncodeAPDU(f.ASDUs)
+
+	buf := make([]byte, 0, 26+len(apdu))
+	buf = append(buf, f.Destination[:]...)
+	buf = append(buf, f.Source[:]...)
+	if f.HasVLAN {
+		buf = append(buf, etherTypeVLAN>>8, etherTypeVLAN&0xff, byte(f.VLAN>>8), byte(f.VLAN))
+	}
+	buf = append(buf, EtherType>>8, EtherType&0xff)
+
+	length := 8 + len(apdu)
+	var reserved byte
+	if f.Simulate {
+		reserved = 0x80
+	}
+	buf = append(buf, byte(f.APPID>>8), byte(f.APPID), byte(length>>8), byte(length), reserved, 0, 0, 0)
+	return append(buf, apdu...)
+}
+
+// EncodeAPDU encodes ASDUs as an SV APDU (savPdu)
+func EncodeAPDU(asdus []ASDU) []byte {
+	var seq []byte
+	for i := range asdus {
+		seq = appendTLV(seq, tagASDU, encodeASDU(&asdus[i]))
+	}
+
+	pdu := appendTLV(nil, tagNoASDU, []byte{byte(len(asdus))})
+	pdu = appendTLV(pdu, tagSeqASDU, seq)
+	return appendTLV(nil, tagSavPdu, pdu)
+}
+
+func encodeASDU(a *ASDU) []byte {
+	var buf []byte
+	var scratch [8]byte
+
+	buf = appendTLV(buf, tagSvID, []byte(a.SvID))
+	if a.DatSet != "" {
+		buf = appendTLV(buf, tagDatSet, []byte(a.DatSet))
+	}
+	binary.BigEndian.PutUint16(scratch[:], a.SmpCnt)
+	buf = appendTLV(buf, tagSmpCnt, scratch[:2])
+	binary.BigEndian.PutUint32(scratch[:], a.ConfRev)
+	buf = appendTLV(buf, tagConfRev, scratch[:4])
+	if a.HasRefrTm {
+		binary.BigEndian.PutUint64(scratch[:], a.RefrTm)
+		buf = appendTLV(buf, tagRefrTm, scratch[:8])
+	}
+	buf = appendTLV(buf, tagSmpSynch, []byte{a.SmpSynch})
+	if a.SmpRate != 0 {
+		binary.BigEndian.PutUint16(scratch[:], a.SmpRate)
+		buf = appendTLV(buf, tagSmpRate, scratch[:2])
+	}
+
+	data := make([]byte, 8*len(a.Values))
+	for i := range a.Values {
+		binary.BigEndian.PutUint32(data[8*i:], uint32(a.Values[i]))
+		if i < len(a.Quality) {
+			binary.BigEndian.PutUint32(data[8*i+4:], a.Quality[i])
+		}
+	}
+	buf = appendTLV(buf, tagSeqData, data)
+
+	if a.HasSmpMod {
+		binary.BigEndian.PutUint16(scratch[:], a.SmpMod)
+		buf = appendTLV(buf, tagSmpMod, scratch[:2])
+	}
+	if len(a.GmIdentity) > 0 {
+		buf = appendTLV(buf, tagGmID, a.GmIdentity)
+	}
+	return buf
+}
+
+// readTLV reads a BER tag, length and value, returning the total number of bytes used
+func readTLV(buf []byte) (byte, []byte, int, error) {
+	if len(buf) < 2 {
+		return 0, nil, 0, ErrMalformed
+	}
+	tag := buf[0]
+	length := int(buf[1])
+	pos := 2
+
+	// long form lengths
+	if length&0x80 != 0 {
+		bytes := length & 0x7f
+		if bytes == 0 || bytes > 2 || len(buf) < pos+bytes {
+			return 0, nil, 0, ErrMalformed
+		}
+		length = 0
+		for i := 0; i < bytes; i++ {
+			length = length<<8 | int(buf[pos+i])
+		}
+		pos += bytes
+	}
+
+	if len(buf) < pos+length {
+		return 0, nil, 0, ErrMalformed
+	}
+	return tag, buf[pos : pos+length], pos + length, nil
+}
+
+func appendTLV(buf []byte, tag byte, value []byte) []byte {
+	buf = append(buf, tag)
+	switch {
+	case len(value) < 0x80:
+		buf = append(buf, byte(len(value)))
+	case len(value) <= 0xff:
+		buf = append(buf, 0x81, byte(len(value)))
+	default:
+		buf = append(buf, 0x82, byte(len(value)>>8), byte(len(value)))
+	}
+	return append(buf, value...)
+}
+
+// readUint reads a big-endian unsigned integer of any length up to 8 bytes
+func readUint(buf []byte) uint64 {
+	var v uint64
+	for _, b := range buf {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
@@ -0,0 +1,149 @@
+package sv
+
+import (
+	"errors"
+	"time"
+
+	"github.com/synaptecltd/slipstream"
+)
+
+// ErrVariableCount is returned when the number of values in an ASDU does not match the number of variables, or when a
+// sample has fewer quality values than int32 values
+var ErrVariableCount = errors.New("SV dataset does not match the number of variables")
+
+// SampleWriter accepts samples, and is implemented by slipstream.StreamWriter and slipstream.FileWriter
+type SampleWriter interface {
+	Write(data *slipstream.DatasetWithQuality) error
+}
+
+// Ingester converts the ASDUs of an SV stream to samples, such as for a slipstream.StreamWriter
+type Ingester struct {
+	w             SampleWriter
+	svID          string
+	samplingRate  int
+	variableCount int
+	sample        slipstream.DatasetWithQuality
+}
+
+// NewIngester creates an Ingester for the SV stream with the given svID, sampling rate and number of variables. ASDUs
+// from other streams are ignored.
+func NewIngester(w SampleWriter, svID string, samplingRate int, variableCount int) *Ingester {
+	return &Ingester{
+		w:             w,
+		svID:          svID,
+		samplingRate:  samplingRate,
+		variableCount: variableCount,
+		sample: slipstream.DatasetWithQuality{
+			Int32s: make([]int32, variableCount),
+			Q:      make([]uint32, variableCount),
+		},
+	}
+}
+
+// WriteFrame parses an SV Ethernet frame, and writes a sample for each ASDU of the stream. The arrival time is used
+// to timestamp samples which do not include a reference time.
+func (s *Ingester) WriteFrame(buf []byte, arrival time.Time) error {
+	f, err := ParseFrame(buf)
+	if err != nil {
+		return err
+	}
+	for i := range f.ASDUs {
+		if err := s.WriteASDU(&f.ASDUs[i], arrival); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteASDU writes a sample for an ASDU, if it is from the stream
+func (s *Ingester) WriteASDU(a *ASDU, arrival time.Time) error {
+	if a.SvID != s.svID {
+		return nil
+	}
+	if len(a.Values) != s.variableCount || len(a.Quality) != s.variableCount {
+		return ErrVariableCount
+	}
+
+	if a.HasRefrTm {
+		s.sample.T = a.Timestamp()
+	} else {
+		s.sample.T = sampleTimestamp(a.SmpCnt, s.samplingRate, arrival)
+	}
+	copy(s.sample.Int32s, a.Values)
+	copy(s.sample.Q, a.Quality)
+	return s.w.Write(&s.sample)
+}
+
+// sampleTimestamp calculates the timestamp of a sample from its sample count, which is reset at the start of each
+// second, and the arrival time. The arrival time must be within half a second of the sampling time.
+func sampleTimestamp(smpCnt uint16, samplingRate int, arrival time.Time) uint64 {
+	offset := slipstream.SampleTimestamp(0, int(smpCnt), samplingRate)
+	t := arrival.UnixNano() - int64(offset)
+	second := (t + int64(time.Second)/2) / int64(time.Second) * int64(time.Second)
+	if second < 0 {
+		second = 0
+	}
+	return uint64(second) + offset
+}
+
+// Emitter regenerates SV frames from decoded samples, such as from slipstream.Decoder.Out
+type Emitter struct {
+	Frame          Frame // template for each frame, which is used for the Ethernet header and APPID
+	ASDU           ASDU  // template for each ASDU, which is used for the svID, confRev and other fields
+	ASDUsPerFrame  int   // number of ASDUs per frame, or 1 if zero
+	SamplingRate   int
+	IncludeRefrTm  bool // include the sample timestamp as the reference time
+	pending        []ASDU
+	pendingSamples int
+}
+
+// Emit converts samples to ASDUs, and returns any complete frames. The sample count of each ASDU is calculated from
+// the sample timestamp, and is reset at the start of each second. Each sample must have a quality value for each int32
+// variable.
+func (e *Emitter) Emit(samples []slipstream.DatasetWithQuality) ([][]byte, error) {
+	for i := range samples {
+		if len(samples[i].Q) < len(samples[i].Int32s) {
+			return nil, ErrVariableCount
+		}
+	}
+
+	perFrame := e.ASDUsPerFrame
+	if perFrame <= 0 {
+		perFrame = 1
+	}
+	if len(e.pending) != perFrame {
+		e.pending = make([]ASDU, perFrame)
+		e.pendingSamples = 0
+	}
+
+	var frames [][]byte
+	for i := range samples {
+		a := &e.pending[e.pendingSamples]
+		*a = e.ASDU
+		a.SmpCnt = sampleCount(samples[i].T, e.SamplingRate)
+		if e.IncludeRefrTm {
+			a.SetTimestamp(samples[i].T)
+		}
+		a.Values = append([]int32(nil), samples[i].Int32s...)
+		a.Quality = append([]uint32(nil), samples[i].Q[:len(samples[i].Int32s)]...)
+
+		e.pendingSamples++
+		if e.pendingSamples == perFrame {
+			f := e.Frame
+			f.ASDUs = e.pending
+			frames = append(frames, f.Encode())
+			e.pendingSamples = 0
+		}
+	}
+	return frames, nil
+}
+
+// sampleCount calculates the sample count of a sample from its timestamp
+func sampleCount(t uint64, samplingRate int) uint16 {
+	if samplingRate <= 0 {
+		return 0
+	}
+	offset := t % uint64(time.Second)
+	count := (offset*uint64(samplingRate) + uint64(time.Second)/2) / uint64(time.Second)
+	return uint16(count % uint64(samplingRate))
+}
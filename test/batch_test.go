@@ -0,0 +1,235 @@
+package slipstream_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/slipstream"
+)
+
+// batchConfigs are encoder settings with every type of variable, spatial references and each encoding option
+var batchConfigs = map[string]slipstream.Config{
+	"mixed":    {ID: ID, Int32Count: 3, Int16Count: 2, Int64Count: 2, Float32Count: 1, Float64Count: 1, SamplingRate: 4000, SamplesPerMessage: 80, SpatialRefs: []int{-1, 0, 0}},
+	"simple8b": {ID: ID, Int32Count: 3, Int16Count: 2, Int64Count: 2, Float32Count: 1, Float64Count: 1, SamplingRate: 4000, SamplesPerMessage: 5000, UseXOR: true},
+	"adaptive": {ID: ID, Int32Count: 3, Int16Count: 2, Int64Count: 2, Float64Count: 1, SamplingRate: 4000, SamplesPerMessage: 8, AdaptiveEncoding: true},
+	"variable": {ID: ID, Int32Count: 3, Int16Count: 1, SamplingRate: 4000, SamplesPerMessage: 800, VariableEncodings: []slipstream.VariableEncoding{{Raw: true}, {DeltaEncodingLayers: 3, UseXOR: true}, {DeltaEncodingLayers: 1, ElideConstant: true}, {DeltaEncodingLayers: 2}}},
+}
+
+// encodeSamples returns the messages produced by calling Encode() for each sample
+func encodeSamples(enc *slipstream.Encoder, data []slipstream.DatasetWithQuality) [][]byte {
+	var messages [][]byte
+	for d := range data {
+		buf, length, _ := enc.Encode(&data[d])
+		if length > 0 {
+			messages = append(messages, append([]byte(nil), buf[:length]...))
+		}
+	}
+	if buf, length, _ := enc.EndEncode(); length > 0 {
+		messages = append(messages, append([]byte(nil), buf[:length]...))
+	}
+	return messages
+}
+
+// columns returns the int32 values and quality of each variable in a slice of samples
+func columns(data []slipstream.DatasetWithQuality) ([][]int32, [][]uint32) {
+	cols := make([][]int32, len(data[0].Int32s))
+	q := make([][]uint32, len(data[0].Q))
+	for i := range cols {
+		for j := range data {
+			cols[i] = append(cols[i], data[j].Int32s[i])
+			q[i] = append(q[i], data[j].Q[i])
+		}
+	}
+	return cols, q
+}
+
+func TestEncodeMessage(t *testing.T) {
+	for name, c := range batchConfigs {
+		t.Run(name, func(t *testing.T) {
+			data := createMixedData(2*c.SamplesPerMessage+3, c)
+			enc, _ := slipstream.NewEncoderWithConfig(c)
+			expected := encodeSamples(enc, data)
+
+			// each message must be identical to the output of Encode()
+			batchEnc, _ := slipstream.NewEncoderWithConfig(c)
+			for i, msg := range expected {
+				start := i * c.SamplesPerMessage
+				end := start + c.SamplesPerMessage
+				if end > len(data) {
+					end = len(data)
+				}
+				buf, err := batchEnc.EncodeMessage(nil, data[start:end])
+				assert.NoError(t, err)
+				assert.Equal(t, msg, buf)
+			}
+		})
+	}
+
+	for _, name := range []string{"a10-2q", "b4000-4000s2", "e14400-14400q"} {
+		t.Run(name, func(t *testing.T) {
+			test := tests[name]
+			data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+			enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			batchEnc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			if test.useSpatialRefs {
+				enc.SetSpatialRefs(test.countOfVariables, test.countOfVariables/8, test.countOfVariables/8, true)
+				batchEnc.SetSpatialRefs(test.countOfVariables, test.countOfVariables/8, test.countOfVariables/8, true)
+			}
+
+			for i, msg := range encodeSamples(enc, data) {
+				buf, err := batchEnc.EncodeMessage(nil, data[i*test.samplesPerMessage:(i+1)*test.samplesPerMessage])
+				assert.NoError(t, err)
+				assert.Equal(t, msg, buf)
+			}
+		})
+	}
+}
+
+func TestEncodeColumns(t *testing.T) {
+	for _, name := range []string{"a10-2q", "b4000-80", "b4000-4000s2", "e14400-14400q"} {
+		t.Run(name, func(t *testing.T) {
+			test := tests[name]
+			data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+			enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			batchEnc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			if test.useSpatialRefs {
+				enc.SetSpatialRefs(test.countOfVariables, test.countOfVariables/8, test.countOfVariables/8, true)
+				batchEnc.SetSpatialRefs(test.countOfVariables, test.countOfVariables/8, test.countOfVariables/8, true)
+				dec.SetSpatialRefs(test.countOfVariables, test.countOfVariables/8, test.countOfVariables/8, true)
+			}
+
+			decodedCols := make([][]int32, test.countOfVariables)
+			decodedQ := make([][]uint32, test.countOfVariables)
+			for i := range decodedCols {
+				decodedCols[i] = make([]int32, test.samplesPerMessage)
+				decodedQ[i] = make([]uint32, test.samplesPerMessage)
+			}
+
+			for i, msg := range encodeSamples(enc, data) {
+				samples := data[i*test.samplesPerMessage : (i+1)*test.samplesPerMessage]
+				cols, q := columns(samples)
+				buf, err := batchEnc.EncodeColumns(nil, samples[0].T, cols, q)
+				assert.NoError(t, err)
+				assert.Equal(t, msg, buf)
+
+				t0, n, err := dec.DecodeColumns(msg, decodedCols, decodedQ)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				assert.Equal(t, samples[0].T, t0)
+				assert.Equal(t, len(samples), n)
+				for j := range cols {
+					assert.Equal(t, cols[j], decodedCols[j][:n])
+					assert.Equal(t, q[j], decodedQ[j][:n])
+				}
+			}
+		})
+	}
+}
+
+func TestBatchErrors(t *testing.T) {
+	test := tests["b4000-80"]
+	data := createInputData(createEmulator(test.samplingRate, 0), test.samplesPerMessage+1, test.countOfVariables, test.qualityChange)
+	enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	cols, q := columns(data[:test.samplesPerMessage])
+
+	_, err := enc.EncodeMessage(nil, nil)
+	assert.ErrorIs(t, err, slipstream.ErrSampleCountOverflow)
+	_, err = enc.EncodeMessage(nil, data)
+	assert.ErrorIs(t, err, slipstream.ErrSampleCountOverflow)
+	_, err = enc.EncodeColumns(nil, 0, cols[1:], q[1:])
+	assert.ErrorIs(t, err, slipstream.ErrVariableCount)
+	short := append([][]int32{cols[0][1:]}, cols[1:]...)
+	_, err = enc.EncodeColumns(nil, 0, short, q)
+	assert.ErrorIs(t, err, slipstream.ErrVariableCount)
+	invalid := append([]slipstream.DatasetWithQuality(nil), data[:2]...)
+	invalid[1].Int32s = invalid[1].Int32s[1:]
+	_, err = enc.EncodeMessage(nil, invalid)
+	assert.ErrorIs(t, err, slipstream.ErrVariableCount)
+
+	// a complete message cannot be encoded while a message is partially encoded by Encode()
+	enc.Encode(&data[0])
+	_, err = enc.EncodeMessage(nil, data[:2])
+	assert.ErrorIs(t, err, slipstream.ErrMessageInProgress)
+	msg := enc.AppendMessage(nil)
+
+	// the columns must have space for every sample in the message
+	_, _, err = dec.DecodeColumns(msg, cols, q)
+	assert.NoError(t, err)
+	_, _, err = dec.DecodeColumns(msg, cols[1:], q[1:])
+	assert.ErrorIs(t, err, slipstream.ErrVariableCount)
+	msg, _ = enc.EncodeColumns(nil, 0, cols, q)
+	_, _, err = dec.DecodeColumns(msg, short, q)
+	assert.ErrorIs(t, err, slipstream.ErrVariableCount)
+
+	// columns are only supported for int32 variables
+	mixed, _ := slipstream.NewEncoderWithConfig(batchConfigs["mixed"])
+	_, err = mixed.EncodeColumns(nil, 0, cols[:3], q[:3])
+	assert.ErrorIs(t, err, slipstream.ErrVariableCount)
+}
+
+func BenchmarkEncodeBatch(b1 *testing.B) {
+	for _, name := range messageBenchmarks {
+		test := tests[name]
+		data := createInputData(createEmulator(test.samplingRate, 0), test.samplesPerMessage, test.countOfVariables, test.qualityChange)
+		cols, q := columns(data)
+
+		for _, c := range []slipstream.PayloadCompressor{slipstream.GzipCompression, slipstream.NoCompression} {
+			enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			enc.SetCompressor(c)
+			dst := make([]byte, 0, enc.MaxMessageSize())
+			suffix := ""
+			if c == slipstream.NoCompression {
+				suffix = "-uncompressed"
+			}
+
+			b1.Run(name+suffix+"/samples", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					for d := range data {
+						dst = enc.EncodeTo(dst[:0], &data[d])
+					}
+				}
+			})
+			b1.Run(name+suffix+"/message", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					dst, _ = enc.EncodeMessage(dst[:0], data)
+				}
+			})
+			b1.Run(name+suffix+"/columns", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					dst, _ = enc.EncodeColumns(dst[:0], data[0].T, cols, q)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkDecodeColumns(b1 *testing.B) {
+	for _, name := range messageBenchmarks {
+		test := tests[name]
+		data := createInputData(createEmulator(test.samplingRate, 0), test.samplesPerMessage, test.countOfVariables, test.qualityChange)
+		cols, q := columns(data)
+		enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		enc.SetCompressor(slipstream.NoCompression)
+		dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		msg, _ := enc.EncodeColumns(nil, data[0].T, cols, q)
+
+		b1.Run(name+"/samples", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				dec.DecodeToBuffer(msg, len(msg))
+			}
+		})
+		b1.Run(name+"/columns", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				dec.DecodeColumns(msg, cols, q)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package slipstream_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/slipstream"
+)
+
+var compressors = map[string]slipstream.PayloadCompressor{
+	"none":  slipstream.NoCompression,
+	"gzip":  slipstream.GzipCompression,
+	"zstd":  slipstream.ZstdCompression,
+	"huff0": slipstream.Huff0Compression,
+	"fse":   slipstream.FSECompression,
+}
+
+// customCompressor is an application-defined compressor, which uses a built-in compressor with a different ID
+type customCompressor struct {
+	slipstream.PayloadCompressor
+	id byte
+}
+
+func (c customCompressor) ID() byte { return c.id }
+
+// encodeMessage returns the first complete message
+func encodeMessage(enc *slipstream.Encoder, data []slipstream.DatasetWithQuality) []byte {
+	for d := range data {
+		buf, length, _ := enc.Encode(&data[d])
+		if length > 0 {
+			return append([]byte(nil), buf[:length]...)
+		}
+	}
+	buf, length, _ := enc.EndEncode()
+	return append([]byte(nil), buf[:length]...)
+}
+
+func TestCompressors(t *testing.T) {
+	for _, name := range []string{"b4000-4000", "e14400-14400q", "f40000-40000"} {
+		test := tests[name]
+		data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+		compressed := test.samplesPerMessage > slipstream.UseGzipThresholdSamples
+
+		sizes := map[string]int{}
+		for compressorName, compressor := range compressors {
+			t.Run(name+"/"+compressorName, func(t *testing.T) {
+				enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+				enc.SetCompressor(compressor)
+				dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+
+				_, err := encodeAndDecode(t, &data, enc, dec, test.countOfVariables, test.samplesPerMessage, test.earlyEncodingStop)
+				assert.NoError(t, err)
+
+				// the header identifies the compressor, if the payload has been compressed
+				msg := encodeMessage(enc, data)
+				sizes[compressorName] = len(msg)
+				flags := msg[17] & (slipstream.FlagGzip | slipstream.FlagCompressed)
+				switch {
+				case !compressed || compressor.ID() == slipstream.CompressorNone:
+					assert.Equal(t, byte(0), flags)
+				case compressor.ID() == slipstream.CompressorGzip:
+					assert.Equal(t, byte(slipstream.FlagGzip), flags)
+				case flags != 0:
+					assert.Equal(t, byte(slipstream.FlagCompressed), flags)
+				}
+			})
+		}
+
+		// compression must never increase the message size
+		for compressorName, size := range sizes {
+			assert.LessOrEqual(t, size, sizes["none"], compressorName)
+		}
+	}
+}
+
+// the custom compressor is registered once, so that tests can be repeated
+func init() {
+	if err := slipstream.RegisterCompressor(customCompressor{slipstream.FSECompression, 200}); err != nil {
+		panic(err)
+	}
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	// built-in and reserved IDs cannot be registered, and each ID can only be registered once
+	for _, id := range []byte{slipstream.CompressorNone, slipstream.CompressorZstd, 5, slipstream.CompressorCustom - 1, 200} {
+		assert.ErrorIs(t, slipstream.RegisterCompressor(customCompressor{slipstream.FSECompression, id}), slipstream.ErrCompressorID, id)
+	}
+}
+
+func TestUnsupportedCompressor(t *testing.T) {
+	test := tests["e14400-14400"]
+	data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+
+	enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	enc.SetCompressor(customCompressor{slipstream.FSECompression, 201})
+	dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+
+	msg := encodeMessage(enc, data)
+	assert.Equal(t, byte(slipstream.FlagCompressed), msg[17]&slipstream.FlagCompressed)
+	_, err := dec.DecodeToBuffer(msg, len(msg))
+	assert.ErrorIs(t, err, slipstream.ErrUnsupportedCompressor)
+
+	// once registered, the decoder uses the compressor
+	enc.SetCompressor(customCompressor{slipstream.FSECompression, 200})
+	msg = encodeMessage(enc, data)
+	samples, err := dec.DecodeToBuffer(msg, len(msg))
+	assert.NoError(t, err)
+	for i := 0; i < samples; i++ {
+		assert.Equal(t, data[i].Int32s, dec.Out[i].Int32s)
+	}
+}
+
+func BenchmarkDecodeCompressors(b1 *testing.B) {
+	test := tests["f40000-40000"]
+	data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+
+	for _, name := range []string{"none", "gzip", "zstd", "huff0", "fse"} {
+		enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		enc.SetCompressor(compressors[name])
+		dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		msg := encodeMessage(enc, data)
+
+		b1.Run(name, func(b *testing.B) {
+			b.ReportMetric(float64(len(msg)), "bytes/msg")
+			for i := 0; i < b.N; i++ {
+				dec.DecodeToBuffer(msg, len(msg))
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package slipstream_test
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/slipstream"
+	"github.com/synaptecltd/slipstream/comtrade"
+)
+
+const comtradeCfg = `Substation A,Relay 1,1999
+4,2A,2D
+1,IA,A,Line 1,A,0.01,0,0,-32767,32767,1000,1,P
+2,VA,A,Line 1,kV,0.001,0.5,0,-32767,32767,400,0.11,P
+1,Trip,,Line 1,0
+2,Breaker open,,Line 1,1
+50
+1
+1000,4
+17/10/2026,12:30:00.000100
+17/10/2026,12:30:00.001100
+ASCII
+1
+`
+
+const comtradeDat = `1,0,100,-200,0,1
+2,1000,150,99999,0,1
+3,2000,,-180,1,1
+4,3000,120,-170,1,0
+`
+
+// createComtradeRecord creates a record with sinusoidal analog channels and changing digital channels
+func createComtradeRecord(format string, revision int, samples int) *comtrade.Record {
+	r := &comtrade.Record{
+		StationName: "Substation B",
+		DeviceID:    "Recorder 2",
+		Revision:    revision,
+		Analog: []comtrade.AnalogChannel{
+			{Name: "IA", Phase: "A", Unit: "A", A: 0.001, Min: -32767, Max: 32767, Primary: 2000, Secondary: 1, PS: "S"},
+			{Name: "IB", Phase: "B", Unit: "A", A: 0.001, Min: -32767, Max: 32767, Primary: 2000, Secondary: 1, PS: "S"},
+			{Name: "VA", Phase: "A", Unit: "V", A: 0.01, B: -1, Skew: 2.5, Min: -32767, Max: 32767, Primary: 1, Secondary: 1, PS: "P"},
+		},
+		Digital:       make([]comtrade.DigitalChannel, 18),
+		LineFrequency: 50,
+		SamplingRate:  4000,
+		Start:         time.Date(2026, 10, 17, 8, 0, 0, 250_000, time.UTC),
+		Trigger:       time.Date(2026, 10, 17, 8, 0, 0, 10_250_000, time.UTC),
+		Format:        format,
+	}
+	if revision == 2013 {
+		r.TimeCode = "+10h30"
+		r.LocalCode = "+10h30"
+	}
+	for i := range r.Digital {
+		r.Digital[i] = comtrade.DigitalChannel{Name: "D" + string(rune('A'+i)), Normal: i % 2}
+	}
+
+	start := uint64(r.Start.UnixNano())
+	for i := 0; i < samples; i++ {
+		s := slipstream.DatasetWithQuality{
+			T:      slipstream.SampleTimestamp(start, i, r.SamplingRate),
+			Int32s: make([]int32, r.VariableCount()),
+			Q:      make([]uint32, r.VariableCount()),
+		}
+		for j := range r.Analog {
+			s.Int32s[j] = int32(20000 * math.Sin(2*math.Pi*50*float64(i)/4000+float64(j)))
+		}
+		for j := range r.Digital {
+			if i > 40*(j+1) {
+				s.Int32s[len(r.Analog)+j] = 1
+			}
+		}
+		if i == 7 {
+			s.Q[1] = comtrade.QualityInvalid
+			s.Int32s[1] = 0
+		}
+		r.Samples = append(r.Samples, s)
+	}
+	return r
+}
+
+func TestComtradeRead(t *testing.T) {
+	r, err := comtrade.Read(strings.NewReader(comtradeCfg), strings.NewReader(comtradeDat))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, "Substation A", r.StationName)
+	assert.Equal(t, 1999, r.Revision)
+	assert.Equal(t, 1000, r.SamplingRate)
+	assert.Equal(t, "kV", r.Analog[1].Unit)
+	assert.Equal(t, 0.5, r.Analog[1].B)
+	assert.Equal(t, "Breaker open", r.Digital[1].Name)
+	assert.Equal(t, 1, r.Digital[1].Normal)
+	assert.Equal(t, time.Date(2026, 10, 17, 12, 30, 0, 100_000, time.UTC), r.Start)
+	assert.Equal(t, time.Date(2026, 10, 17, 12, 30, 0, 1_100_000, time.UTC), r.Trigger)
+
+	assert.Equal(t, 4, len(r.Samples))
+	assert.Equal(t, uint64(r.Start.UnixNano())+2_000_000, r.Samples[2].T)
+	assert.Equal(t, []int32{100, -200, 0, 1}, r.Samples[0].Int32s)
+	assert.Equal(t, []int32{120, -170, 1, 0}, r.Samples[3].Int32s)
+	assert.Equal(t, -0.2+0.5, r.Analog[1].Value(r.Samples[0].Int32s[1]))
+
+	// missing values
+	assert.Equal(t, []uint32{0, comtrade.QualityInvalid, 0, 0}, r.Samples[1].Q)
+	assert.Equal(t, []uint32{comtrade.QualityInvalid, 0, 0, 0}, r.Samples[2].Q)
+
+	// multiple sampling rates are not supported
+	cfg := strings.Replace(comtradeCfg, "1\n1000,4\n", "2\n1000,2\n2000,4\n", 1)
+	_, err = comtrade.Read(strings.NewReader(cfg), strings.NewReader(comtradeDat))
+	assert.ErrorIs(t, err, comtrade.ErrUnsupported)
+
+	// data file timestamps are scaled by the time multiplier
+	cfg = strings.Replace(comtradeCfg, "ASCII\n1\n", "ASCII\n2\n", 1)
+	dat := strings.NewReplacer(",1000,", ",500,", ",2000,", ",1000,", ",3000,", ",1500,").Replace(comtradeDat)
+	_, err = comtrade.Read(strings.NewReader(cfg), strings.NewReader(dat))
+	assert.NoError(t, err)
+
+	// samples which are not evenly spaced are not supported
+	_, err = comtrade.Read(strings.NewReader(comtradeCfg), strings.NewReader(dat))
+	assert.ErrorIs(t, err, comtrade.ErrUnsupported)
+	cfg = strings.Replace(comtradeCfg, "ASCII\n1\n", "ASCII\n0\n", 1)
+	_, err = comtrade.Read(strings.NewReader(cfg), strings.NewReader(comtradeDat))
+	assert.ErrorIs(t, err, comtrade.ErrInvalidConfig)
+
+	// the data file must contain all samples
+	_, err = comtrade.Read(strings.NewReader(comtradeCfg), strings.NewReader(comtradeDat[:20]))
+	assert.ErrorIs(t, err, comtrade.ErrInvalidData)
+
+	_, err = comtrade.Read(strings.NewReader(comtradeCfg[:40]), strings.NewReader(comtradeDat))
+	assert.ErrorIs(t, err, comtrade.ErrInvalidConfig)
+
+	// the sample and channel counts are not trusted before the data is read
+	cfg = strings.Replace(comtradeCfg, "1000,4\n", "1000,1000000000000\n", 1)
+	_, err = comtrade.Read(strings.NewReader(cfg), strings.NewReader(comtradeDat))
+	assert.ErrorIs(t, err, comtrade.ErrInvalidData)
+	cfg = strings.Replace(comtradeCfg, "4,2A,2D\n", "1000000002,1000000000A,2D\n", 1)
+	_, err = comtrade.Read(strings.NewReader(cfg), strings.NewReader(comtradeDat))
+	assert.ErrorIs(t, err, comtrade.ErrInvalidConfig)
+}
+
+func TestComtradeLongRecord(t *testing.T) {
+	// at one sample per second, the timestamps in microseconds exceed 32 bits after about 71 minutes
+	const samples = 2*60*60 + 1
+	for _, format := range []string{comtrade.FormatASCII, comtrade.FormatBinary32} {
+		r := createComtradeRecord(format, 1999, samples)
+		r.SamplingRate = 1
+		for i := range r.Samples {
+			r.Samples[i].T = slipstream.SampleTimestamp(uint64(r.Start.UnixNano()), i, r.SamplingRate)
+		}
+
+		var cfg, dat bytes.Buffer
+		if !assert.NoError(t, r.Write(&cfg, &dat)) {
+			t.FailNow()
+		}
+		assert.Contains(t, cfg.String(), "\r\n"+format+"\r\n2\r\n")
+		out, err := comtrade.Read(&cfg, &dat)
+		if !assert.NoError(t, err, format) {
+			t.FailNow()
+		}
+		assert.Equal(t, r.Samples, out.Samples)
+	}
+}
+
+func TestComtradeRoundTrip(t *testing.T) {
+	for _, format := range []string{comtrade.FormatASCII, comtrade.FormatBinary, comtrade.FormatBinary32} {
+		for _, revision := range []int{1999, 2013} {
+			t.Run(format+"-"+strconv.Itoa(revision), func(t *testing.T) {
+				r := createComtradeRecord(format, revision, 400)
+
+				var cfg, dat bytes.Buffer
+				if !assert.NoError(t, r.Write(&cfg, &dat)) {
+					t.FailNow()
+				}
+				out, err := comtrade.Read(&cfg, &dat)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+
+				assert.True(t, r.Start.Equal(out.Start))
+				assert.True(t, r.Trigger.Equal(out.Trigger))
+				out.Start, out.Trigger = r.Start, r.Trigger
+				assert.Equal(t, r, out)
+			})
+		}
+	}
+}
+
+func TestComtradeEncode(t *testing.T) {
+	r := createComtradeRecord(comtrade.FormatBinary32, 2013, 1000)
+	samplesPerMessage := 80
+
+	enc, err := slipstream.NewEncoderWithConfig(r.Config(ID, samplesPerMessage))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	dec, err := slipstream.NewDecoderWithConfig(enc.Config())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	out := &comtrade.Record{
+		StationName:   r.StationName,
+		DeviceID:      r.DeviceID,
+		Revision:      r.Revision,
+		Analog:        r.Analog,
+		Digital:       r.Digital,
+		LineFrequency: r.LineFrequency,
+		SamplingRate:  r.SamplingRate,
+		Trigger:       r.Trigger,
+		Format:        r.Format,
+		TimeCode:      r.TimeCode,
+		LocalCode:     r.LocalCode,
+	}
+	decode := func(buf []byte, length int, err error) {
+		assert.NoError(t, err)
+		if length > 0 {
+			samples, err := dec.DecodeToBuffer(buf, length)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			assert.NoError(t, out.Append(dec.Out[:samples]))
+		}
+	}
+	for i := range r.Samples {
+		decode(enc.Encode(&r.Samples[i]))
+	}
+	decode(enc.EndEncode())
+	assert.Equal(t, len(r.Samples), len(out.Samples))
+	assert.True(t, r.Start.Equal(out.Start))
+
+	// the converted files should match the original files
+	var cfg, dat, outCfg, outDat bytes.Buffer
+	assert.NoError(t, r.Write(&cfg, &dat))
+	assert.NoError(t, out.Write(&outCfg, &outDat))
+	assert.Equal(t, cfg.String(), outCfg.String())
+	assert.Equal(t, dat.Bytes(), outDat.Bytes())
+
+	assert.Equal(t, r.VariableCount(), len(r.Channels()))
+	assert.Equal(t, r.Analog[2].A, r.Channels()[2].Scale)
+	assert.Equal(t, r.Analog[2].B, r.Channels()[2].Offset)
+	assert.Equal(t, r.Analog[2].Value(-300), r.Channels()[2].Value(-300))
+}
+
+func TestComtradeFiles(t *testing.T) {
+	r := createComtradeRecord(comtrade.FormatBinary, 1999, 100)
+	name := filepath.Join(t.TempDir(), "record.cfg")
+	if !assert.NoError(t, r.WriteFiles(name)) {
+		t.FailNow()
+	}
+	out, err := comtrade.ReadFiles(name)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, r.Samples, out.Samples)
+}
@@ -0,0 +1,124 @@
+package slipstream_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/slipstream"
+	"github.com/synaptecltd/slipstream/csv"
+)
+
+const csvData = `Time (s), Ia, Va, Unused
+0.000, 1.2345, 230.104, x
+0.001, -1.2, 229.5, x
+0.002, , 228.996, x
+0.003, 0.0004, -10, x
+`
+
+func TestCSVRead(t *testing.T) {
+	format := csv.Format{
+		TimeColumn: "Time (s)",
+		Columns:    []csv.Column{{Name: "Va", Scale: 100}, {Name: "Ia", Scale: 1000}, {Name: "In", Optional: true}},
+		Start:      time.Unix(1_700_000_000, 0),
+	}
+	data, samplingRate, err := csv.Read(strings.NewReader(csvData), format)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, 1000, samplingRate)
+	assert.Equal(t, 4, len(data))
+	assert.Equal(t, []int32{23010, 1235, 0}, data[0].Int32s)
+	assert.Equal(t, []int32{22950, -1200, 0}, data[1].Int32s)
+	assert.Equal(t, []int32{22900, 0, 0}, data[2].Int32s)
+	assert.Equal(t, []uint32{0, csv.QualityInvalid, 0}, data[2].Q)
+	assert.Equal(t, []int32{-1000, 0, 0}, data[3].Int32s)
+	assert.Equal(t, uint64(1_700_000_000_003_000_000), data[3].T)
+
+	// the sampling rate can be provided if there is no time column
+	format.TimeColumn = ""
+	_, _, err = csv.Read(strings.NewReader(csvData), format)
+	assert.ErrorIs(t, err, csv.ErrSamplingRate)
+	format.SamplingRate = 4000
+	data, samplingRate, err = csv.Read(strings.NewReader(csvData), format)
+	assert.NoError(t, err)
+	assert.Equal(t, 4000, samplingRate)
+	assert.Equal(t, uint64(1_700_000_000_000_250_000), data[1].T)
+
+	// errors are returned rather than panicking
+	format.Columns = []csv.Column{{Name: "Vb"}}
+	_, _, err = csv.Read(strings.NewReader(csvData), format)
+	assert.ErrorIs(t, err, csv.ErrMissingColumn)
+	format.Columns = []csv.Column{{Name: "Unused"}}
+	_, _, err = csv.Read(strings.NewReader(csvData), format)
+	assert.ErrorIs(t, err, csv.ErrInvalidValue)
+	format.Columns = []csv.Column{{Name: "Va", Scale: 1e8}}
+	_, _, err = csv.Read(strings.NewReader(csvData), format)
+	assert.ErrorIs(t, err, csv.ErrInvalidValue)
+	format.Columns = []csv.Column{{Name: "Va", Scale: 100}}
+	for _, value := range []string{"NaN", "Inf", "-Inf", "1e400"} {
+		_, _, err = csv.Read(strings.NewReader(strings.Replace(csvData, "229.5", value, 1)), format)
+		assert.ErrorIs(t, err, csv.ErrInvalidValue, value)
+	}
+}
+
+// csvFormat maps the columns of the EPRI PQ data files to the currents in mA and voltages in units of 10 mV. Some
+// files contain only line-to-line voltages, so the phase voltages and neutral current are optional.
+var csvFormat = csv.Format{
+	TimeColumn: "Time (s)",
+	Columns: []csv.Column{
+		{Name: "Ia", Scale: 1000},
+		{Name: "Ib", Scale: 1000},
+		{Name: "Ic", Scale: 1000},
+		{Name: "In", Optional: true, Scale: 1000},
+		{Name: "Va", Optional: true, Scale: 100},
+		{Name: "Vb", Optional: true, Scale: 100},
+		{Name: "Vc", Optional: true, Scale: 100},
+	},
+}
+
+func TestCSVEncodeDecode(t *testing.T) {
+	for _, csvFile := range find("assets", ".csv")[:3] {
+		data, samplingRate, err := csv.ReadFile(csvFile, csvFormat)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		// compress the data, and write the decoded messages to a new CSV file
+		samplesPerMessage := samplingRate / 10
+		enc := slipstream.NewEncoder(ID, len(csvFormat.Columns), samplingRate, samplesPerMessage)
+		dec := slipstream.NewDecoder(ID, len(csvFormat.Columns), samplingRate, samplesPerMessage)
+		var out bytes.Buffer
+		w := csv.NewWriter(&out, csvFormat)
+
+		write := func(buf []byte, length int, err error) {
+			assert.NoError(t, err)
+			if length > 0 {
+				samples, err := dec.DecodeToBuffer(buf, length)
+				assert.NoError(t, err)
+				assert.NoError(t, w.Write(dec.Out[:samples]))
+			}
+		}
+		for i := range data {
+			write(enc.Encode(&data[i]))
+		}
+		write(enc.EndEncode())
+		assert.NoError(t, w.Flush())
+
+		// the new file should contain the same samples
+		decoded, decodedSamplingRate, err := csv.Read(&out, csvFormat)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, samplingRate, decodedSamplingRate)
+		assert.Equal(t, len(data), len(decoded))
+		for i := range data {
+			assert.Equal(t, data[i].T, decoded[i].T)
+			assert.Equal(t, data[i].Int32s, decoded[i].Int32s)
+			assert.Equal(t, data[i].Q, decoded[i].Q)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package slipstream_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/slipstream"
+)
+
+// deltaEncodings are the delta encodings used for every variable in the delta encoding tests and benchmarks
+var deltaEncodings = []slipstream.VariableEncoding{
+	{DeltaEncodingLayers: 1},
+	{DeltaEncodingLayers: 2},
+	{DeltaEncodingLayers: 3, UseXOR: true},
+	{DeltaEncodingLayers: 4},
+	{DeltaEncodingLayers: slipstream.MaxDeltaEncodingLayers, UseXOR: true},
+}
+
+// deltaConfig returns an encoder configuration which uses the same encoding for every variable
+func deltaConfig(samplingRate int, samplesPerMessage int, encoding slipstream.VariableEncoding) slipstream.Config {
+	encodings := make([]slipstream.VariableEncoding, 8)
+	for i := range encodings {
+		encodings[i] = encoding
+	}
+	return slipstream.Config{
+		ID:                ID,
+		Int32Count:        len(encodings),
+		SamplingRate:      samplingRate,
+		SamplesPerMessage: samplesPerMessage,
+		VariableEncodings: encodings,
+	}
+}
+
+func TestDeltaEncodingLayers(t *testing.T) {
+	for _, samplesPerMessage := range []int{5, 80, 14400} {
+		for layers := 0; layers <= slipstream.MaxDeltaEncodingLayers; layers++ {
+			for _, useXOR := range []bool{false, true} {
+				encoding := slipstream.VariableEncoding{DeltaEncodingLayers: layers, UseXOR: useXOR, Raw: layers == 0}
+				t.Run(fmt.Sprintf("%d/%d/xor=%v", samplesPerMessage, layers, useXOR), func(t *testing.T) {
+					c := deltaConfig(14400, samplesPerMessage, encoding)
+					enc, _ := slipstream.NewEncoderWithConfig(c)
+					dec, _ := slipstream.NewDecoderWithConfig(c)
+
+					// extreme values cause every layer of arithmetic delta encoding to overflow
+					data := createInputData(createEmulator(c.SamplingRate, 0), samplesPerMessage, c.Int32Count, false)
+					extremes := []int32{math.MinInt32, math.MaxInt32, 0, -1, 1, math.MinInt32 + 1, math.MaxInt32 - 1}
+					for j := range data {
+						data[j].Int32s[0] = extremes[j%len(extremes)]
+						data[j].Int32s[1] = extremes[(j*j)%len(extremes)]
+					}
+
+					messages := encodeSamples(enc, data)
+					if !assert.Len(t, messages, 1) {
+						t.FailNow()
+					}
+					samples, err := dec.DecodeToBuffer(messages[0], len(messages[0]))
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					for j := 0; j < samples; j++ {
+						assert.Equal(t, data[j].Int32s, dec.Out[j].Int32s)
+					}
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkDeltaEncoding measures the throughput of encoding and decoding uncompressed one second messages at high
+// sampling rates, for each number of delta encoding layers. BenchmarkDeltaKernels compares the kernels alone with the
+// per-value loops which they replaced.
+func BenchmarkDeltaEncoding(b1 *testing.B) {
+	for _, samplingRate := range []int{14400, 40000} {
+		data := createInputData(createEmulator(samplingRate, 0), samplingRate, 8, false)
+		for _, encoding := range deltaEncodings {
+			c := deltaConfig(samplingRate, samplingRate, encoding)
+			enc, _ := slipstream.NewEncoderWithConfig(c)
+			enc.SetCompressor(slipstream.NoCompression)
+			dec, _ := slipstream.NewDecoderWithConfig(c)
+			dst := make([]byte, 0, enc.MaxMessageSize())
+			msg := encodeSamples(enc, data)[0]
+			name := fmt.Sprintf("%d/layers=%d/xor=%v", samplingRate, encoding.DeltaEncodingLayers, encoding.UseXOR)
+
+			b1.Run(name+"/encode", func(b *testing.B) {
+				b.SetBytes(int64(samplingRate * c.Int32Count * 4))
+				for i := 0; i < b.N; i++ {
+					for d := range data {
+						dst = enc.EncodeTo(dst[:0], &data[d])
+					}
+				}
+			})
+			b1.Run(name+"/decode", func(b *testing.B) {
+				b.SetBytes(int64(samplingRate * c.Int32Count * 4))
+				for i := 0; i < b.N; i++ {
+					if _, err := dec.DecodeToBuffer(msg, len(msg)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package slipstream_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/slipstream"
+)
+
+// createCaptureData creates samples with absolute timestamps, starting at the given time in nanoseconds
+func createCaptureData(name string, start uint64) []slipstream.DatasetWithQuality {
+	test := tests[name]
+	data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+	for i := range data {
+		data[i].T = slipstream.SampleTimestamp(start, i, test.samplingRate)
+	}
+	return data
+}
+
+var captureChannels = []slipstream.Channel{
+	{Name: "IA", Unit: "A", Scale: 0.001},
+	{Name: "IB", Unit: "A", Scale: 0.001},
+	{Name: "IC", Unit: "A", Scale: 0.001},
+	{Name: "IN", Unit: "A", Scale: 0.001},
+	{Name: "VA", Unit: "V", Scale: 0.01},
+	{Name: "VB", Unit: "V", Scale: 0.01},
+	{Name: "VC", Unit: "V", Scale: 0.01},
+	{Name: "VN", Unit: "V", Scale: 0.01, Offset: -0.5},
+}
+
+// writeCaptureFile writes a capture file, returning the file name
+func writeCaptureFile(t *testing.T, name string, data []slipstream.DatasetWithQuality) string {
+	test := tests[name]
+	fileName := filepath.Join(t.TempDir(), name+".slip")
+
+	enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	w, err := slipstream.CreateFile(fileName, enc, captureChannels)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for i := range data {
+		assert.NoError(t, w.Write(&data[i]))
+	}
+	assert.NoError(t, w.Close())
+	return fileName
+}
+
+func TestCaptureFile(t *testing.T) {
+	for _, name := range []string{"a10-1", "b4000-80", "c4800-2", "e14400-14400q"} {
+		t.Run(name, func(t *testing.T) {
+			test := tests[name]
+			data := createCaptureData(name, 1_600_000_000_000_000_000)
+			fileName := writeCaptureFile(t, name, data)
+
+			f, err := slipstream.OpenFile(fileName)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			defer f.Close()
+
+			assert.Equal(t, captureChannels, f.Channels())
+			assert.Equal(t, test.samplingRate, f.Config().SamplingRate)
+			assert.Equal(t, (len(data)+test.samplesPerMessage-1)/test.samplesPerMessage, len(f.Index()))
+
+			samples := 0
+			for i, entry := range f.Index() {
+				assert.Equal(t, data[samples].T, entry.T)
+				out, err := f.ReadMessage(i)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				for j := range out {
+					// only the first timestamp of each message is encoded, so others may differ due to rounding
+					assert.Contains(t, []int64{-1, 0, 1}, int64(out[j].T-data[samples].T))
+					assert.Equal(t, data[samples].Int32s, out[j].Int32s)
+					assert.Equal(t, data[samples].Q, out[j].Q)
+					samples++
+				}
+			}
+			assert.Equal(t, len(data), samples)
+
+			// find the message containing any sample
+			assert.Equal(t, -1, f.FindMessage(data[0].T-1))
+			last := len(data) - 1
+			assert.Equal(t, last/test.samplesPerMessage, f.FindMessage(data[last].T))
+			assert.Equal(t, len(f.Index())-1, f.FindMessage(data[last].T+1e9))
+		})
+	}
+}
+
+func TestInvalidCaptureFile(t *testing.T) {
+	data := createCaptureData("b4000-80", 0)
+	fileName := writeCaptureFile(t, "b4000-80", data)
+	buf, err := os.ReadFile(fileName)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	corrupt := func(modify func([]byte) []byte) error {
+		name := filepath.Join(t.TempDir(), "corrupt.slip")
+		assert.NoError(t, os.WriteFile(name, modify(append([]byte(nil), buf...)), 0o600))
+		f, err := slipstream.OpenFile(name)
+		if err == nil {
+			f.Close()
+		}
+		return err
+	}
+
+	assert.ErrorIs(t, corrupt(func(b []byte) []byte { return b[:10] }), slipstream.ErrInvalidFile)
+	assert.ErrorIs(t, corrupt(func(b []byte) []byte { b[0] = 'X'; return b }), slipstream.ErrInvalidFile)
+	assert.ErrorIs(t, corrupt(func(b []byte) []byte { b[len(b)-20]++; return b }), slipstream.ErrInvalidFile)
+
+	// the index must be sorted by timestamp
+	assert.ErrorIs(t, corrupt(func(b []byte) []byte {
+		count := int(binary.BigEndian.Uint64(b[len(b)-12:]))
+		binary.BigEndian.PutUint64(b[len(b)-20-16*count+8:], math.MaxUint64)
+		return b
+	}), slipstream.ErrInvalidFile)
+
+	// the channels must match the variables
+	enc := slipstream.NewEncoder(ID, 8, 4000, 80)
+	_, err = slipstream.NewFileWriter(os.Stdout, enc, captureChannels[:2])
+	assert.ErrorIs(t, err, slipstream.ErrInvalidConfig)
+}
+
+func TestCaptureFileWithoutIndex(t *testing.T) {
+	name := "b4000-80"
+	test := tests[name]
+	data := createCaptureData(name, 1_600_000_000_000_000_000)
+	fileName := writeCaptureFile(t, name, data)
+	buf, err := os.ReadFile(fileName)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	complete, err := slipstream.OpenFile(fileName)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer complete.Close()
+	index := complete.Index()
+
+	sizes := map[int]int{
+		int(index[20].Offset):      20,         // a recorder which stopped after a complete message
+		int(index[20].Offset) + 10: 20,         // a partial message is ignored
+		len(buf) - 8:               len(index), // the messages are complete, but the index is not
+	}
+	for size, messages := range sizes {
+		f, err := slipstream.NewFileReader(bytes.NewReader(buf[:size]), int64(size))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, index[:messages], f.Index())
+
+		samples := 0
+		for i := range f.Index() {
+			out, err := f.ReadMessage(i)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			for j := range out {
+				assert.Equal(t, data[samples].Int32s, out[j].Int32s)
+				samples++
+			}
+		}
+		assert.Equal(t, messages*test.samplesPerMessage, samples)
+	}
+}
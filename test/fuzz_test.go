@@ -0,0 +1,456 @@
+package slipstream_test
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/synaptecltd/slipstream"
+)
+
+// fuzzVariables is the number of variables used for fuzzing, which allows spatial references to be used
+const fuzzVariables = 16
+
+// fuzzRecordSize is the number of bytes of fuzz input used to generate each sample
+const fuzzRecordSize = fuzzVariables*4 + 1
+
+// fuzzCSVSamples is the number of samples read from each CSV file to seed the fuzz corpus
+const fuzzCSVSamples = 2*80 + 1
+
+// fuzzSamplingRate is the sampling rate used for fuzzing (this does not affect the encoding)
+const fuzzSamplingRate = 4000
+
+type fuzzConfig struct {
+	samplesPerMessage int
+	useXOR            bool
+	useSpatialRefs    bool
+}
+
+// fuzzConfigs covers every combination of varint and simple-8b encoding, XOR and spatial references
+var fuzzConfigs = []fuzzConfig{
+	{samplesPerMessage: 8},
+	{samplesPerMessage: 8, useXOR: true},
+	{samplesPerMessage: 8, useSpatialRefs: true},
+	{samplesPerMessage: 8, useXOR: true, useSpatialRefs: true},
+	{samplesPerMessage: 80},
+	{samplesPerMessage: 80, useXOR: true},
+	{samplesPerMessage: 80, useSpatialRefs: true},
+	{samplesPerMessage: 80, useXOR: true, useSpatialRefs: true},
+}
+
+// fuzzCompressors are the payload compressors used for fuzzing, including a compressor registered by the application
+var fuzzCompressors = []slipstream.PayloadCompressor{
+	slipstream.NoCompression,
+	slipstream.GzipCompression,
+	slipstream.ZstdCompression,
+	slipstream.Huff0Compression,
+	slipstream.FSECompression,
+	customCompressor{slipstream.FSECompression, 200},
+}
+
+// fuzzTypedConfigs are the batch encoder settings in a fixed order, which cover every type of variable, spatial
+// references and the encoding of each variable. Messages are limited to 80 samples, and are compressed if they have
+// more than 8 samples.
+var fuzzTypedConfigs = func() []slipstream.Config {
+	names := make([]string, 0, len(batchConfigs))
+	for name := range batchConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	configs := make([]slipstream.Config, len(names))
+	for i, name := range names {
+		configs[i] = batchConfigs[name]
+		if configs[i].SamplesPerMessage > 80 {
+			configs[i].SamplesPerMessage = 80
+		}
+		configs[i].UseGzipThresholdSamples = 8
+	}
+	return configs
+}()
+
+func newFuzzEncoder(samplesPerMessage int, useXOR bool, useSpatialRefs bool) *slipstream.Encoder {
+	enc := slipstream.NewEncoder(ID, fuzzVariables, fuzzSamplingRate, samplesPerMessage)
+	enc.SetXOR(useXOR)
+	if useSpatialRefs {
+		enc.SetSpatialRefs(fuzzVariables, fuzzVariables/8, fuzzVariables/8, true)
+	}
+	return enc
+}
+
+func newFuzzDecoder(samplesPerMessage int, useXOR bool, useSpatialRefs bool) *slipstream.Decoder {
+	dec := slipstream.NewDecoder(ID, fuzzVariables, fuzzSamplingRate, samplesPerMessage)
+	dec.SetXOR(useXOR)
+	if useSpatialRefs {
+		dec.SetSpatialRefs(fuzzVariables, fuzzVariables/8, fuzzVariables/8, true)
+	}
+	return dec
+}
+
+// newTypedFuzzEncoder creates an encoder for one of fuzzTypedConfigs, with a payload compressor from fuzzCompressors
+func newTypedFuzzEncoder(c slipstream.Config, compressor int, checksum bool) (*slipstream.Encoder, error) {
+	c.UseChecksum = checksum
+	enc, err := slipstream.NewEncoderWithConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	enc.SetCompressor(fuzzCompressors[compressor%len(fuzzCompressors)])
+	return enc, nil
+}
+
+// createFuzzDataCSV creates 16 variables from each CSV file, using the values from the next sample for the
+// additional variables
+func createFuzzDataCSV(tb testing.TB, filename string) []slipstream.DatasetWithQuality {
+	csvData, _ := createInputDataCSV(tb, filename, fuzzCSVSamples)
+
+	data := make([]slipstream.DatasetWithQuality, len(csvData))
+	for i := range data {
+		next := csvData[(i+1)%len(csvData)]
+		data[i].T = csvData[i].T
+		data[i].Int32s = append(append(make([]int32, 0, fuzzVariables), csvData[i].Int32s...), next.Int32s...)
+		data[i].Q = make([]uint32, fuzzVariables)
+	}
+	return data
+}
+
+// datasetToBytes converts samples into the representation used for fuzz input
+func datasetToBytes(data []slipstream.DatasetWithQuality) []byte {
+	buf := make([]byte, 0, len(data)*fuzzRecordSize)
+	value := make([]byte, 4)
+	for i := range data {
+		for j := 0; j < fuzzVariables; j++ {
+			binary.BigEndian.PutUint32(value, uint32(data[i].Int32s[j]))
+			buf = append(buf, value...)
+		}
+
+		// record any quality change for one variable
+		var qualityChange byte
+		for j := 0; j < fuzzVariables; j++ {
+			if data[i].Q[j] != 0 {
+				qualityChange = byte(data[i].Q[j])
+			}
+		}
+		buf = append(buf, qualityChange)
+	}
+	return buf
+}
+
+// bytesToDataset converts arbitrary fuzz input into samples, including quality changes
+func bytesToDataset(buf []byte) []slipstream.DatasetWithQuality {
+	data := make([]slipstream.DatasetWithQuality, len(buf)/fuzzRecordSize)
+	quality := make([]uint32, fuzzVariables)
+	for i := range data {
+		record := buf[i*fuzzRecordSize : (i+1)*fuzzRecordSize]
+
+		data[i].T = uint64(i)
+		data[i].Int32s = make([]int32, fuzzVariables)
+		for j := range data[i].Int32s {
+			data[i].Int32s[j] = int32(binary.BigEndian.Uint32(record[j*4:]))
+		}
+
+		// a non-zero value changes the quality of one variable, which persists for later samples
+		if qualityChange := record[fuzzRecordSize-1]; qualityChange != 0 {
+			quality[int(qualityChange)%fuzzVariables] = uint32(qualityChange) << (qualityChange % 24)
+		}
+		data[i].Q = append([]uint32(nil), quality...)
+	}
+	return data
+}
+
+// typedRecordSize returns the number of bytes of fuzz input used to generate each sample with a configuration
+func typedRecordSize(c slipstream.Config) int {
+	return 4*c.Int32Count + 2*c.Int16Count + 8*c.Int64Count + 4*c.Float32Count + 8*c.Float64Count + 1
+}
+
+// typedDatasetToBytes converts samples with every type of variable into the representation used for fuzz input
+func typedDatasetToBytes(data []slipstream.DatasetWithQuality) []byte {
+	var buf []byte
+	var value [8]byte
+	for i := range data {
+		d := &data[i]
+		for _, v := range d.Int32s {
+			binary.BigEndian.PutUint32(value[:], uint32(v))
+			buf = append(buf, value[:4]...)
+		}
+		for _, v := range d.Int16s {
+			binary.BigEndian.PutUint16(value[:], uint16(v))
+			buf = append(buf, value[:2]...)
+		}
+		for _, v := range d.Int64s {
+			binary.BigEndian.PutUint64(value[:], uint64(v))
+			buf = append(buf, value[:8]...)
+		}
+		for _, v := range d.Float32s {
+			binary.BigEndian.PutUint32(value[:], math.Float32bits(v))
+			buf = append(buf, value[:4]...)
+		}
+		for _, v := range d.Float64s {
+			binary.BigEndian.PutUint64(value[:], math.Float64bits(v))
+			buf = append(buf, value[:8]...)
+		}
+
+		// record any quality change for one variable
+		var qualityChange byte
+		for _, q := range d.Q {
+			if q != 0 {
+				qualityChange = byte(q)
+			}
+		}
+		buf = append(buf, qualityChange)
+	}
+	return buf
+}
+
+// bytesToTypedDataset converts arbitrary fuzz input into samples with the variables of a configuration, including
+// quality changes
+func bytesToTypedDataset(buf []byte, c slipstream.Config) []slipstream.DatasetWithQuality {
+	size := typedRecordSize(c)
+	variables := c.Int32Count + c.Int16Count + c.Int64Count + c.Float32Count + c.Float64Count
+	data := make([]slipstream.DatasetWithQuality, len(buf)/size)
+	quality := make([]uint32, variables)
+	for i := range data {
+		record := buf[i*size : (i+1)*size]
+		d := &data[i]
+
+		d.T = uint64(i)
+		d.Int32s = make([]int32, c.Int32Count)
+		for j := range d.Int32s {
+			d.Int32s[j] = int32(binary.BigEndian.Uint32(record))
+			record = record[4:]
+		}
+		d.Int16s = make([]int16, c.Int16Count)
+		for j := range d.Int16s {
+			d.Int16s[j] = int16(binary.BigEndian.Uint16(record))
+			record = record[2:]
+		}
+		d.Int64s = make([]int64, c.Int64Count)
+		for j := range d.Int64s {
+			d.Int64s[j] = int64(binary.BigEndian.Uint64(record))
+			record = record[8:]
+		}
+		d.Float32s = make([]float32, c.Float32Count)
+		for j := range d.Float32s {
+			d.Float32s[j] = math.Float32frombits(binary.BigEndian.Uint32(record))
+			record = record[4:]
+		}
+		d.Float64s = make([]float64, c.Float64Count)
+		for j := range d.Float64s {
+			d.Float64s[j] = math.Float64frombits(binary.BigEndian.Uint64(record))
+			record = record[8:]
+		}
+
+		// a non-zero value changes the quality of one variable, which persists for later samples
+		if qualityChange := record[0]; qualityChange != 0 {
+			quality[int(qualityChange)%variables] = uint32(qualityChange) << (qualityChange % 24)
+		}
+		d.Q = append([]uint32(nil), quality...)
+	}
+	return data
+}
+
+// firstSamples returns up to the first n samples
+func firstSamples(data []slipstream.DatasetWithQuality, n int) []slipstream.DatasetWithQuality {
+	if n > len(data) {
+		n = len(data)
+	}
+	return data[:n]
+}
+
+// encodeMessages returns each encoded message for the input data
+func encodeMessages(enc *slipstream.Encoder, data []slipstream.DatasetWithQuality) [][]byte {
+	var messages [][]byte
+	for i := range data {
+		buf, length, _ := enc.Encode(&data[i])
+		if length == 0 && i == len(data)-1 {
+			buf, length, _ = enc.EndEncode()
+		}
+		if length > 0 {
+			messages = append(messages, append([]byte(nil), buf[:length]...))
+		}
+	}
+	return messages
+}
+
+// extremeDataset creates samples using extreme int32 values and frequent quality changes
+func extremeDataset(samples int) []slipstream.DatasetWithQuality {
+	extremes := []int32{math.MinInt32, math.MaxInt32, 0, -1, 1, math.MinInt32 + 1, math.MaxInt32 - 1}
+
+	data := make([]slipstream.DatasetWithQuality, samples)
+	for i := range data {
+		data[i].Int32s = make([]int32, fuzzVariables)
+		data[i].Q = make([]uint32, fuzzVariables)
+		for j := range data[i].Int32s {
+			data[i].Int32s[j] = extremes[(i*(j+1))%len(extremes)]
+			data[i].Q[j] = uint32(i % (j + 1))
+		}
+	}
+	return data
+}
+
+// cubicDataset creates samples for which the third-order differences are all -1, which produces long runs of
+// identical simple-8b values
+func cubicDataset(samples int) []slipstream.DatasetWithQuality {
+	data := make([]slipstream.DatasetWithQuality, samples)
+	for i := range data {
+		data[i].Int32s = make([]int32, fuzzVariables)
+		data[i].Q = make([]uint32, fuzzVariables)
+		for j := range data[i].Int32s {
+			data[i].Int32s[j] = int32(-i * (i - 1) * (i - 2) / 6)
+		}
+	}
+	return data
+}
+
+func FuzzDecode(f *testing.F) {
+	// seed the corpus with valid messages for each encoding configuration
+	for _, csvFile := range find("assets", ".csv") {
+		data := createFuzzDataCSV(f, csvFile)
+		for _, cfg := range fuzzConfigs {
+			enc := newFuzzEncoder(cfg.samplesPerMessage, cfg.useXOR, cfg.useSpatialRefs)
+			messages := encodeMessages(enc, firstSamples(data, cfg.samplesPerMessage))
+			f.Add(messages[0])
+		}
+	}
+
+	for _, c := range fuzzTypedConfigs {
+		data := createMixedData(c.SamplesPerMessage, c)
+		for compressor := range fuzzCompressors {
+			for _, checksum := range []bool{false, true} {
+				enc, err := newTypedFuzzEncoder(c, compressor, checksum)
+				if err != nil {
+					f.Fatal(err)
+				}
+				f.Add(encodeMessages(enc, data)[0])
+			}
+		}
+	}
+
+	// the compressor and checksum are signalled in the message header, so one decoder is used for each configuration
+	var decoders []*slipstream.Decoder
+	for _, cfg := range fuzzConfigs {
+		decoders = append(decoders, newFuzzDecoder(cfg.samplesPerMessage, cfg.useXOR, cfg.useSpatialRefs))
+	}
+	for _, c := range fuzzTypedConfigs {
+		dec, err := slipstream.NewDecoderWithConfig(c)
+		if err != nil {
+			f.Fatal(err)
+		}
+		decoders = append(decoders, dec)
+	}
+
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		for _, dec := range decoders {
+			samplesDecoded, err := dec.DecodeToBuffer(msg, len(msg))
+			if err == nil && (samplesDecoded < 1 || samplesDecoded > dec.SamplesPerMessage) {
+				t.Fatalf("invalid number of samples decoded: %d", samplesDecoded)
+			}
+		}
+	})
+}
+
+// FuzzEncodeDecode encodes and decodes arbitrary samples. A typed value of zero uses int32 variables, and other values
+// select one of fuzzTypedConfigs, of which the spatial references are only used if useSpatialRefs is set.
+func FuzzEncodeDecode(f *testing.F) {
+	// seed the corpus with data from each CSV file
+	for _, csvFile := range find("assets", ".csv") {
+		data := createFuzzDataCSV(f, csvFile)
+		for _, cfg := range fuzzConfigs {
+			f.Add(datasetToBytes(firstSamples(data, 2*cfg.samplesPerMessage+1)), uint16(cfg.samplesPerMessage), cfg.useXOR, cfg.useSpatialRefs, uint8(0), uint8(0), false)
+		}
+	}
+	for _, cfg := range fuzzConfigs {
+		f.Add(datasetToBytes(extremeDataset(cfg.samplesPerMessage)), uint16(cfg.samplesPerMessage), cfg.useXOR, cfg.useSpatialRefs, uint8(0), uint8(0), false)
+	}
+	f.Add(datasetToBytes(cubicDataset(200)), uint16(200), false, false, uint8(0), uint8(0), false)
+
+	// seed every type of variable with each compressor, with and without the checksum
+	for i, c := range fuzzTypedConfigs {
+		input := typedDatasetToBytes(createMixedData(2*c.SamplesPerMessage+1, c))
+		for compressor := range fuzzCompressors {
+			for _, checksum := range []bool{false, true} {
+				f.Add(input, uint16(c.SamplesPerMessage), c.UseXOR, true, uint8(i+1), uint8(compressor), checksum)
+			}
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte, samplesPerMessage uint16, useXOR bool, useSpatialRefs bool, typed uint8, compressor uint8, checksum bool) {
+		if samplesPerMessage == 0 || samplesPerMessage > 512 {
+			t.Skip()
+		}
+
+		if typed > 0 {
+			c := fuzzTypedConfigs[int(typed-1)%len(fuzzTypedConfigs)]
+			c.SamplesPerMessage = int(samplesPerMessage)
+			c.UseXOR = useXOR
+			if !useSpatialRefs {
+				c.SpatialRefs = nil
+			}
+			data := bytesToTypedDataset(input, c)
+			if len(data) == 0 {
+				t.Skip()
+			}
+
+			enc, err := newTypedFuzzEncoder(c, int(compressor), checksum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dec, err := slipstream.NewDecoderFromConfig(enc.EncodeConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			encodeAndDecodeMixedData(t, enc, dec, data)
+			return
+		}
+
+		data := bytesToDataset(input)
+		if len(data) == 0 {
+			t.Skip()
+		}
+
+		enc := newFuzzEncoder(int(samplesPerMessage), useXOR, useSpatialRefs)
+		enc.SetChecksum(checksum)
+		enc.SetCompressor(fuzzCompressors[int(compressor)%len(fuzzCompressors)])
+		dec := newFuzzDecoder(int(samplesPerMessage), useXOR, useSpatialRefs)
+
+		stats, err := encodeAndDecode(t, &data, enc, dec, fuzzVariables, int(samplesPerMessage), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.samples != len(data) {
+			t.Fatalf("encoded %d samples, expected %d", stats.samples, len(data))
+		}
+	})
+}
+
+func FuzzDecodeConfig(f *testing.F) {
+	// seed the corpus with the configuration of each type of encoder
+	for _, cfg := range fuzzConfigs {
+		f.Add(newFuzzEncoder(cfg.samplesPerMessage, cfg.useXOR, cfg.useSpatialRefs).EncodeConfig())
+	}
+	for _, c := range batchConfigs {
+		enc, err := slipstream.NewEncoderWithConfig(c)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(enc.EncodeConfig())
+	}
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		c, err := slipstream.DecodeConfig(buf)
+		if err != nil {
+			if slipstream.IsConfig(buf) {
+				t.Fatal("IsConfig() accepted an invalid configuration")
+			}
+			return
+		}
+
+		// a valid configuration must be usable by both encoders and decoders
+		if _, err := slipstream.NewDecoderFromConfig(buf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := slipstream.NewEncoderWithConfig(*c); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,6 @@
+//go:build !race
+
+package slipstream_test
+
+// raceEnabled is true if the race detector is enabled, which causes additional allocations
+const raceEnabled = false
@@ -0,0 +1,66 @@
+package slipstream_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/slipstream"
+)
+
+func TestQuality(t *testing.T) {
+	var q slipstream.Quality
+	assert.Equal(t, slipstream.ValidityGood, q.Validity())
+	assert.Equal(t, slipstream.SourceProcess, q.Source())
+
+	q.SetValidity(slipstream.ValidityQuestionable)
+	q.Set(slipstream.QualityOldData|slipstream.QualityInaccurate, true)
+	q.SetSource(slipstream.SourceSubstituted)
+	q.SetTest(true)
+	q.SetOperatorBlocked(true)
+	q.SetDerived(true)
+	assert.Equal(t, slipstream.Quality(0x3e83), q)
+	assert.Equal(t, slipstream.ValidityQuestionable, q.Validity())
+	assert.Equal(t, slipstream.QualityOldData|slipstream.QualityInaccurate, q.Detail())
+	assert.True(t, q.Has(slipstream.QualityOldData))
+	assert.False(t, q.Has(slipstream.QualityOldData|slipstream.QualityFailure))
+	assert.Equal(t, slipstream.SourceSubstituted, q.Source())
+	assert.True(t, q.Test())
+	assert.True(t, q.OperatorBlocked())
+	assert.True(t, q.Derived())
+
+	// time quality is in the most significant byte, and does not affect the data quality
+	q.SetLeapSecondKnown(true)
+	q.SetClockNotSynchronised(true)
+	q.SetTimeAccuracy(20)
+	assert.Equal(t, slipstream.Quality(0xb4003e83), q)
+	assert.True(t, q.LeapSecondKnown())
+	assert.False(t, q.ClockFailure())
+	assert.True(t, q.ClockNotSynchronised())
+	assert.Equal(t, 20, q.TimeAccuracy())
+	q.SetTimeAccuracy(slipstream.TimeAccuracyUnspecified)
+	assert.Equal(t, slipstream.TimeAccuracyUnspecified, q.TimeAccuracy())
+
+	q.SetValidity(slipstream.ValidityInvalid)
+	q.SetTest(false)
+	q.SetDerived(false)
+	q.SetClockNotSynchronised(false)
+	assert.Equal(t, slipstream.Quality(0x9f001681), q)
+
+	// quality values are preserved by encoding and decoding
+	test := tests["b4000-80"]
+	data := createInputData(createEmulator(test.samplingRate, 0), test.samplesPerMessage, test.countOfVariables, false)
+	for i := range data {
+		data[i].SetQuality(1, q)
+	}
+	enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	var buf []byte
+	var length int
+	for i := range data {
+		buf, length, _ = enc.Encode(&data[i])
+	}
+	_, err := dec.DecodeToBuffer(buf, length)
+	assert.NoError(t, err)
+	assert.Equal(t, q, dec.Out[test.samplesPerMessage-1].Quality(1))
+	assert.Equal(t, slipstream.ValidityGood, dec.Out[0].Quality(0).Validity())
+}
@@ -0,0 +1,189 @@
+package slipstream_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/slipstream"
+)
+
+// writeStreamFile writes samples to a file of length-prefixed messages, appending to any existing file
+func writeStreamFile(t *testing.T, fileName string, enc *slipstream.Encoder, data []slipstream.DatasetWithQuality) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer file.Close()
+
+	w := slipstream.NewStreamWriter(file, enc)
+	for i := range data {
+		assert.NoError(t, w.Write(&data[i]))
+	}
+	assert.NoError(t, w.Close())
+}
+
+// expectedRange returns the input samples within a time range
+func expectedRange(data []slipstream.DatasetWithQuality, start, end time.Time) []slipstream.DatasetWithQuality {
+	var out []slipstream.DatasetWithQuality
+	for _, d := range data {
+		if d.T >= uint64(start.UnixNano()) && d.T < uint64(end.UnixNano()) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func assertSamplesEqual(t *testing.T, expected []slipstream.DatasetWithQuality, actual []slipstream.DatasetWithQuality) {
+	if !assert.Equal(t, len(expected), len(actual)) {
+		return
+	}
+	for i := range expected {
+		assert.Equal(t, expected[i].Int32s, actual[i].Int32s)
+		assert.Equal(t, expected[i].Q, actual[i].Q)
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	const start = 1_600_000_000_000_000_000
+	name := "b4000-80"
+	test := tests[name]
+	data := createCaptureData(name, start)
+	period := time.Second / time.Duration(test.samplingRate)
+
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	writeStreamFile(t, fileName, slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage), data)
+	captureName := writeCaptureFile(t, name, data)
+
+	stream, err := slipstream.OpenStreamFile(fileName)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer stream.Close()
+	capture, err := slipstream.OpenFile(captureName)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer capture.Close()
+
+	// times are offset by half a sample period, to avoid ambiguity due to the rounding of timestamps
+	at := func(sample int) time.Time {
+		return time.Unix(0, start).Add(time.Duration(sample)*period + period/2)
+	}
+	ranges := []struct {
+		start, end time.Time
+	}{
+		{at(100), at(110)},                         // within one message
+		{at(1970), at(2030)},                       // across message boundaries
+		{at(-100), at(5)},                          // starting before the data
+		{at(3990), at(5000)},                       // ending after the data
+		{at(-1), at(len(data))},                    // all samples
+		{at(len(data)), at(len(data) + 100)},       // after the data
+		{at(200), at(100)},                         // empty range
+		{time.Unix(0, start), time.Unix(0, start)}, // zero length
+	}
+
+	for _, r := range ranges {
+		expected := expectedRange(data, r.start, r.end)
+
+		out, err := stream.ReadRange(r.start, r.end)
+		assert.NoError(t, err)
+		assertSamplesEqual(t, expected, out)
+
+		out, err = capture.ReadRange(r.start, r.end)
+		assert.NoError(t, err)
+		assertSamplesEqual(t, expected, out)
+	}
+
+	index, err := stream.Index()
+	assert.NoError(t, err)
+	assert.Equal(t, capture.Index()[len(index)-1].T, index[len(index)-1].T)
+	assert.Equal(t, len(data)/test.samplesPerMessage, len(index))
+}
+
+func TestReadRangeConfigChange(t *testing.T) {
+	// a second stream, with a different configuration, is appended to the file
+	const start = 1_600_000_000_000_000_000
+	first := createCaptureData("b4000-80", start)
+	second := createCaptureData("c4800-2", start+uint64(time.Second))
+
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	writeStreamFile(t, fileName, slipstream.NewEncoder(ID, 8, 4000, 80), first)
+	writeStreamFile(t, fileName, slipstream.NewEncoder(ID, 8, 4800, 2), second)
+
+	stream, err := slipstream.OpenStreamFile(fileName)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer stream.Close()
+
+	rangeStart := time.Unix(0, start).Add(999 * time.Millisecond)
+	rangeEnd := time.Unix(0, start).Add(1001 * time.Millisecond)
+	out, err := stream.ReadRange(rangeStart, rangeEnd)
+	assert.NoError(t, err)
+	assertSamplesEqual(t, append(expectedRange(first, rangeStart, rangeEnd), expectedRange(second, rangeStart, rangeEnd)...), out)
+}
+
+// countingReader counts the bytes read, and can fail reads at or after an offset
+type countingReader struct {
+	r      io.ReaderAt
+	read   int64
+	failAt int64
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	if c.failAt > 0 && off+int64(len(p)) > c.failAt {
+		return 0, errors.New("read failed")
+	}
+	n, err := c.r.ReadAt(p, off)
+	c.read += int64(n)
+	return n, err
+}
+
+func TestStreamFileIndex(t *testing.T) {
+	name := "e14400-14400q"
+	test := tests[name]
+	data := createCaptureData(name, 1_600_000_000_000_000_000)
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	writeStreamFile(t, fileName, slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage), data)
+	buf, err := os.ReadFile(fileName)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// only the header of each message is read when building the index
+	r := &countingReader{r: bytes.NewReader(buf)}
+	stream := slipstream.NewStreamFile(r, int64(len(buf)))
+	index, err := stream.Index()
+	assert.NoError(t, err)
+	assert.Len(t, index, len(data)/test.samplesPerMessage)
+	assert.Less(t, r.read, int64(len(buf)/10))
+
+	// a failed read does not leave a partial index, so the index is complete when it is built again
+	name = "b4000-80"
+	test = tests[name]
+	data = createCaptureData(name, 1_600_000_000_000_000_000)
+	fileName = filepath.Join(t.TempDir(), "messages.bin")
+	writeStreamFile(t, fileName, slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage), data)
+	buf, err = os.ReadFile(fileName)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	index, err = slipstream.NewStreamFile(bytes.NewReader(buf), int64(len(buf))).Index()
+	assert.NoError(t, err)
+	assert.Len(t, index, len(data)/test.samplesPerMessage)
+
+	r = &countingReader{r: bytes.NewReader(buf), failAt: index[len(index)/2].Offset}
+	stream = slipstream.NewStreamFile(r, int64(len(buf)))
+	_, err = stream.Index()
+	assert.Error(t, err)
+	r.failAt = 0
+	retry, err := stream.Index()
+	assert.NoError(t, err)
+	assert.Equal(t, index, retry)
+}
@@ -0,0 +1,324 @@
+package slipstream_test
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/slipstream"
+	"github.com/synaptecltd/slipstream/server"
+)
+
+// waitForSubscribers waits until a stream has the given number of subscribers
+func waitForSubscribers(t *testing.T, s *server.Server, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Stats(ID).Subscribers != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d subscribers", count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServerTCP(t *testing.T) {
+	test := tests["b4000-80"]
+	data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+
+	s := server.NewServer()
+	s.QueueSize = test.samples
+	defer s.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer l.Close()
+	go s.ServeTCP(l)
+
+	// subscribers can connect before the stream is published
+	var readers []*slipstream.StreamReader
+	for i := 0; i < 3; i++ {
+		conn, err := server.DialTCP(l.Addr().String(), ID)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer conn.Close()
+		readers = append(readers, slipstream.NewStreamReader(conn))
+	}
+	waitForSubscribers(t, s, 3)
+
+	enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	p := server.NewPublisher(s, enc)
+	half := len(data) / 2
+	for i := 0; i < half; i++ {
+		assert.NoError(t, p.Write(&data[i]))
+	}
+
+	// a late subscriber receives the configuration when it connects
+	conn, err := server.DialTCP(l.Addr().String(), ID)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+	waitForSubscribers(t, s, 4)
+	for i := half; i < len(data); i++ {
+		assert.NoError(t, p.Write(&data[i]))
+	}
+
+	readSamples := func(r *slipstream.StreamReader, start int) {
+		for i := start; i < len(data); i++ {
+			if !assert.True(t, r.Next()) {
+				t.FailNow()
+			}
+			assert.Equal(t, data[i].Int32s, r.Sample().Int32s)
+		}
+	}
+	for _, r := range readers {
+		readSamples(r, 0)
+	}
+	readSamples(slipstream.NewStreamReader(conn), half)
+
+	stats := s.Stats(ID)
+	assert.Equal(t, uint64(len(data)/test.samplesPerMessage), stats.Messages)
+	assert.Equal(t, uint64(0), stats.Dropped)
+
+	// subscribers are removed when they disconnect
+	conn.Close()
+	waitForSubscribers(t, s, 3)
+}
+
+// dialWebSocket performs a WebSocket handshake, returning a reader for the server frames
+func dialWebSocket(t *testing.T, url string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	var key [16]byte
+	rand.Read(key[:])
+	req, _ := http.NewRequest(http.MethodGet, url+"/streams/"+ID.String(), nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key[:]))
+	assert.NoError(t, req.Write(conn))
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Sec-WebSocket-Accept"))
+	return conn, r
+}
+
+// readWebSocketFrame reads an unmasked frame from the server
+func readWebSocketFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	var header [2]byte
+	_, err := io.ReadFull(r, header[:])
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	length := uint64(header[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	} else if length == 127 {
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	assert.NoError(t, err)
+	return header[0] & 0x0f, payload
+}
+
+func TestServerWebSocket(t *testing.T) {
+	test := tests["c4800-20"]
+	data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+
+	s := server.NewServer()
+	s.QueueSize = test.samples
+	defer s.Close()
+	httpServer := httptest.NewServer(s)
+	defer httpServer.Close()
+
+	// not a WebSocket request
+	resp, err := http.Get(httpServer.URL + "/streams/" + ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+	resp.Body.Close()
+
+	enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	p := server.NewPublisher(s, enc)
+	assert.NoError(t, p.Write(&data[0]))
+
+	conn, r := dialWebSocket(t, httpServer.URL)
+	defer conn.Close()
+	waitForSubscribers(t, s, 1)
+
+	// a masked ping from the client
+	conn.Write([]byte{0x89, 0x82, 1, 2, 3, 4, 'h' ^ 1, 'i' ^ 2})
+
+	for i := 1; i < len(data); i++ {
+		assert.NoError(t, p.Write(&data[i]))
+	}
+
+	// the configuration is sent first, so that the client can create a decoder
+	opcode, frame := readWebSocketFrame(t, r)
+	assert.Equal(t, byte(0x2), opcode)
+	dec, err := slipstream.NewDecoderFromConfig(frame)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	samples := 0
+	for samples < len(data) {
+		opcode, frame := readWebSocketFrame(t, r)
+		if opcode == 0xa {
+			assert.Equal(t, []byte("hi"), frame)
+			continue
+		}
+		n, err := dec.DecodeToBuffer(frame, len(frame))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		for i := 0; i < n; i++ {
+			assert.Equal(t, data[samples].Int32s, dec.Out[i].Int32s)
+			samples++
+		}
+	}
+
+	// the client closes the connection
+	conn.Write([]byte{0x88, 0x80, 1, 2, 3, 4})
+	waitForSubscribers(t, s, 0)
+
+	// clients which send control frames longer than 125 bytes, or fragmented control frames, are disconnected
+	longPing := append([]byte{0x89, 0x80 | 126, 0, 126, 1, 2, 3, 4}, make([]byte, 126)...)
+	fragmentedPing := []byte{0x09, 0x80, 1, 2, 3, 4}
+	for _, frame := range [][]byte{longPing, fragmentedPing} {
+		conn, _ := dialWebSocket(t, httpServer.URL)
+		waitForSubscribers(t, s, 1)
+		conn.Write(frame)
+		waitForSubscribers(t, s, 0)
+		conn.Close()
+	}
+}
+
+func TestServerDropPolicy(t *testing.T) {
+	test := tests["c4800-2"]
+	data := createInputData(createEmulator(test.samplingRate, 0), 20, test.countOfVariables, test.qualityChange)
+	messages := len(data) / test.samplesPerMessage
+
+	for _, policy := range []server.DropPolicy{server.DropNewest, server.DropOldest, server.Disconnect} {
+		s := server.NewServer()
+		s.QueueSize = 4
+		s.Policy = policy
+
+		enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		p := server.NewPublisher(s, enc)
+		assert.NoError(t, p.Write(&data[0]))
+		assert.NoError(t, p.Write(&data[1]))
+
+		// the subscriber does not read until all messages are published, which must not block the publisher
+		sub, err := s.Subscribe(ID)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		for i := 2; i < len(data); i++ {
+			assert.NoError(t, p.Write(&data[i]))
+		}
+
+		stats := s.Stats(ID)
+		assert.Equal(t, uint64(messages), stats.Messages)
+		if policy == server.Disconnect {
+			_, err := sub.Next()
+			assert.ErrorIs(t, err, server.ErrSubscriptionClosed)
+			assert.Equal(t, uint64(1), stats.Disconnected)
+			assert.Equal(t, 0, stats.Subscribers)
+			s.Close()
+			continue
+		}
+
+		frame, err := sub.Next()
+		assert.NoError(t, err)
+		assert.True(t, slipstream.IsConfig(frame))
+		dec, _ := slipstream.NewDecoderFromConfig(frame)
+
+		// the index of the first sample of each message received
+		var received []int
+		for i := 0; i < s.QueueSize; i++ {
+			frame, err := sub.Next()
+			assert.NoError(t, err)
+			_, err = dec.DecodeToBuffer(frame, len(frame))
+			assert.NoError(t, err)
+			for j := range data {
+				if assert.ObjectsAreEqual(data[j].Int32s, dec.Out[0].Int32s) {
+					received = append(received, j)
+					break
+				}
+			}
+		}
+
+		assert.Equal(t, uint64(messages-1-s.QueueSize), stats.Dropped)
+		if policy == server.DropNewest {
+			assert.Equal(t, []int{2, 4, 6, 8}, received)
+		} else {
+			assert.Equal(t, []int{12, 14, 16, 18}, received)
+		}
+		s.Close()
+	}
+}
+
+func TestServerWriteTimeout(t *testing.T) {
+	data := createInputData(createEmulator(4000, 0), 4000, 8, false)
+	enc := slipstream.NewEncoder(ID, 8, 4000, 4000)
+	enc.SetCompressor(slipstream.NoCompression)
+	msg, err := enc.EncodeMessage(nil, data)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	s := server.NewServer()
+	s.WriteTimeout = 100 * time.Millisecond
+	defer s.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer l.Close()
+	go s.ServeTCP(l)
+	httpServer := httptest.NewServer(s)
+	defer httpServer.Close()
+
+	// the subscribers never read, so the server's writes block once the socket buffers are full
+	conn, err := server.DialTCP(l.Addr().String(), ID)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+	wsConn, _ := dialWebSocket(t, httpServer.URL)
+	defer wsConn.Close()
+	waitForSubscribers(t, s, 2)
+
+	assert.NoError(t, s.Publish(enc.EncodeConfig()))
+	deadline := time.Now().Add(10 * time.Second)
+	for s.Stats(ID).Subscribers > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("slow subscribers were not disconnected")
+		}
+		assert.NoError(t, s.Publish(msg))
+		time.Sleep(time.Millisecond)
+	}
+}
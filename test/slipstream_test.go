@@ -1,6 +1,8 @@
 package slipstream_test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io/fs"
 	"math"
@@ -11,7 +13,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/gocarina/gocsv"
 	"github.com/google/uuid"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/rs/zerolog"
@@ -19,6 +20,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/synaptecltd/emulator"
 	"github.com/synaptecltd/slipstream"
+	"github.com/synaptecltd/slipstream/csv"
 )
 
 // DevelopmentBuild enables additional debugging features
@@ -213,6 +215,145 @@ func BenchmarkDecode(b1 *testing.B) {
 	}
 }
 
+// messageBenchmarks are used to measure the steady-state encoding and decoding of messages, with and without gzip
+var messageBenchmarks = []string{"b4000-80", "e14400-14400"}
+
+// BenchmarkEncodeMessage measures encoding of complete messages, which must not allocate in steady state
+func BenchmarkEncodeMessage(b1 *testing.B) {
+	for _, name := range messageBenchmarks {
+		test := tests[name]
+		data := createInputData(createEmulator(test.samplingRate, 0), test.samplesPerMessage, test.countOfVariables, test.qualityChange)
+		enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+
+		b1.Run(name, func(b *testing.B) {
+			// warm up the encoder, so that any lazily allocated state is excluded
+			encodeMessage(enc, data)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for d := range data {
+					enc.Encode(&data[d])
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkEncodeMessageTo measures encoding of complete messages into a buffer owned by the caller
+func BenchmarkEncodeMessageTo(b1 *testing.B) {
+	for _, name := range messageBenchmarks {
+		test := tests[name]
+		data := createInputData(createEmulator(test.samplingRate, 0), test.samplesPerMessage, test.countOfVariables, test.qualityChange)
+		enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		dst := make([]byte, 0, enc.MaxMessageSize())
+
+		b1.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for d := range data {
+					dst = enc.EncodeTo(dst[:0], &data[d])
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDecodeMessage measures decoding of complete messages, which must not allocate in steady state
+func BenchmarkDecodeMessage(b1 *testing.B) {
+	for _, name := range messageBenchmarks {
+		test := tests[name]
+		data := createInputData(createEmulator(test.samplingRate, 0), test.samplesPerMessage, test.countOfVariables, test.qualityChange)
+		enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		msg := encodeMessage(enc, data)
+
+		b1.Run(name, func(b *testing.B) {
+			dec.DecodeToBuffer(msg, len(msg))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				dec.DecodeToBuffer(msg, len(msg))
+			}
+		})
+	}
+}
+
+func TestEncodeTo(t *testing.T) {
+	for _, name := range []string{"a10-2q", "b4000-80", "e14400-14400s", "e14400-14400q"} {
+		t.Run(name, func(t *testing.T) {
+			test := tests[name]
+			data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+			if test.earlyEncodingStop {
+				data = data[:earlyEncodingStopSamples]
+			}
+
+			for _, compressor := range []slipstream.PayloadCompressor{slipstream.GzipCompression, slipstream.ZstdCompression} {
+				enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+				enc.SetChecksum(true)
+				enc.SetCompressor(compressor)
+				encTo := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+				encTo.SetChecksum(true)
+				encTo.SetCompressor(compressor)
+
+				// messages are appended after existing data, and must be identical to the output of Encode()
+				var expected []byte
+				dst := []byte("prefix")
+				for d := range data {
+					buf, length, err := enc.Encode(&data[d])
+					assert.NoError(t, err)
+					expected = append(expected, buf[:length]...)
+					dst = encTo.EncodeTo(dst, &data[d])
+				}
+				buf, length, _ := enc.EndEncode()
+				expected = append(expected, buf[:length]...)
+				dst = encTo.AppendMessage(dst)
+				assert.Equal(t, "prefix", string(dst[:6]))
+				assert.Equal(t, expected, dst[6:])
+
+				// there is nothing to append when no samples have been encoded
+				assert.Equal(t, dst, encTo.AppendMessage(dst))
+			}
+		})
+	}
+}
+
+func TestZeroAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not measured with the race detector")
+	}
+	for _, name := range messageBenchmarks {
+		t.Run(name, func(t *testing.T) {
+			test := tests[name]
+			data := createInputData(createEmulator(test.samplingRate, 0), test.samplesPerMessage, test.countOfVariables, test.qualityChange)
+			enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			msg := encodeMessage(enc, data)
+
+			allocs := testing.AllocsPerRun(20, func() {
+				for d := range data {
+					enc.Encode(&data[d])
+				}
+			})
+			assert.Zero(t, allocs, "encode")
+
+			dst := make([]byte, 0, enc.MaxMessageSize())
+			allocs = testing.AllocsPerRun(20, func() {
+				for d := range data {
+					dst = enc.EncodeTo(dst[:0], &data[d])
+				}
+			})
+			assert.Zero(t, allocs, "encode to")
+
+			allocs = testing.AllocsPerRun(20, func() {
+				dec.DecodeToBuffer(msg, len(msg))
+			})
+			assert.Zero(t, allocs, "decode")
+		})
+	}
+}
+
 func createInputData(ied *emulator.Emulator, samples int, countOfVariables int, qualityChange bool) []slipstream.DatasetWithQuality {
 	var data []slipstream.DatasetWithQuality = make([]slipstream.DatasetWithQuality, samples)
 	for i := range data {
@@ -260,75 +401,22 @@ func createInputData(ied *emulator.Emulator, samples int, countOfVariables int,
 	return data
 }
 
-func createInputDataCSV(filename string) ([]slipstream.DatasetWithQuality, int) {
-	type sampleCSV struct {
-		Time float64 `csv:"Time (s)"` // .csv column headers
-		Va   float64 `csv:"Va"`
-		Vb   float64 `csv:"Vb"`
-		Vc   float64 `csv:"Vc"`
-		Ia   float64 `csv:"Ia"`
-		Ib   float64 `csv:"Ib"`
-		Ic   float64 `csv:"Ic"`
-		In   float64 `csv:"In"`
-	}
-
-	in, err := os.Open(filename)
+// createInputDataCSV reads the currents and voltages from an EPRI PQ data file, truncated to the given number of
+// samples (or all samples if zero), and adds the sum of the voltages as the eighth variable
+func createInputDataCSV(tb testing.TB, filename string, samples int) ([]slipstream.DatasetWithQuality, int) {
+	data, samplingRate, err := csv.ReadFile(filename, csvFormat)
 	if err != nil {
-		panic(err)
-	}
-	defer in.Close()
-
-	samples := []*sampleCSV{}
-
-	if err := gocsv.UnmarshalFile(in, &samples); err != nil {
-		panic(err)
+		tb.Fatal(err)
 	}
-
-	numSamples := len(samples)
-
-	if numSamples < 2 {
-		panic("not enough data")
+	if samples > 0 && samples < len(data) {
+		data = data[:samples]
 	}
 
-	// infer sampling rate from first two samples, assuming integer value in Hz
-	samplingRate := int(1.0 / (samples[1].Time - samples[0].Time))
-	countOfVariables := 8
-
-	var data []slipstream.DatasetWithQuality = make([]slipstream.DatasetWithQuality, numSamples)
 	for i := range data {
-		data[i].Int32s = make([]int32, countOfVariables)
-		data[i].Q = make([]uint32, countOfVariables)
-	}
-
-	// populated data from CSV file
-	// the timestamp is a simple integer counter, starting from 0
-	for i, sample := range samples {
-		// compute emulated waveform data
-
-		// calculate timestamp
-		data[i].T = uint64(i)
-
-		// set waveform data
-		data[i].Int32s[0] = int32(sample.Ia * 1000.0)
-		data[i].Int32s[1] = int32(sample.Ib * 1000.0)
-		data[i].Int32s[2] = int32(sample.Ic * 1000.0)
-		data[i].Int32s[3] = int32(sample.In * 1000.0)
-		data[i].Int32s[4] = int32(sample.Va * 100.0)
-		data[i].Int32s[5] = int32(sample.Vb * 100.0)
-		data[i].Int32s[6] = int32(sample.Vc * 100.0)
-		data[i].Int32s[7] = int32((sample.Va + sample.Vb + sample.Vc) * 100.0)
-
-		// set quality data
-		data[i].Q[0] = 0
-		data[i].Q[1] = 0
-		data[i].Q[2] = 0
-		data[i].Q[3] = 0
-		data[i].Q[4] = 0
-		data[i].Q[5] = 0
-		data[i].Q[6] = 0
-		data[i].Q[7] = 0
+		d := &data[i]
+		d.Int32s = append(d.Int32s, d.Int32s[4]+d.Int32s[5]+d.Int32s[6])
+		d.Q = append(d.Q, 0)
 	}
-
 	return data, samplingRate
 }
 
@@ -430,7 +518,7 @@ func encodeAndDecode(t *testing.T, data *[]slipstream.DatasetWithQuality, enc *s
 			// generate average stats
 			encodeStats.messages++
 			encodeStats.totalBytes += length
-			encodeStats.totalHeaderBytes += 24
+			encodeStats.totalHeaderBytes += 26
 
 			samplesDecoded, errDecode := dec.DecodeToBuffer(buf, length)
 			if errDecode != nil {
@@ -500,7 +588,7 @@ func TestEncodeDecodeCSVData(t *testing.T) {
 	tab.AppendHeader(table.Row{"samples", "sampling\nrate", "samples\nper message", "messages", "size\n(bytes)", "size\n(%)", "bits per\nsample"})
 
 	for _, csvFile := range csvFiles {
-		data, samplingRate := createInputDataCSV(csvFile)
+		data, samplingRate := createInputDataCSV(t, csvFile, 0)
 		countOfVariables := 8
 		samplesPerMessage := samplingRate
 		earlyEncodingStop := false
@@ -637,3 +725,667 @@ func TestWrongID(t *testing.T) {
 		}
 	})
 }
+
+func TestConfig(t *testing.T) {
+	for _, name := range []string{"a10-2q", "b4000-80", "b4000-4000s2", "e14400-14400q"} {
+		t.Run(name, func(t *testing.T) {
+			test := tests[name]
+
+			var ied *emulator.Emulator = createEmulator(test.samplingRate, 0)
+			var data []slipstream.DatasetWithQuality
+			if test.countOfVariables == 16 {
+				var ied2 *emulator.Emulator = createEmulator(test.samplingRate, 0)
+				data = createInputDataDualIED(ied, ied2, test.samples, test.countOfVariables, test.qualityChange)
+			} else {
+				data = createInputData(ied, test.samples, test.countOfVariables, test.qualityChange)
+			}
+
+			enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			enc.SetXOR(true)
+			if test.useSpatialRefs {
+				enc.SetSpatialRefs(test.countOfVariables, test.countOfVariables/8, test.countOfVariables/8, true)
+			}
+
+			// create the decoder using only the configuration frame
+			dec, err := slipstream.NewDecoderFromConfig(enc.EncodeConfig())
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			assert.Equal(t, ID, dec.ID)
+			assert.Equal(t, test.countOfVariables, dec.Int32Count)
+			assert.Equal(t, test.samplingRate, dec.SamplingRate)
+			assert.Equal(t, test.samplesPerMessage, dec.SamplesPerMessage)
+
+			_, err = encodeAndDecode(t, &data, enc, dec, test.countOfVariables, test.samplesPerMessage, test.earlyEncodingStop)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	enc := slipstream.NewEncoder(ID, 8, 4000, 80)
+	cfg := enc.EncodeConfig()
+
+	assert.True(t, slipstream.IsConfig(cfg))
+
+	// truncated frames must be rejected
+	for i := 0; i < len(cfg); i++ {
+		_, err := slipstream.NewDecoderFromConfig(cfg[:i])
+		assert.ErrorIs(t, err, slipstream.ErrInvalidConfig)
+	}
+
+	// data messages are not configuration frames
+	data := createInputData(createEmulator(4000, 0), 80, 8, false)
+	var buf []byte
+	for d := range data {
+		buf, _, _ = enc.Encode(&data[d])
+	}
+	assert.False(t, slipstream.IsConfig(buf))
+	_, err := slipstream.DecodeConfig(buf)
+	assert.ErrorIs(t, err, slipstream.ErrInvalidConfig)
+
+	// a corrupt configuration frame is not a valid configuration
+	assert.False(t, slipstream.IsConfig(cfg[:len(cfg)-1]))
+
+	// counts which would require large allocations are rejected before allocating
+	frame := func(int32Count uint64, samplesPerMessage uint64, refCount uint64, refs int) []byte {
+		frame := append([]byte("SLPC"), slipstream.ProtocolVersion)
+		frame = append(frame, ID[:]...)
+		var field [binary.MaxVarintLen64]byte
+		// the fields are followed by the options byte and the number of spatial references
+		for _, v := range []uint64{int32Count, 0, 0, 0, 0, 4000, samplesPerMessage, 3, 16, 4096, 0, refCount} {
+			frame = append(frame, field[:binary.PutUvarint(field[:], v)]...)
+		}
+		frame = append(frame, make([]byte, refs)...)
+		return append(frame, 0)
+	}
+	assert.True(t, slipstream.IsConfig(frame(8, 80, 8, 8)))
+	for _, invalid := range [][]byte{
+		frame(0xfffffff0, 80, 0xfffffff0, 0),
+		frame(0xfffffff0, 80, 0xfffffff0, 100),
+		frame(8, 0x7fffffff, 8, 8),
+		frame(8, slipstream.MaxSamplesPerMessage+1, 8, 8),
+		frame(slipstream.MaxVariableCount+1, 1, slipstream.MaxVariableCount+1, slipstream.MaxVariableCount+1),
+		frame(1000, slipstream.MaxSamplesPerMessage, 1000, 1000),
+	} {
+		assert.False(t, slipstream.IsConfig(invalid))
+		_, err := slipstream.NewDecoderFromConfig(invalid)
+		assert.ErrorIs(t, err, slipstream.ErrInvalidConfig)
+	}
+	_, err = slipstream.NewEncoderWithConfig(slipstream.Config{ID: ID, Int32Count: 8, SamplingRate: 4000, SamplesPerMessage: slipstream.MaxSamplesPerMessage + 1})
+	assert.ErrorIs(t, err, slipstream.ErrInvalidConfig)
+
+	// a stream UUID which starts with the configuration magic bytes, followed by the protocol version
+	id := uuid.UUID{'S', 'L', 'P', 'C', slipstream.ProtocolVersion, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	enc = slipstream.NewEncoder(id, 8, 4000, 80)
+	var stream bytes.Buffer
+	w := slipstream.NewStreamWriter(&stream, enc)
+	for d := range data {
+		assert.NoError(t, w.Write(&data[d]))
+	}
+	assert.NoError(t, w.Close())
+	assert.False(t, slipstream.IsConfig(encodeMessage(slipstream.NewEncoder(id, 8, 4000, 80), data)))
+
+	r := slipstream.NewStreamReader(&stream)
+	samples := 0
+	for r.Next() {
+		assert.Equal(t, data[samples].Int32s, r.Sample().Int32s)
+		samples++
+	}
+	assert.NoError(t, r.Err())
+	assert.Equal(t, len(data), samples)
+}
+
+func TestMessageHeader(t *testing.T) {
+	test := tests["b4000-80"]
+	data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+
+	t.Run("decoder adapts to encoding options", func(t *testing.T) {
+		enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		enc.SetXOR(true)
+		dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+
+		_, err := encodeAndDecode(t, &data, enc, dec, test.countOfVariables, test.samplesPerMessage, test.earlyEncodingStop)
+		assert.NoError(t, err)
+	})
+
+	enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	var msg []byte
+	for d := range data {
+		buf, length, _ := enc.Encode(&data[d])
+		if length > 0 {
+			msg = make([]byte, length)
+			copy(msg, buf)
+			break
+		}
+	}
+	assert.Equal(t, byte(slipstream.ProtocolVersion), msg[16])
+	assert.Equal(t, byte(slipstream.FlagSimple8b), msg[17])
+
+	t.Run("unsupported version", func(t *testing.T) {
+		dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		bad := append([]byte(nil), msg...)
+		bad[16] = slipstream.ProtocolVersion + 1
+
+		_, err := dec.DecodeToBuffer(bad, len(bad))
+		var versionErr *slipstream.UnsupportedVersionError
+		if assert.ErrorAs(t, err, &versionErr) {
+			assert.Equal(t, slipstream.ProtocolVersion+1, versionErr.Version)
+		}
+	})
+
+	t.Run("unsupported flags", func(t *testing.T) {
+		dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		bad := append([]byte(nil), msg...)
+		bad[17] |= 0x80
+
+		_, err := dec.DecodeToBuffer(bad, len(bad))
+		assert.ErrorIs(t, err, slipstream.ErrUnsupportedFlags)
+	})
+
+	t.Run("missing spatial refs", func(t *testing.T) {
+		dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		bad := append([]byte(nil), msg...)
+		bad[17] |= slipstream.FlagSpatialRefs
+
+		_, err := dec.DecodeToBuffer(bad, len(bad))
+		assert.ErrorIs(t, err, slipstream.ErrMissingSpatialRefs)
+	})
+}
+
+func TestCorruptMessages(t *testing.T) {
+	for _, name := range []string{"a10-2q", "b4000-80", "b4000-4000s2", "e14400-14400q"} {
+		t.Run(name, func(t *testing.T) {
+			test := tests[name]
+
+			var ied *emulator.Emulator = createEmulator(test.samplingRate, 0)
+			var data []slipstream.DatasetWithQuality
+			if test.countOfVariables == 16 {
+				var ied2 *emulator.Emulator = createEmulator(test.samplingRate, 0)
+				data = createInputDataDualIED(ied, ied2, test.samples, test.countOfVariables, test.qualityChange)
+			} else {
+				data = createInputData(ied, test.samples, test.countOfVariables, test.qualityChange)
+			}
+
+			enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			if test.useSpatialRefs {
+				enc.SetSpatialRefs(test.countOfVariables, test.countOfVariables/8, test.countOfVariables/8, true)
+				dec.SetSpatialRefs(test.countOfVariables, test.countOfVariables/8, test.countOfVariables/8, true)
+			}
+
+			var msg []byte
+			for d := range data {
+				buf, length, _ := enc.Encode(&data[d])
+				if length > 0 {
+					msg = append([]byte(nil), buf[:length]...)
+					break
+				}
+			}
+
+			// check a selection of positions within large messages, to limit the test duration
+			step := len(msg)/256 + 1
+
+			// every truncated message must return an error rather than panic
+			for i := 0; i < len(msg); i += step {
+				_, err := dec.DecodeToBuffer(msg, i)
+				assert.Error(t, err)
+			}
+			_, err := dec.DecodeToBuffer(msg, len(msg)+1)
+			assert.ErrorIs(t, err, slipstream.ErrTruncated)
+
+			// additional data after the message is rejected
+			extended := append(append([]byte(nil), msg...), 0)
+			_, err = dec.DecodeToBuffer(extended, len(extended))
+			assert.Error(t, err)
+
+			// sample count in the header must not exceed the decoder capacity
+			tooMany := append([]byte(nil), msg[:26]...)
+			tooMany = append(tooMany, 0xfe, 0xff, 0xff, 0xff, 0x0f)
+			_, err = dec.DecodeToBuffer(tooMany, len(tooMany))
+			assert.ErrorIs(t, err, slipstream.ErrSampleCountOverflow)
+
+			// bit flips must never cause a panic
+			for i := 26; i < len(msg); i += step {
+				corrupt := append([]byte(nil), msg...)
+				corrupt[i] ^= 0xa5
+				dec.DecodeToBuffer(corrupt, len(corrupt))
+			}
+
+			// an error in one message must not affect the next message
+			samplesDecoded, err := dec.DecodeToBuffer(msg, len(msg))
+			if assert.NoError(t, err) {
+				for i := range dec.Out[:samplesDecoded] {
+					assert.Equal(t, data[i].Int32s, dec.Out[i].Int32s)
+					assert.Equal(t, data[i].Q, dec.Out[i].Q)
+				}
+			}
+		})
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	const samplingRate = 14400
+	const samplesPerMessage = 14400
+	start := uint64(time.Date(2022, 4, 12, 10, 30, 0, 123456789, time.UTC).UnixNano())
+
+	data := createInputData(createEmulator(samplingRate, 0), samplesPerMessage, 8, false)
+	data[0].T = start
+
+	enc := slipstream.NewEncoder(ID, 8, samplingRate, samplesPerMessage)
+	var buf []byte
+	var length int
+	for d := range data {
+		buf, length, _ = enc.Encode(&data[d])
+	}
+
+	t.Run("absolute", func(t *testing.T) {
+		dec := slipstream.NewDecoder(ID, 8, samplingRate, samplesPerMessage)
+		samples, err := dec.DecodeToBuffer(buf, length)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		assert.Equal(t, start, dec.Out[0].T)
+		assert.Equal(t, start+69444, dec.Out[1].T)  // 69444.44 ns
+		assert.Equal(t, start+138889, dec.Out[2].T) // 138888.89 ns
+		assert.Equal(t, start+500000000, dec.Out[7200].T)
+		assert.Equal(t, start+999930556, dec.Out[samples-1].T) // 999930555.56 ns
+
+		// the next message would start exactly one second later, without drift
+		assert.Equal(t, start+uint64(time.Second), slipstream.SampleTimestamp(start, samples, samplingRate))
+	})
+
+	t.Run("relative", func(t *testing.T) {
+		dec := slipstream.NewDecoder(ID, 8, samplingRate, samplesPerMessage)
+		dec.SetRelativeTimestamps(true)
+		samples, err := dec.DecodeToBuffer(buf, length)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		assert.Equal(t, start, dec.Out[0].T)
+		for i := 1; i < samples; i++ {
+			assert.Equal(t, uint64(i), dec.Out[i].T)
+		}
+	})
+}
+
+func TestChecksum(t *testing.T) {
+	for _, name := range []string{"a10-2q", "b4000-80", "e14400-14400q"} {
+		t.Run(name, func(t *testing.T) {
+			test := tests[name]
+			data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+
+			enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			enc.SetChecksum(true)
+			dec, err := slipstream.NewDecoderFromConfig(enc.EncodeConfig())
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+
+			_, err = encodeAndDecode(t, &data, enc, dec, test.countOfVariables, test.samplesPerMessage, test.earlyEncodingStop)
+			assert.NoError(t, err)
+
+			var msg []byte
+			for d := range data {
+				buf, length, _ := enc.Encode(&data[d])
+				if length > 0 {
+					msg = append([]byte(nil), buf[:length]...)
+					break
+				}
+			}
+			assert.Equal(t, byte(slipstream.FlagChecksum), msg[17]&slipstream.FlagChecksum)
+
+			// any corrupted byte after the header flags must be detected
+			step := len(msg)/256 + 1
+			for i := 18; i < len(msg); i += step {
+				corrupt := append([]byte(nil), msg...)
+				corrupt[i] ^= 0x01
+				_, err := dec.DecodeToBuffer(corrupt, len(corrupt))
+				assert.ErrorIs(t, err, slipstream.ErrChecksum)
+			}
+		})
+	}
+}
+
+// createMixedData creates smooth waveforms with some special values, and quality changes for every type of variable
+func createMixedData(samples int, c slipstream.Config) []slipstream.DatasetWithQuality {
+	special := []float64{math.NaN(), math.Inf(1), math.Inf(-1), math.Copysign(0, -1), math.MaxFloat64, math.SmallestNonzeroFloat64}
+	extremes := []int64{math.MinInt64, math.MaxInt64, 0, -1, 1 << 62}
+
+	data := make([]slipstream.DatasetWithQuality, samples)
+	for i := range data {
+		data[i].T = uint64(i)
+		data[i].Int32s = make([]int32, c.Int32Count)
+		data[i].Int16s = make([]int16, c.Int16Count)
+		data[i].Int64s = make([]int64, c.Int64Count)
+		data[i].Float32s = make([]float32, c.Float32Count)
+		data[i].Float64s = make([]float64, c.Float64Count)
+		data[i].Q = make([]uint32, c.Int32Count+c.Int16Count+c.Int64Count+c.Float32Count+c.Float64Count)
+
+		angle := 2 * math.Pi * 50 * float64(i) / 4000
+		for j := range data[i].Int32s {
+			data[i].Int32s[j] = int32(1000 * math.Sin(angle+float64(j)))
+		}
+		for j := range data[i].Int16s {
+			data[i].Int16s[j] = int16(math.MaxInt16 * math.Sin(angle+float64(j)))
+		}
+		for j := range data[i].Int64s {
+			// an energy accumulator which exceeds the int32 range
+			data[i].Int64s[j] = int64(1e12) + int64(i)*int64(1000+j) + int64(100*math.Sin(angle))
+		}
+		for j := range data[i].Float32s {
+			data[i].Float32s[j] = float32(230.0 * math.Sin(angle+float64(j)))
+		}
+		for j := range data[i].Float64s {
+			data[i].Float64s[j] = 230.0*math.Sin(angle+float64(j)) + math.Sin(float64(i*i+j))
+		}
+
+		// include special values and periods of constant values
+		if i%50 == 7 {
+			if c.Int16Count > 0 {
+				data[i].Int16s[0] = math.MinInt16
+			}
+			if c.Int64Count > 0 {
+				data[i].Int64s[0] = extremes[(i/50)%len(extremes)]
+			}
+			if c.Float32Count > 0 {
+				data[i].Float32s[0] = float32(special[(i/50)%len(special)])
+			}
+			if c.Float64Count > 0 {
+				data[i].Float64s[0] = special[(i/50)%len(special)]
+			}
+		}
+		if i%100 < 20 && c.Float64Count > 0 {
+			data[i].Float64s[c.Float64Count-1] = 1.5
+		}
+
+		for j := range data[i].Q {
+			if i%(j+10) == 0 {
+				data[i].Q[j] = uint32(i)
+			}
+		}
+	}
+	return data
+}
+
+// encodeAndDecodeMixed checks that every type of variable is decoded exactly
+func encodeAndDecodeMixed(t *testing.T, c slipstream.Config) {
+	enc, err := slipstream.NewEncoderWithConfig(c)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	dec, err := slipstream.NewDecoderFromConfig(enc.EncodeConfig())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	encodeAndDecodeMixedData(t, enc, dec, createMixedData(2*c.SamplesPerMessage+3, c))
+}
+
+// encodeAndDecodeMixedData encodes the samples, and checks that the decoded samples match, including the bits of
+// float values
+func encodeAndDecodeMixedData(t *testing.T, enc *slipstream.Encoder, dec *slipstream.Decoder, data []slipstream.DatasetWithQuality) {
+	decodedSamples := 0
+	for i := range data {
+		buf, length, err := enc.Encode(&data[i])
+		assert.NoError(t, err)
+		if length == 0 && i == len(data)-1 {
+			buf, length, _ = enc.EndEncode()
+		}
+		if length == 0 {
+			continue
+		}
+
+		samples, err := dec.DecodeToBuffer(buf, length)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		for j := 0; j < samples; j++ {
+			in := data[decodedSamples+j]
+			out := dec.Out[j]
+			assert.Equal(t, in.Int32s, out.Int32s)
+			assert.Equal(t, in.Int16s, out.Int16s)
+			assert.Equal(t, in.Int64s, out.Int64s)
+			assert.Equal(t, in.Q, out.Q)
+			for k := range in.Float32s {
+				assert.Equal(t, math.Float32bits(in.Float32s[k]), math.Float32bits(out.Float32s[k]))
+			}
+			for k := range in.Float64s {
+				assert.Equal(t, math.Float64bits(in.Float64s[k]), math.Float64bits(out.Float64s[k]))
+			}
+		}
+		decodedSamples += samples
+	}
+	assert.Equal(t, len(data), decodedSamples)
+}
+
+func TestFloats(t *testing.T) {
+	for _, samplesPerMessage := range []int{8, 80, 5000} {
+		for _, useXOR := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%d-xor=%v", samplesPerMessage, useXOR), func(t *testing.T) {
+				encodeAndDecodeMixed(t, slipstream.Config{
+					ID:                ID,
+					Int32Count:        2,
+					Float32Count:      3,
+					Float64Count:      3,
+					SamplingRate:      4000,
+					SamplesPerMessage: samplesPerMessage,
+					UseXOR:            useXOR,
+				})
+			})
+		}
+	}
+}
+
+func TestIntegerWidths(t *testing.T) {
+	for _, samplesPerMessage := range []int{8, 80, 5000} {
+		for _, useXOR := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%d-xor=%v", samplesPerMessage, useXOR), func(t *testing.T) {
+				encodeAndDecodeMixed(t, slipstream.Config{
+					ID:                ID,
+					Int32Count:        3,
+					Int16Count:        4,
+					Int64Count:        2,
+					Float64Count:      1,
+					SamplingRate:      4000,
+					SamplesPerMessage: samplesPerMessage,
+					UseXOR:            useXOR,
+					SpatialRefs:       []int{-1, 0, 0},
+				})
+			})
+		}
+	}
+}
+
+func TestFloatsOnly(t *testing.T) {
+	c := slipstream.Config{
+		ID:                ID,
+		Float64Count:      1,
+		SamplingRate:      4000,
+		SamplesPerMessage: 80,
+	}
+	enc, err := slipstream.NewEncoderWithConfig(c)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	dec, err := slipstream.NewDecoderWithConfig(c)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// a constant value needs only one bit per sample after the first sample
+	var buf []byte
+	var length int
+	for i := 0; i < c.SamplesPerMessage; i++ {
+		buf, length, _ = enc.Encode(&slipstream.DatasetWithQuality{Float64s: []float64{math.Pi}, Q: []uint32{0}})
+	}
+	assert.Less(t, length, slipstream.MaxHeaderSize+8+c.SamplesPerMessage/8+4)
+
+	samples, err := dec.DecodeToBuffer(buf, length)
+	assert.NoError(t, err)
+	assert.Equal(t, c.SamplesPerMessage, samples)
+	for i := 0; i < samples; i++ {
+		assert.Equal(t, math.Pi, dec.Out[i].Float64s[0])
+	}
+
+	_, err = slipstream.NewEncoderWithConfig(slipstream.Config{ID: ID, SamplingRate: 4000, SamplesPerMessage: 80})
+	assert.ErrorIs(t, err, slipstream.ErrInvalidConfig)
+}
+
+func TestVariableEncodings(t *testing.T) {
+	encodings := []slipstream.VariableEncoding{
+		{DeltaEncodingLayers: 3},
+		{DeltaEncodingLayers: 1, UseXOR: true},
+		{Raw: true, ElideConstant: true},
+		{DeltaEncodingLayers: slipstream.MaxDeltaEncodingLayers},
+		{DeltaEncodingLayers: 2, ElideConstant: true},
+		{Raw: true},
+	}
+
+	for _, samplesPerMessage := range []int{8, 80, 5000} {
+		t.Run(fmt.Sprint(samplesPerMessage), func(t *testing.T) {
+			c := slipstream.Config{
+				ID:                ID,
+				Int32Count:        3,
+				Int16Count:        1,
+				Int64Count:        2,
+				Float32Count:      1,
+				SamplingRate:      4000,
+				SamplesPerMessage: samplesPerMessage,
+				VariableEncodings: encodings,
+			}
+			encodeAndDecodeMixed(t, c)
+
+			enc, err := slipstream.NewEncoderWithConfig(c)
+			assert.NoError(t, err)
+			cfg, err := slipstream.DecodeConfig(enc.EncodeConfig())
+			assert.NoError(t, err)
+			assert.Equal(t, encodings, cfg.VariableEncodings)
+		})
+	}
+
+	t.Run("constant", func(t *testing.T) {
+		c := slipstream.Config{
+			ID:                ID,
+			Int32Count:        2,
+			Int64Count:        1,
+			SamplingRate:      4000,
+			SamplesPerMessage: 80,
+		}
+		enc, err := slipstream.NewEncoderWithConfig(c)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		dec, err := slipstream.NewDecoderWithConfig(c)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		// a status variable and an accumulator which do not change during the message
+		encode := func() ([]byte, int) {
+			var buf []byte
+			var length int
+			for i := 0; i < c.SamplesPerMessage; i++ {
+				buf, length, _ = enc.Encode(&slipstream.DatasetWithQuality{
+					Int32s: []int32{int32(i * i), 1},
+					Int64s: []int64{math.MaxInt64},
+					Q:      []uint32{0, 0, 0},
+				})
+			}
+			return append([]byte(nil), buf[:length]...), length
+		}
+
+		_, defaultLength := encode()
+
+		assert.ErrorIs(t, enc.SetVariableEncoding(3, slipstream.VariableEncoding{Raw: true}), slipstream.ErrInvalidConfig)
+		assert.ErrorIs(t, enc.SetVariableEncoding(0, slipstream.VariableEncoding{DeltaEncodingLayers: 8}), slipstream.ErrInvalidConfig)
+		assert.NoError(t, enc.SetVariableEncoding(1, slipstream.VariableEncoding{Raw: true, ElideConstant: true}))
+		assert.NoError(t, enc.SetVariableEncoding(2, slipstream.VariableEncoding{Raw: true, ElideConstant: true}))
+		buf, length := encode()
+		assert.Less(t, length, defaultLength)
+		assert.Equal(t, byte(slipstream.FlagColumnDescriptors), buf[17]&slipstream.FlagColumnDescriptors)
+
+		samples, err := dec.DecodeToBuffer(buf, length)
+		assert.NoError(t, err)
+		assert.Equal(t, c.SamplesPerMessage, samples)
+		for i := 0; i < samples; i++ {
+			assert.Equal(t, []int32{int32(i * i), 1}, dec.Out[i].Int32s)
+			assert.Equal(t, []int64{math.MaxInt64}, dec.Out[i].Int64s)
+		}
+		assert.Equal(t, slipstream.VariableEncoding{DeltaEncodingLayers: slipstream.DefaultDeltaEncodingLayers}, dec.VariableEncoding(0))
+		assert.Equal(t, slipstream.VariableEncoding{Raw: true}, dec.VariableEncoding(1))
+	})
+}
+
+func TestAdaptiveEncoding(t *testing.T) {
+	for _, samplesPerMessage := range []int{8, 80, 5000} {
+		t.Run(fmt.Sprint(samplesPerMessage), func(t *testing.T) {
+			encodeAndDecodeMixed(t, slipstream.Config{
+				ID:                ID,
+				Int32Count:        3,
+				Int16Count:        2,
+				Int64Count:        2,
+				Float64Count:      1,
+				SamplingRate:      4000,
+				SamplesPerMessage: samplesPerMessage,
+				AdaptiveEncoding:  true,
+				SpatialRefs:       []int{-1, 0, 0},
+			})
+		})
+	}
+
+	// the adaptive encoder must never be worse than the default encoding, apart from the column descriptors
+	for _, name := range []string{"a10-1", "b4000-80", "b4000-4000s2", "e14400-14400q"} {
+		t.Run(name, func(t *testing.T) {
+			test := tests[name]
+			data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+
+			enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			dec := slipstream.NewDecoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+			fixedStats, err := encodeAndDecode(t, &data, enc, dec, test.countOfVariables, test.samplesPerMessage, test.earlyEncodingStop)
+			assert.NoError(t, err)
+
+			enc.SetAdaptiveEncoding(true)
+			adaptiveStats, err := encodeAndDecode(t, &data, enc, dec, test.countOfVariables, test.samplesPerMessage, test.earlyEncodingStop)
+			assert.NoError(t, err)
+			assert.LessOrEqual(t, adaptiveStats.totalBytes, fixedStats.totalBytes+adaptiveStats.messages*test.countOfVariables)
+		})
+	}
+
+	t.Run("noise", func(t *testing.T) {
+		c := slipstream.Config{
+			ID:                ID,
+			Int32Count:        3,
+			SamplingRate:      4000,
+			SamplesPerMessage: 80,
+			AdaptiveEncoding:  true,
+		}
+		enc, err := slipstream.NewEncoderWithConfig(c)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		dec, err := slipstream.NewDecoderFromConfig(enc.EncodeConfig())
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		// a smooth waveform, uncorrelated noise and a constant value
+		var buf []byte
+		var length int
+		noise := uint32(1)
+		for i := 0; i < c.SamplesPerMessage; i++ {
+			noise = noise*1664525 + 1013904223
+			buf, length, _ = enc.Encode(&slipstream.DatasetWithQuality{
+				Int32s: []int32{int32(100000 * math.Sin(2*math.Pi*50*float64(i)/4000)), int32(noise >> 20), 7},
+				Q:      []uint32{0, 0, 0},
+			})
+		}
+
+		_, err = dec.DecodeToBuffer(buf, length)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, dec.VariableEncoding(0).DeltaEncodingLayers, 2)
+		assert.True(t, dec.VariableEncoding(1).Raw)
+		assert.Equal(t, int32(7), dec.Out[c.SamplesPerMessage-1].Int32s[2])
+	})
+}
@@ -0,0 +1,85 @@
+package slipstream_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/slipstream"
+)
+
+func TestStream(t *testing.T) {
+	for _, name := range []string{"a10-1", "b4000-80", "c4800-2", "e14400-14400q"} {
+		t.Run(name, func(t *testing.T) {
+			test := tests[name]
+			data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+
+			// write to a pipe, so that the reader and writer run concurrently
+			pr, pw := io.Pipe()
+			go func() {
+				enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+				w := slipstream.NewStreamWriter(pw, enc)
+				for i := range data {
+					if err := w.Write(&data[i]); err != nil {
+						pw.CloseWithError(err)
+						return
+					}
+				}
+				pw.CloseWithError(w.Close())
+			}()
+
+			r := slipstream.NewStreamReader(pr)
+			samples := 0
+			for r.Next() {
+				sample := r.Sample()
+				assert.Equal(t, data[samples].Int32s, sample.Int32s)
+				assert.Equal(t, data[samples].Q, sample.Q)
+				samples++
+			}
+			assert.NoError(t, r.Err())
+			assert.Equal(t, len(data), samples)
+			assert.Equal(t, test.samplingRate, r.Decoder().SamplingRate)
+		})
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	test := tests["b4000-80"]
+	data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+
+	var stream bytes.Buffer
+	enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	w := slipstream.NewStreamWriter(&stream, enc)
+	for i := range data {
+		assert.NoError(t, w.Write(&data[i]))
+	}
+	assert.NoError(t, w.Close())
+	full := stream.Bytes()
+
+	readAll := func(buf []byte) (int, error) {
+		r := slipstream.NewStreamReader(bytes.NewReader(buf))
+		samples := 0
+		for r.Next() {
+			samples++
+		}
+		return samples, r.Err()
+	}
+
+	samples, err := readAll(full)
+	assert.NoError(t, err)
+	assert.Equal(t, len(data), samples)
+
+	// a stream which ends part way through a frame
+	_, err = readAll(full[:len(full)-1])
+	assert.ErrorIs(t, err, slipstream.ErrTruncated)
+
+	// a stream without the configuration frame
+	configLength := int(full[0]) + 1
+	_, err = readAll(full[configLength:])
+	assert.ErrorIs(t, err, slipstream.ErrMissingConfig)
+
+	// a frame length which exceeds the maximum message size
+	_, err = readAll(append(append([]byte(nil), full[:configLength]...), 0xff, 0xff, 0xff, 0xff, 0x0f))
+	assert.ErrorIs(t, err, slipstream.ErrFrameTooLarge)
+}
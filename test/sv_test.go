@@ -0,0 +1,149 @@
+package slipstream_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/slipstream"
+	"github.com/synaptecltd/slipstream/sv"
+)
+
+// svFrame92LE is a hand-built IEC 61850-9-2 LE frame, with a VLAN tag and a single ASDU of four currents and four
+// voltages. It follows the 9-2 LE frame layout, but was not captured from a merging unit.
+const svFrame92LE = "010ccd0400010050c21234568100800088ba4000006800000000605e800101a259305780064d553031303182020f9f830400000001850102" +
+	"8740000004d200000000ffffe9d2000000000000115c0000000000000000000020000060e4b000000000ffcf8da800000000ffcf8da80000" +
+	"00000000000000002000"
+
+// svFrame61869 is a hand-built IEC 61869-9 frame, with two ASDUs which include the dataset name, reference time,
+// sampling rate and sample mode. It was not captured from a merging unit.
+const svFrame61869 = "010ccd0400010050c212345688ba400001150000000060820109800102a2820102307f80064d553032303181144d5530324c442f4c4c4e" +
+	"30245068734d656173318202000083040000271184086553f1008000000a850102860212c0874000000000000000000000000100000000" +
+	"00000002000000000000000300000000000000040000000000000005000000000000000600000000000000070000000088020000307f80" +
+	"064d553032303181144d5530324c442f4c4c4e30245068734d656173318202000183040000271184086553f1008000000a850102860212" +
+	"c087400000006400000000000000650000000000000066000000000000006700000000000000680000000000000069000000000000006a" +
+	"000000000000006b0000000088020000"
+
+func decodeHex(t *testing.T, s string) []byte {
+	buf, err := hex.DecodeString(s)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return buf
+}
+
+func TestSVParse(t *testing.T) {
+	buf := decodeHex(t, svFrame92LE)
+	f, err := sv.ParseFrame(buf)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.True(t, f.HasVLAN)
+	assert.Equal(t, uint16(0x8000), f.VLAN)
+	assert.Equal(t, uint16(0x4000), f.APPID)
+	assert.Equal(t, 1, len(f.ASDUs))
+	a := f.ASDUs[0]
+	assert.Equal(t, "MU0101", a.SvID)
+	assert.Equal(t, uint16(3999), a.SmpCnt)
+	assert.Equal(t, uint32(1), a.ConfRev)
+	assert.Equal(t, uint8(2), a.SmpSynch)
+	assert.False(t, a.HasRefrTm)
+	assert.Equal(t, []int32{1234, -5678, 4444, 0, 6350000, -3175000, -3175000, 0}, a.Values)
+	assert.Equal(t, []uint32{0, 0, 0, 0x2000, 0, 0, 0, 0x2000}, a.Quality)
+	assert.Equal(t, buf, f.Encode())
+
+	buf = decodeHex(t, svFrame61869)
+	f, err = sv.ParseFrame(buf)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.False(t, f.HasVLAN)
+	assert.Equal(t, 2, len(f.ASDUs))
+	a = f.ASDUs[1]
+	assert.Equal(t, "MU0201", a.SvID)
+	assert.Equal(t, "MU02LD/LLN0$PhsMeas1", a.DatSet)
+	assert.Equal(t, uint16(1), a.SmpCnt)
+	assert.Equal(t, uint16(4800), a.SmpRate)
+	assert.True(t, a.HasSmpMod)
+	assert.Equal(t, uint64(1_700_000_000_500_000_000), a.Timestamp())
+	assert.Equal(t, []int32{100, 101, 102, 103, 104, 105, 106, 107}, a.Values)
+	assert.Equal(t, buf, f.Encode())
+
+	// invalid frames
+	_, err = sv.ParseFrame(buf[:len(buf)-1])
+	assert.ErrorIs(t, err, sv.ErrMalformed)
+	buf[12] = 0x08
+	_, err = sv.ParseFrame(buf)
+	assert.ErrorIs(t, err, sv.ErrNotSV)
+}
+
+func TestSVTranscode(t *testing.T) {
+	for _, refrTm := range []bool{false, true} {
+		test := tests["b4000-80"]
+		data := createCaptureData("b4000-80", 1_700_000_000_000_000_000)
+
+		emitter := &sv.Emitter{
+			Frame:         sv.Frame{APPID: 0x4000, HasVLAN: true, VLAN: 0x8000},
+			ASDU:          sv.ASDU{SvID: "MU0101", ConfRev: 1, SmpSynch: 2},
+			SamplingRate:  test.samplingRate,
+			IncludeRefrTm: refrTm,
+		}
+		frames, err := emitter.Emit(data)
+		assert.NoError(t, err)
+		assert.Equal(t, len(data), len(frames))
+
+		// transcode the SV frames to a Slipstream stream, with arrival times after some latency
+		var stream bytes.Buffer
+		enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+		w := slipstream.NewStreamWriter(&stream, enc)
+		ingester := sv.NewIngester(w, "MU0101", test.samplingRate, test.countOfVariables)
+		for i := range frames {
+			arrival := time.Unix(0, int64(data[i].T)).Add(3 * time.Millisecond)
+			if !assert.NoError(t, ingester.WriteFrame(frames[i], arrival)) {
+				t.FailNow()
+			}
+		}
+		assert.NoError(t, w.Close())
+
+		// reconstruct the SV frames from the decoded samples, with two ASDUs per frame
+		r := slipstream.NewStreamReader(&stream)
+		emitter.ASDUsPerFrame = 2
+		samples := 0
+		for r.Next() {
+			sample := r.Sample()
+			emitted, err := emitter.Emit([]slipstream.DatasetWithQuality{*sample})
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			for _, frame := range emitted {
+				f, err := sv.ParseFrame(frame)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				for _, a := range f.ASDUs {
+					original, _ := sv.ParseFrame(frames[samples])
+					if refrTm {
+						assert.Contains(t, []int64{-1, 0, 1}, int64(a.RefrTm>>8)-int64(original.ASDUs[0].RefrTm>>8))
+						a.RefrTm = original.ASDUs[0].RefrTm
+					}
+					assert.Equal(t, original.ASDUs[0], a)
+					samples++
+				}
+			}
+		}
+		assert.NoError(t, r.Err())
+		assert.Equal(t, len(data), samples)
+	}
+
+	// the dataset must match the number of variables
+	ingester := sv.NewIngester(nil, "MU0101", 4000, 4)
+	assert.ErrorIs(t, ingester.WriteFrame(decodeHex(t, svFrame92LE), time.Now()), sv.ErrVariableCount)
+	assert.NoError(t, ingester.WriteFrame(decodeHex(t, svFrame61869), time.Now()))
+
+	// each sample must have a quality value for each variable
+	emitter := &sv.Emitter{SamplingRate: 4000}
+	_, err := emitter.Emit([]slipstream.DatasetWithQuality{{Int32s: []int32{1, 2}, Q: []uint32{0}}})
+	assert.ErrorIs(t, err, sv.ErrVariableCount)
+}
@@ -0,0 +1,404 @@
+package slipstream_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/synaptecltd/slipstream"
+	"github.com/synaptecltd/slipstream/udp"
+)
+
+// the datagram header contains the sequence number and the frame type
+const (
+	udpHeaderSize  = 5
+	udpFrameParity = 2
+)
+
+func listenUDP(t *testing.T, dec *slipstream.Decoder) *udp.Receiver {
+	r, err := udp.Listen("127.0.0.1:0", dec)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, r.SetReadDeadline(time.Now().Add(5*time.Second)))
+	return r
+}
+
+func TestUDP(t *testing.T) {
+	test := tests["b4000-80"]
+	data := createInputData(createEmulator(test.samplingRate, 0), test.samples, test.countOfVariables, test.qualityChange)
+
+	r := listenUDP(t, nil)
+	defer r.Close()
+
+	enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	s, err := udp.Dial(r.LocalAddr().String(), enc)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	go func() {
+		for i := range data {
+			s.Write(&data[i])
+			if i%test.samplesPerMessage == 0 {
+				// avoid overflowing the socket receive buffer
+				time.Sleep(time.Millisecond)
+			}
+		}
+		s.Close()
+	}()
+
+	samples := 0
+	for samples < len(data) {
+		out, err := r.Receive()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		for i := range out {
+			assert.Equal(t, data[samples].Int32s, out[i].Int32s)
+			assert.Equal(t, data[samples].Q, out[i].Q)
+			samples++
+		}
+	}
+
+	stats := r.Stats()
+	assert.Equal(t, uint64(test.samples/test.samplesPerMessage), stats.Messages)
+	assert.Equal(t, uint64(test.samples), stats.Samples)
+	assert.Equal(t, udp.Stats{Messages: stats.Messages, Samples: stats.Samples}, stats)
+	assert.Equal(t, test.samplingRate, r.Decoder().SamplingRate)
+}
+
+func TestUDPParityUUID(t *testing.T) {
+	test := tests["c4800-2"]
+	data := createInputData(createEmulator(test.samplingRate, 0), 40, test.countOfVariables, test.qualityChange)
+
+	// messages from a stream with a UUID which starts with the same bytes as a parity frame are not mistaken for parity
+	r := listenUDP(t, nil)
+	defer r.Close()
+	id := uuid.UUID{'S', 'L', 'P', 'F', 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	enc := slipstream.NewEncoder(id, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	s, err := udp.Dial(r.LocalAddr().String(), enc)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, s.SetFEC(4))
+	for i := range data {
+		assert.NoError(t, s.Write(&data[i]))
+	}
+	assert.NoError(t, s.Close())
+
+	for i := 0; i < len(data); i += test.samplesPerMessage {
+		out, err := r.Receive()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, data[i].Int32s, out[0].Int32s)
+	}
+	assert.Equal(t, uint64(len(data)/test.samplesPerMessage), r.Stats().Messages)
+}
+
+// captureUDP sends samples using a Sender, and returns the datagrams
+func captureUDP(t *testing.T, data []slipstream.DatasetWithQuality, name string, fecGroupSize int) [][]byte {
+	test := tests[name]
+	capture, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer capture.Close()
+
+	enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	s, err := udp.Dial(capture.LocalAddr().String(), enc)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, s.SetFEC(fecGroupSize))
+	for i := range data {
+		assert.NoError(t, s.Write(&data[i]))
+	}
+	assert.NoError(t, s.Close())
+	assert.Equal(t, uint32(len(data)/test.samplesPerMessage), s.Sequence())
+
+	// the configuration, the messages, and a parity frame for each group
+	count := 1 + int(s.Sequence())
+	if fecGroupSize > 0 {
+		count += (int(s.Sequence()) + fecGroupSize - 1) / fecGroupSize
+	}
+	var datagrams [][]byte
+	for len(datagrams) < count {
+		buf := make([]byte, 2000)
+		capture.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := capture.ReadFrom(buf)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		datagrams = append(datagrams, buf[:n])
+	}
+	return datagrams
+}
+
+func TestUDPLoss(t *testing.T) {
+	test := tests["c4800-2"]
+	data := createInputData(createEmulator(test.samplingRate, 0), 40, test.countOfVariables, test.qualityChange)
+
+	datagrams := captureUDP(t, data, "c4800-2", 0)
+	// the sequence numbers should wrap around
+	for _, d := range datagrams {
+		binary.BigEndian.PutUint32(d, binary.BigEndian.Uint32(d)+0xfffffff8)
+	}
+
+	// replay the messages with one lost, one pair reordered and one duplicated
+	config, messages := datagrams[0], datagrams[1:]
+	replay := [][]byte{config}
+	order := []int{0, 1, 2, 4, 6, 5, 7, 8, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}
+	for _, i := range order {
+		replay = append(replay, messages[i])
+	}
+	// messages from before the configuration are ignored
+	replay = append([][]byte{messages[0]}, replay...)
+
+	r := listenUDP(t, nil)
+	defer r.Close()
+	conn, err := net.Dial("udp", r.LocalAddr().String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+	for _, d := range replay {
+		_, err := conn.Write(d)
+		assert.NoError(t, err)
+	}
+
+	received := append(append([]int(nil), order[:8]...), order[9:]...)
+	for _, i := range received {
+		out, err := r.Receive()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, data[i*test.samplesPerMessage].Int32s, out[0].Int32s)
+	}
+
+	stats := r.Stats()
+	assert.Equal(t, uint64(len(received)), stats.Messages)
+	assert.Equal(t, uint64(1), stats.Lost)
+	assert.Equal(t, uint64(1), stats.Reordered)
+	assert.Equal(t, uint64(1), stats.Duplicates)
+	assert.Equal(t, uint64(0), stats.Invalid)
+
+	// invalid datagrams are counted
+	next := binary.BigEndian.Uint32(messages[19]) + 1
+	invalid := []byte{0, 0, 0, 0, 0xff, 0xff}
+	binary.BigEndian.PutUint32(invalid, next)
+	valid := append([]byte(nil), messages[19]...)
+	binary.BigEndian.PutUint32(valid, next+1)
+	conn.Write([]byte{1, 2})
+	conn.Write(invalid)
+	conn.Write(valid)
+	_, err = r.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), r.Stats().Invalid)
+}
+
+func TestUDPFEC(t *testing.T) {
+	test := tests["c4800-2"]
+	data := createInputData(createEmulator(test.samplingRate, 0), 40, test.countOfVariables, test.qualityChange)
+	datagrams := captureUDP(t, data, "c4800-2", 4)
+	assert.Equal(t, 26, len(datagrams))
+
+	// lose one message in the first group, two in the second group, and the last message of the fourth group
+	lost := map[int]bool{1: true, 5: true, 6: true, 15: true}
+	r := listenUDP(t, nil)
+	defer r.Close()
+	conn, err := net.Dial("udp", r.LocalAddr().String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+	for _, d := range datagrams {
+		frame := d[udpHeaderSize:]
+		if bytes.Equal(frame[:16], ID[:]) && lost[int(binary.BigEndian.Uint32(d))] {
+			continue
+		}
+		_, err := conn.Write(d)
+		assert.NoError(t, err)
+	}
+
+	// all messages are received apart from the second group
+	received := map[int]bool{}
+	for len(received) < 18 {
+		out, err := r.Receive()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		for i := range data {
+			if assert.ObjectsAreEqual(data[i].Int32s, out[0].Int32s) {
+				received[i/test.samplesPerMessage] = true
+				for j := range out {
+					assert.Equal(t, data[i+j].Int32s, out[j].Int32s)
+					assert.Equal(t, data[i+j].Q, out[j].Q)
+				}
+				break
+			}
+		}
+	}
+	assert.False(t, received[5])
+	assert.False(t, received[6])
+
+	stats := r.Stats()
+	assert.Equal(t, uint64(18), stats.Messages)
+	assert.Equal(t, uint64(2), stats.Recovered)
+	assert.Equal(t, uint64(2), stats.Lost)
+	assert.Equal(t, uint64(0), stats.Reordered)
+	assert.Equal(t, uint64(0), stats.Invalid)
+
+	enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	s := udp.NewSender(conn, enc)
+	assert.ErrorIs(t, s.SetFEC(udp.MaxFECGroupSize+1), udp.ErrInvalidFECGroupSize)
+}
+
+func TestUDPRestart(t *testing.T) {
+	test := tests["c4800-2"]
+	data := createInputData(createEmulator(test.samplingRate, 0), 140, test.countOfVariables, test.qualityChange)
+	datagrams := captureUDP(t, data, "c4800-2", 4)
+
+	// the sender restarts after 70 messages, which is more than the duplicate window, and reuses the sequence numbers
+	// of the first run. The first 12 messages are sent again, with one lost.
+	replay := append([][]byte(nil), datagrams...)
+	parity := 0
+	for _, d := range datagrams {
+		replay = append(replay, d)
+		if d[4] == udpFrameParity {
+			if parity++; parity == 3 {
+				break
+			}
+		}
+	}
+	lost := 0
+	for i := len(datagrams); i < len(replay); i++ {
+		if bytes.Equal(replay[i][udpHeaderSize:udpHeaderSize+16], ID[:]) && binary.BigEndian.Uint32(replay[i]) == 5 {
+			lost = i
+		}
+	}
+	replay = append(replay[:lost], replay[lost+1:]...)
+
+	r := listenUDP(t, nil)
+	defer r.Close()
+	conn, err := net.Dial("udp", r.LocalAddr().String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+	go func() {
+		for i, d := range replay {
+			conn.Write(d)
+			if i%10 == 0 {
+				// avoid overflowing the socket receive buffer
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	// the lost message is recovered after the last message of its group
+	var order []int
+	for i := 0; i < 70; i++ {
+		order = append(order, i)
+	}
+	order = append(order, 0, 1, 2, 3, 4, 6, 7, 5, 8, 9, 10, 11)
+	for _, i := range order {
+		out, err := r.Receive()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, data[i*test.samplesPerMessage].Int32s, out[0].Int32s)
+	}
+
+	// the lost message is recovered using only the messages received after the restart
+	stats := r.Stats()
+	assert.Equal(t, uint64(82), stats.Messages)
+	assert.Equal(t, uint64(1), stats.Restarts)
+	assert.Equal(t, uint64(1), stats.Recovered)
+	assert.Equal(t, uint64(0), stats.Lost)
+	assert.Equal(t, uint64(0), stats.Reordered)
+	assert.Equal(t, uint64(0), stats.Duplicates)
+}
+
+// failingConn is a connection which fails one write
+type failingConn struct {
+	net.Conn
+	writes int
+	failAt int
+}
+
+func (c *failingConn) Write(b []byte) (int, error) {
+	c.writes++
+	if c.writes-1 == c.failAt {
+		return 0, net.ErrClosed
+	}
+	return c.Conn.Write(b)
+}
+
+func TestUDPFECWriteError(t *testing.T) {
+	test := tests["c4800-2"]
+	data := createInputData(createEmulator(test.samplingRate, 0), 16, test.countOfVariables, test.qualityChange)
+	capture, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer capture.Close()
+	conn, err := net.Dial("udp", capture.LocalAddr().String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// the third message, after the configuration, is not sent
+	enc := slipstream.NewEncoder(ID, test.countOfVariables, test.samplingRate, test.samplesPerMessage)
+	s := udp.NewSender(&failingConn{Conn: conn, failAt: 3}, enc)
+	assert.NoError(t, s.SetFEC(4))
+	failed := 0
+	for i := range data {
+		if s.Write(&data[i]) != nil {
+			failed++
+		}
+	}
+	assert.NoError(t, s.Close())
+	assert.Equal(t, 1, failed)
+
+	// the configuration, seven messages, and parity frames for messages 0-1, 3-6 and 7
+	var datagrams [][]byte
+	for len(datagrams) < 11 {
+		buf := make([]byte, 2000)
+		capture.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := capture.ReadFrom(buf)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		datagrams = append(datagrams, buf[:n])
+	}
+
+	// the second message is lost, and is reconstructed from the parity of the messages which were sent
+	r := listenUDP(t, nil)
+	defer r.Close()
+	replay, err := net.Dial("udp", r.LocalAddr().String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer replay.Close()
+	for _, d := range datagrams {
+		if bytes.Equal(d[udpHeaderSize:udpHeaderSize+16], ID[:]) && binary.BigEndian.Uint32(d) == 1 {
+			continue
+		}
+		replay.Write(d)
+	}
+	for _, i := range []int{0, 1, 3, 4, 5, 6, 7} {
+		out, err := r.Receive()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, data[i*test.samplesPerMessage].Int32s, out[0].Int32s)
+	}
+	stats := r.Stats()
+	assert.Equal(t, uint64(1), stats.Recovered)
+	assert.Equal(t, uint64(1), stats.Lost)
+}
@@ -0,0 +1,165 @@
+package udp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Forward error correction (FEC) uses XOR parity over groups of consecutive messages. After each group, the sender
+// sends a parity frame, which is identified by the frame type in the datagram header:
+//
+//	sequence number of the first message (uint32) | message count (byte) | uvarint length of each message |
+//	XOR of all messages, each padded with zeros to the length of the longest message
+//
+// If exactly one message of a group is lost, the receiver reconstructs it from the parity frame and the other
+// messages. Parity frames add one datagram per group, so smaller groups tolerate more loss at a higher overhead.
+
+// MaxFECGroupSize is the largest number of messages protected by each parity frame
+const MaxFECGroupSize = 255
+
+// historySize is the number of recent messages kept by the receiver for reconstruction, which must be larger than the
+// group size
+const historySize = 512
+
+// ErrInvalidFECGroupSize is returned for an FEC group size which is out of range
+var ErrInvalidFECGroupSize = errors.New("invalid FEC group size")
+
+// errMalformedParity is used internally for parity frames which cannot be parsed
+var errMalformedParity = errors.New("malformed parity frame")
+
+// parityEncoder accumulates the parity of a group of messages
+type parityEncoder struct {
+	groupSize int
+	first     uint32
+	lengths   []int
+	parity    []byte
+	frame     []byte
+}
+
+// add includes a message in the parity, returning a parity frame if the group is complete
+func (p *parityEncoder) add(sequence uint32, msg []byte) []byte {
+	if len(p.lengths) == 0 {
+		p.first = sequence
+		p.parity = p.parity[:0]
+	}
+	for len(p.parity) < len(msg) {
+		p.parity = append(p.parity, 0)
+	}
+	for i := range msg {
+		p.parity[i] ^= msg[i]
+	}
+	p.lengths = append(p.lengths, len(msg))
+
+	if len(p.lengths) >= p.groupSize {
+		return p.flush()
+	}
+	return nil
+}
+
+// flush returns a parity frame for any messages in the current group
+func (p *parityEncoder) flush() []byte {
+	if len(p.lengths) == 0 {
+		return nil
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	binary.BigEndian.PutUint32(buf[:], p.first)
+	p.frame = append(p.frame[:0], buf[:4]...)
+	p.frame = append(p.frame, byte(len(p.lengths)))
+	for _, length := range p.lengths {
+		n := binary.PutUvarint(buf[:], uint64(length))
+		p.frame = append(p.frame, buf[:n]...)
+	}
+	p.frame = append(p.frame, p.parity...)
+
+	p.lengths = p.lengths[:0]
+	return p.frame
+}
+
+// decodeParity decodes a parity frame, returning the first sequence number, the message lengths, and the parity
+func decodeParity(frame []byte, lengths []int) (uint32, []int, []byte, error) {
+	if len(frame) < 5 {
+		return 0, nil, nil, errMalformedParity
+	}
+	first := binary.BigEndian.Uint32(frame)
+	count := int(frame[4])
+	pos := 5
+
+	lengths = lengths[:0]
+	for i := 0; i < count; i++ {
+		length, n := binary.Uvarint(frame[pos:])
+		if n <= 0 || length > maxDatagramSize {
+			return 0, nil, nil, errMalformedParity
+		}
+		lengths = append(lengths, int(length))
+		pos += n
+	}
+
+	parity := frame[pos:]
+	for _, length := range lengths {
+		if length > len(parity) {
+			return 0, nil, nil, errMalformedParity
+		}
+	}
+	return first, lengths, parity, nil
+}
+
+// historyEntry is a recent message kept for reconstruction
+type historyEntry struct {
+	sequence uint32
+	valid    bool
+	frame    []byte
+}
+
+// remember keeps a copy of a message for reconstruction
+func (r *Receiver) remember(sequence uint32, frame []byte) {
+	entry := &r.history[sequence%historySize]
+	entry.sequence = sequence
+	entry.valid = true
+	entry.frame = append(entry.frame[:0], frame...)
+}
+
+// recall returns a recent message, or nil if it has not been received
+func (r *Receiver) recall(sequence uint32) []byte {
+	entry := &r.history[sequence%historySize]
+	if !entry.valid || entry.sequence != sequence {
+		return nil
+	}
+	return entry.frame
+}
+
+// reconstruct uses a parity frame to reconstruct a lost message, returning its sequence number and contents
+func (r *Receiver) reconstruct(frame []byte) (uint32, []byte, bool) {
+	first, lengths, parity, err := decodeParity(frame, r.lengths)
+	r.lengths = lengths
+	if err != nil {
+		r.stats.Invalid++
+		return 0, nil, false
+	}
+
+	// find the missing message, which can only be reconstructed if no other messages are missing
+	missing := -1
+	for i := range lengths {
+		if r.recall(first+uint32(i)) == nil {
+			if missing >= 0 {
+				return 0, nil, false
+			}
+			missing = i
+		}
+	}
+	if missing < 0 {
+		return 0, nil, false
+	}
+
+	r.recovered = append(r.recovered[:0], parity[:lengths[missing]]...)
+	for i := range lengths {
+		if i == missing {
+			continue
+		}
+		msg := r.recall(first + uint32(i))
+		for j := 0; j < len(msg) && j < len(r.recovered); j++ {
+			r.recovered[j] ^= msg[j]
+		}
+	}
+	return first + uint32(missing), r.recovered, true
+}
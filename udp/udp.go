@@ -0,0 +1,375 @@
+// Package udp sends and receives Slipstream messages over UDP.
+//
+// Each datagram contains a 32-bit big-endian sequence number and a frame type byte, followed by a Slipstream message,
+// configuration frame, or (optionally) a forward error correction parity frame. The sequence number is incremented for
+// each message, so that the receiver can detect lost, duplicated and reordered messages. Configuration and parity
+// frames use the sequence number of the next message, and are not included in the statistics.
+package udp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/synaptecltd/slipstream"
+)
+
+// headerSize is the size of the sequence number and frame type at the start of each datagram
+const headerSize = 5
+
+// frame types, which identify the contents of each datagram without depending on the frame itself, because a message
+// can start with any stream UUID
+const (
+	frameMessage = 0
+	frameConfig  = 1
+	frameParity  = 2
+)
+
+// maxDatagramSize is the largest possible UDP payload
+const maxDatagramSize = 65535
+
+// windowSize is the number of recent sequence numbers which are tracked to detect duplicates
+const windowSize = 64
+
+// Sender encodes samples and sends each message as a UDP datagram
+type Sender struct {
+	conn        net.Conn
+	enc         *slipstream.Encoder
+	sequence    uint32
+	wroteConfig bool
+	buf         []byte
+	fec         *parityEncoder
+}
+
+// NewSender creates a Sender which uses the given encoder. The connection must be a connected UDP socket, such as
+// from net.Dial("udp", address). The configuration of the encoder is sent before the first message.
+func NewSender(conn net.Conn, enc *slipstream.Encoder) *Sender {
+	// messages are encoded directly into the buffer, which must allow for the largest possible message
+	size := maxDatagramSize
+	if headerSize+enc.MaxMessageSize() > size {
+		size = headerSize + enc.MaxMessageSize()
+	}
+	return &Sender{conn: conn, enc: enc, buf: make([]byte, size)}
+}
+
+// Dial creates a Sender which sends to the given address
+func Dial(address string, enc *slipstream.Encoder) (*Sender, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewSender(conn, enc), nil
+}
+
+// Write encodes the next sample, and sends a message when it is complete
+func (s *Sender) Write(data *slipstream.DatasetWithQuality) error {
+	if !s.wroteConfig {
+		if err := s.SendConfig(); err != nil {
+			return err
+		}
+	}
+
+	// the message is encoded directly after the header
+	s.putHeader(s.sequence, frameMessage)
+	return s.sendMessage(s.enc.EncodeTo(s.buf[:headerSize], data))
+}
+
+// Flush sends any partially encoded message, and the parity frame for any incomplete group of messages
+func (s *Sender) Flush() error {
+	s.putHeader(s.sequence, frameMessage)
+	if err := s.sendMessage(s.enc.AppendMessage(s.buf[:headerSize])); err != nil {
+		return err
+	}
+	if s.fec != nil {
+		if parity := s.fec.flush(); parity != nil {
+			return s.send(s.sequence, frameParity, parity)
+		}
+	}
+	return nil
+}
+
+// SetFEC enables forward error correction, by sending a parity frame after each group of messages, so that the
+// receiver can reconstruct any one lost message in each group. A group size of zero disables forward error correction.
+func (s *Sender) SetFEC(groupSize int) error {
+	if groupSize < 0 || groupSize > MaxFECGroupSize {
+		return ErrInvalidFECGroupSize
+	}
+	if groupSize == 0 {
+		s.fec = nil
+		return nil
+	}
+	s.fec = &parityEncoder{groupSize: groupSize}
+	return nil
+}
+
+// SendConfig sends the configuration of the encoder. It can be called periodically, so that receivers can join at any
+// time.
+func (s *Sender) SendConfig() error {
+	s.wroteConfig = true
+	return s.send(s.sequence, frameConfig, s.enc.EncodeConfig())
+}
+
+// Sequence returns the sequence number of the next message
+func (s *Sender) Sequence() uint32 {
+	return s.sequence
+}
+
+// Close flushes any partially encoded message, and closes the connection
+func (s *Sender) Close() error {
+	err := s.Flush()
+	if closeErr := s.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sendMessage sends a datagram containing the header and an encoded message, if a message is present
+func (s *Sender) sendMessage(datagram []byte) error {
+	if len(datagram) == headerSize {
+		return nil
+	}
+	if len(datagram) > maxDatagramSize {
+		return slipstream.ErrFrameTooLarge
+	}
+
+	_, err := s.conn.Write(datagram)
+	var parity []byte
+	if s.fec != nil {
+		if err == nil {
+			parity = s.fec.add(s.sequence, datagram[headerSize:])
+		} else {
+			// a message which was not sent cannot be included in the parity, so the group ends at the previous message
+			parity = s.fec.flush()
+		}
+	}
+	s.sequence++
+	if parity != nil {
+		if sendErr := s.send(s.sequence, frameParity, parity); err == nil {
+			err = sendErr
+		}
+	}
+	return err
+}
+
+func (s *Sender) send(sequence uint32, frameType byte, frame []byte) error {
+	if headerSize+len(frame) > maxDatagramSize {
+		return slipstream.ErrFrameTooLarge
+	}
+	s.putHeader(sequence, frameType)
+	n := copy(s.buf[headerSize:], frame)
+	_, err := s.conn.Write(s.buf[:headerSize+n])
+	return err
+}
+
+// putHeader writes the datagram header to the start of the buffer
+func (s *Sender) putHeader(sequence uint32, frameType byte) {
+	binary.BigEndian.PutUint32(s.buf, sequence)
+	s.buf[4] = frameType
+}
+
+// Stats are the statistics of a Receiver. Messages which arrive after later messages are counted as reordered, and
+// messages which are reconstructed using forward error correction are counted as recovered. Neither are counted as
+// lost. A sequence number which is too far behind the most recent message to be a duplicate or reordered message is
+// counted as a restart of the sender, and the receiver resynchronises to the new sequence numbers.
+type Stats struct {
+	Messages   uint64 // messages decoded successfully, including recovered messages
+	Samples    uint64 // samples decoded successfully
+	Lost       uint64 // gaps in the sequence numbers
+	Duplicates uint64
+	Reordered  uint64
+	Recovered  uint64
+	Restarts   uint64
+	Invalid    uint64 // datagrams which could not be decoded, or which do not match the decoder ID
+}
+
+// Receiver receives UDP datagrams and decodes the messages
+type Receiver struct {
+	conn     net.PacketConn
+	dec      *slipstream.Decoder
+	config   []byte // the most recent configuration frame
+	buf      []byte
+	started  bool
+	next     uint32 // the next expected sequence number
+	received uint64 // bit i is set if message next-1-i has been received
+	stats    Stats
+	mutex    sync.Mutex
+
+	// recent messages and buffers for forward error correction
+	history   [historySize]historyEntry
+	lengths   []int
+	recovered []byte
+}
+
+// NewReceiver creates a Receiver for the given connection. If the decoder is nil, messages are ignored until a
+// configuration frame is received.
+func NewReceiver(conn net.PacketConn, dec *slipstream.Decoder) *Receiver {
+	return &Receiver{conn: conn, dec: dec, buf: make([]byte, maxDatagramSize)}
+}
+
+// Listen creates a Receiver which listens on the given address
+func Listen(address string, dec *slipstream.Decoder) (*Receiver, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewReceiver(conn, dec), nil
+}
+
+// Receive waits for the next message, and returns the decoded samples. The samples are only valid until the next call
+// to Receive(). Duplicated and invalid datagrams are counted in the statistics, and are otherwise ignored.
+func (r *Receiver) Receive() ([]slipstream.DatasetWithQuality, error) {
+	for {
+		n, _, err := r.conn.ReadFrom(r.buf)
+		if err != nil {
+			return nil, err
+		}
+		if samples := r.decode(r.buf[:n]); samples != nil {
+			return samples, nil
+		}
+	}
+}
+
+// decode decodes a datagram, returning nil if there are no new samples
+func (r *Receiver) decode(datagram []byte) []slipstream.DatasetWithQuality {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(datagram) < headerSize {
+		r.stats.Invalid++
+		return nil
+	}
+	sequence := binary.BigEndian.Uint32(datagram)
+	frameType := datagram[4]
+	frame := datagram[headerSize:]
+
+	// the configuration is sent periodically, and the decoder is only replaced if it changes
+	if frameType == frameConfig {
+		if bytes.Equal(frame, r.config) {
+			return nil
+		}
+		dec, err := slipstream.NewDecoderFromConfig(frame)
+		if err != nil {
+			r.stats.Invalid++
+			return nil
+		}
+		r.dec = dec
+		r.config = append(r.config[:0], frame...)
+		return nil
+	}
+	if frameType != frameMessage && frameType != frameParity {
+		r.stats.Invalid++
+		return nil
+	}
+	if r.dec == nil {
+		return nil
+	}
+
+	recovered := false
+	if frameType == frameParity {
+		if sequence, frame, recovered = r.reconstruct(frame); !recovered {
+			return nil
+		}
+	}
+
+	if !r.track(sequence, recovered) {
+		return nil
+	}
+	r.remember(sequence, frame)
+	samples, err := r.dec.DecodeToBuffer(frame, len(frame))
+	if err != nil {
+		r.stats.Invalid++
+		return nil
+	}
+	r.stats.Messages++
+	r.stats.Samples += uint64(samples)
+	return r.dec.Out[:samples]
+}
+
+// track updates the statistics for a received or recovered message, returning false if the message is a duplicate
+func (r *Receiver) track(sequence uint32, recovered bool) bool {
+	if !r.started {
+		r.started = true
+		r.next = sequence
+	}
+
+	diff := int32(sequence - r.next)
+	if diff >= 0 {
+		// a new message, which may follow a gap
+		r.stats.Lost += uint64(diff)
+		if diff >= windowSize-1 {
+			r.received = 1
+		} else {
+			r.received = r.received<<(diff+1) | 1
+		}
+		r.next = sequence + 1
+		if recovered {
+			r.stats.Recovered++
+		}
+		return true
+	}
+
+	// a message from before the most recent message, which is either a duplicate or was previously counted as lost
+	age := -diff - 1
+	if age >= windowSize && !recovered {
+		r.resync(sequence)
+		return true
+	}
+	if age < windowSize {
+		if r.received&(1<<age) != 0 {
+			r.stats.Duplicates++
+			return false
+		}
+		r.received |= 1 << age
+	}
+	if recovered {
+		r.stats.Recovered++
+	} else {
+		r.stats.Reordered++
+	}
+	if r.stats.Lost > 0 {
+		r.stats.Lost--
+	}
+	return true
+}
+
+// resync restarts sequence number tracking from a received message, after the sender has restarted. Messages in the
+// FEC history are discarded, because the sender reuses their sequence numbers.
+func (r *Receiver) resync(sequence uint32) {
+	r.stats.Restarts++
+	r.next = sequence + 1
+	r.received = 1
+	for i := range r.history {
+		r.history[i].valid = false
+	}
+}
+
+// Stats returns the statistics
+func (r *Receiver) Stats() Stats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stats
+}
+
+// Decoder returns the decoder, which is nil until a configuration frame has been received if no decoder was provided
+func (r *Receiver) Decoder() *slipstream.Decoder {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.dec
+}
+
+// LocalAddr returns the local address of the connection
+func (r *Receiver) LocalAddr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
+// SetReadDeadline sets the deadline for Receive()
+func (r *Receiver) SetReadDeadline(t time.Time) error {
+	return r.conn.SetReadDeadline(t)
+}
+
+// Close closes the connection
+func (r *Receiver) Close() error {
+	return r.conn.Close()
+}
@@ -0,0 +1,63 @@
+package slipstream
+
+// MaxDeltaEncodingLayers is the maximum number of layers of delta encoding for a variable
+const MaxDeltaEncodingLayers = 7
+
+// VariableEncoding defines how an integer variable (of type int32, int16 or int64) is encoded. Floating-point
+// variables always use XOR encoding.
+type VariableEncoding struct {
+	DeltaEncodingLayers int  // layers of delta encoding, from 1 to MaxDeltaEncodingLayers
+	UseXOR              bool // use XOR delta instead of arithmetic delta
+	Raw                 bool // encode each value as a varint, without delta encoding
+	ElideConstant       bool // encode the value only once if it is the same for every sample in a message
+}
+
+// The encoding of each variable is described by a single byte, both in configuration frames and in the column
+// descriptors of messages with FlagColumnDescriptors set. The lowest three bits are the number of delta encoding
+// layers (with zero meaning raw values), followed by the XOR bit and the constant bit. In configuration frames, the
+// constant bit means that constant values may be elided. In messages, it means that the value has been elided, so
+// that the value is encoded once after the column descriptors rather than for every sample.
+const (
+	descriptorLayersMask byte = 0x07
+	descriptorXOR        byte = 0x08
+	descriptorConstant   byte = 0x10
+)
+
+// layers returns the number of delta encoding layers actually used, where zero means raw values
+func (e VariableEncoding) layers() int {
+	if e.Raw {
+		return 0
+	}
+	return e.DeltaEncodingLayers
+}
+
+func (e VariableEncoding) valid() bool {
+	return e.Raw || (e.DeltaEncodingLayers >= 1 && e.DeltaEncodingLayers <= MaxDeltaEncodingLayers)
+}
+
+// descriptor returns the byte which describes the encoding
+func (e VariableEncoding) descriptor(constant bool) byte {
+	d := byte(e.layers()) & descriptorLayersMask
+	if e.UseXOR {
+		d |= descriptorXOR
+	}
+	if constant {
+		d |= descriptorConstant
+	}
+	return d
+}
+
+// variableEncodingFromDescriptor reverses VariableEncoding.descriptor()
+func variableEncodingFromDescriptor(d byte) (VariableEncoding, bool, error) {
+	if d&^(descriptorLayersMask|descriptorXOR|descriptorConstant) != 0 {
+		return VariableEncoding{}, false, ErrInvalidDescriptor
+	}
+
+	layers := int(d & descriptorLayersMask)
+	e := VariableEncoding{
+		DeltaEncodingLayers: layers,
+		UseXOR:              d&descriptorXOR != 0,
+		Raw:                 layers == 0,
+	}
+	return e, d&descriptorConstant != 0, nil
+}